@@ -0,0 +1,179 @@
+package main
+
+import "C"
+import (
+	"reflect"
+	"slices"
+	"sync/atomic"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
+	R "github.com/sagernet/sing-box/route/rule"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+type appProxyResult struct {
+	UID     int32 `json:"uid"`
+	Proxied bool  `json:"proxied"`
+	// Rules are the indexes of the route rules whose user_id list changed.
+	Rules []int `json:"rules"`
+}
+
+// LibboxSetAppProxy moves the app running as uid in or out of the proxy
+// without restarting the instance. The config must route apps by user_id:
+// a rule sending its UIDs to a direct outbound (or bypassing) is an exclude
+// list, a rule routing them to any other outbound is an include list, and the
+// UID is added to or removed from every such rule so it ends up proxied when
+// proxied is non-zero and direct otherwise. Only top-level, non-inverted
+// rules are changed, and the change lasts until the instance is restarted.
+// Only connections routed after the change are affected; one being routed
+// meanwhile sees either the old or the new list.
+//
+//export LibboxSetAppProxy
+func LibboxSetAppProxy(uid C.int, proxied C.int) *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		return jsonError("service not running")
+	}
+	result := appProxyResult{
+		UID:     int32(uid),
+		Proxied: proxied != 0,
+		Rules:   []int{},
+	}
+	var found bool
+	for index, rule := range instance.Router().Rules() {
+		defaultRule, isDefault := rule.(*R.DefaultRule)
+		if !isDefault {
+			continue
+		}
+		if invert := unexportedField(reflect.ValueOf(defaultRule), "invert"); !invert.IsValid() || invert.Kind() != reflect.Bool || invert.Bool() {
+			continue
+		}
+		var outboundTag string
+		switch action := rule.Action().(type) {
+		case *R.RuleActionRoute:
+			outboundTag = action.Outbound
+		case *R.RuleActionBypass:
+			outboundTag = action.Outbound
+		default:
+			continue
+		}
+		includes := true
+		if _, isBypass := rule.Action().(*R.RuleActionBypass); isBypass {
+			includes = false
+		} else if out, loaded := instance.Outbound().Outbound(outboundTag); loaded && out.Type() == constant.TypeDirect {
+			includes = false
+		}
+		changed, matched := setRuleUserID(defaultRule, result.UID, includes == result.Proxied)
+		found = found || matched
+		if changed {
+			result.Rules = append(result.Rules, index)
+		}
+	}
+	if !found {
+		return jsonError("no route rule matches by user_id, per-app routing is not configured")
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// appUserIDItem stands in for the user_id condition of a top-level route
+// rule, so that LibboxSetAppProxy can change its UIDs while connections are
+// routed: the router only reads the rule's items, and the list behind this
+// one is replaced as a whole and published atomically.
+type appUserIDItem struct {
+	current atomic.Pointer[appUserIDs]
+}
+
+type appUserIDs struct {
+	userIDs []int32
+	item    *R.UserIdItem
+}
+
+func newAppUserIDItem(userIDs []int32) *appUserIDItem {
+	item := &appUserIDItem{}
+	item.set(userIDs)
+	return item
+}
+
+func (i *appUserIDItem) set(userIDs []int32) {
+	i.current.Store(&appUserIDs{userIDs: userIDs, item: R.NewUserIDItem(userIDs)})
+}
+
+func (i *appUserIDItem) Match(metadata *adapter.InboundContext) bool {
+	return i.current.Load().item.Match(metadata)
+}
+
+func (i *appUserIDItem) String() string {
+	return i.current.Load().item.String()
+}
+
+// installAppUserIDs swaps the user_id conditions of the top-level route
+// rules for appUserIDItems. It must run after the instance is created and
+// before it starts, while nothing routes through it yet.
+func installAppUserIDs(router adapter.Router) {
+	itemsType := reflect.TypeOf([]R.RuleItem(nil))
+	for _, rule := range router.Rules() {
+		defaultRule, isDefault := rule.(*R.DefaultRule)
+		if !isDefault {
+			continue
+		}
+		items := unexportedField(reflect.ValueOf(defaultRule), "items")
+		allItems := unexportedField(reflect.ValueOf(defaultRule), "allItems")
+		if !items.IsValid() || items.Type() != itemsType || !allItems.IsValid() || allItems.Type() != itemsType {
+			continue
+		}
+		for index, item := range items.Interface().([]R.RuleItem) {
+			userIDItem, isUserID := item.(*R.UserIdItem)
+			if !isUserID {
+				continue
+			}
+			userIDs := unexportedField(reflect.ValueOf(userIDItem), "userIds")
+			if !userIDs.IsValid() || userIDs.Type() != reflect.TypeOf([]int32(nil)) {
+				continue
+			}
+			replacement := R.RuleItem(newAppUserIDItem(slices.Clone(userIDs.Interface().([]int32))))
+			items.Index(index).Set(reflect.ValueOf(&replacement).Elem())
+			for allIndex, allItem := range allItems.Interface().([]R.RuleItem) {
+				if allItem == item {
+					allItems.Index(allIndex).Set(reflect.ValueOf(&replacement).Elem())
+				}
+			}
+		}
+	}
+}
+
+// setRuleUserID adds uid to or removes it from the user_id condition of
+// rule, reporting whether the list changed and whether the rule has one
+// installAppUserIDs made changeable.
+func setRuleUserID(rule *R.DefaultRule, uid int32, member bool) (changed bool, matched bool) {
+	items := unexportedField(reflect.ValueOf(rule), "items")
+	if !items.IsValid() || items.Type() != reflect.TypeOf([]R.RuleItem(nil)) {
+		return false, false
+	}
+	for _, item := range items.Interface().([]R.RuleItem) {
+		userIDItem, isUserID := item.(*appUserIDItem)
+		if !isUserID {
+			continue
+		}
+		userIDs := userIDItem.current.Load().userIDs
+		if slices.Contains(userIDs, uid) == member {
+			return false, true
+		}
+		if member {
+			userIDs = append(slices.Clone(userIDs), uid)
+		} else {
+			userIDs = slices.DeleteFunc(slices.Clone(userIDs), func(it int32) bool {
+				return it == uid
+			})
+		}
+		userIDItem.set(userIDs)
+		return true, true
+	}
+	return false, false
+}
@@ -0,0 +1,115 @@
+package main
+
+// #include "callback.h"
+import "C"
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// autoTestTimeout bounds each round of the auto test; rounds closer together
+// get the interval instead.
+const autoTestTimeout = 5 * time.Second
+
+var (
+	autoTestSink   = newCallbackSink(4)
+	autoTestAccess sync.Mutex
+	autoTest       *autoTestRun
+)
+
+type autoTestRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// ownsHarness is set when the run opened the test harness itself and
+	// closes it again on stop.
+	ownsHarness bool
+}
+
+// LibboxStartAutoTest runs LibboxTestBatch on outboundsJSON and targetURL
+// right away and then every intervalMS, passing each round's result, in
+// LibboxTestBatch's format, to callback. Rounds run in the persistent test
+// harness, which is opened for the auto test when it isn't already. Only one
+// auto test runs at a time; stop it with LibboxStopAutoTest, e.g. when the
+// app goes to the background.
+//
+//export LibboxStartAutoTest
+func LibboxStartAutoTest(outboundsJSON *C.char, targetURL *C.char, intervalMS C.longlong, callback C.libbox_callback_t) *C.char {
+	interval := time.Duration(intervalMS) * time.Millisecond
+	if interval <= 0 {
+		return C.CString("interval must be positive")
+	}
+	if callback == nil {
+		return C.CString("callback is required")
+	}
+	configStr := C.GoString(outboundsJSON)
+	targetStr := C.GoString(targetURL)
+
+	autoTestAccess.Lock()
+	defer autoTestAccess.Unlock()
+
+	if autoTest != nil {
+		return C.CString("auto test already running")
+	}
+	run := &autoTestRun{done: make(chan struct{})}
+	harnessMu.Lock()
+	if harness == nil {
+		if err := openTestHarness(currentLogLevel); err != nil {
+			harnessMu.Unlock()
+			return C.CString(err.Error())
+		}
+		run.ownsHarness = true
+	}
+	harnessMu.Unlock()
+
+	var ctx context.Context
+	ctx, run.cancel = context.WithCancel(context.Background())
+	autoTestSink.set(callback)
+	autoTest = run
+	go run.loop(ctx, configStr, targetStr, interval)
+	return nil
+}
+
+// LibboxStopAutoTest stops the auto test and waits for a round in progress
+// to finish. No results are delivered after it returns.
+//
+//export LibboxStopAutoTest
+func LibboxStopAutoTest() *C.char {
+	autoTestAccess.Lock()
+	defer autoTestAccess.Unlock()
+
+	if autoTest == nil {
+		return C.CString("auto test not running")
+	}
+	autoTest.cancel()
+	<-autoTest.done
+	autoTestSink.set(nil)
+	if autoTest.ownsHarness {
+		harnessMu.Lock()
+		if harness != nil {
+			closeTestHarness()
+		}
+		harnessMu.Unlock()
+	}
+	autoTest = nil
+	return nil
+}
+
+func (r *autoTestRun) loop(ctx context.Context, configStr string, targetStr string, interval time.Duration) {
+	defer close(r.done)
+	timeout := min(interval, autoTestTimeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result := testBatch(configStr, targetStr, timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		autoTestSink.post(result)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
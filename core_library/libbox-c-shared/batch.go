@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// batchResultOptions are the optional fields of the LibboxTestBatch wrapper
+// that shape its result. Without any of them the result stays a plain
+// tag→latency object.
+type batchResultOptions struct {
+	// Sort orders the results; "latency" is the only supported value and
+	// sorts ascending.
+	Sort string `json:"sort,omitempty"`
+	// MaxLatencyMs drops results slower than this when positive.
+	MaxLatencyMs uint16 `json:"maxLatencyMs,omitempty"`
+	// Baseline also measures the target over the direct outbound and reports
+	// it as "direct", so proxy overhead can be told apart from a slow link.
+	Baseline bool `json:"baseline,omitempty"`
+}
+
+// directBaselineTag is the key the direct baseline is reported under. It is
+// also the tag testBoxConfig gives the direct outbound, so no node uses it.
+const directBaselineTag = "direct"
+
+// batchTagsKey is the key the tags outbounds were tested as are listed
+// under, by input index. No node uses it either.
+const batchTagsKey = "tags"
+
+type batchResult struct {
+	Tag string `json:"tag"`
+	// Index is the position of the outbound in the input.
+	Index     int    `json:"index"`
+	LatencyMs uint16 `json:"latencyMs"`
+	// RelativeMs is LatencyMs minus the direct baseline, when measured.
+	RelativeMs *int `json:"relativeMs,omitempty"`
+}
+
+type batchResults struct {
+	Results []batchResult `json:"results"`
+	Best    string        `json:"best,omitempty"`
+	Direct  *uint16       `json:"direct,omitempty"`
+	Tags    []string      `json:"tags"`
+}
+
+func (o batchResultOptions) isDefault() bool {
+	return o.Sort == "" && o.MaxLatencyMs == 0
+}
+
+// measureBaseline URL-tests the targets over the first direct outbound of the
+// manager.
+func measureBaseline(ctx context.Context, manager adapter.OutboundManager, targets []string) *uint16 {
+	for _, out := range manager.Outbounds() {
+		if out.Type() != constant.TypeDirect {
+			continue
+		}
+		latency, err := urlTestTargets(ctx, targets, out)
+		if err != nil {
+			return nil
+		}
+		return &latency
+	}
+	return nil
+}
+
+// assignBatchTags gives every outbound a tag unique in the test box and
+// returns the tags by input index. Untagged outbounds get test-<index>; a tag
+// that is already taken, given twice, synthesized or reserved by the test box
+// or the result format, gets a numeric suffix.
+func assignBatchTags(rawOutbounds []map[string]any) []string {
+	used := map[string]bool{
+		directBaselineTag: true,
+		batchTagsKey:      true,
+	}
+	for _, outbound := range rawOutbounds {
+		if tag, _ := outbound["tag"].(string); tag != "" && !used[tag] {
+			used[tag] = true
+		}
+	}
+	tags := make([]string, len(rawOutbounds))
+	given := make(map[string]bool)
+	for i, outbound := range rawOutbounds {
+		tag, _ := outbound["tag"].(string)
+		if tag != "" && !given[tag] && tag != directBaselineTag && tag != batchTagsKey {
+			// the first outbound giving a tag keeps it
+			given[tag] = true
+			tags[i] = tag
+			continue
+		}
+		base := tag
+		if base == "" {
+			base = fmt.Sprintf("test-%d", i)
+		}
+		unique := base
+		for suffix := 2; used[unique]; suffix++ {
+			unique = fmt.Sprintf("%s-%d", base, suffix)
+		}
+		used[unique] = true
+		outbound["tag"] = unique
+		tags[i] = unique
+	}
+	return tags
+}
+
+// formatBatchResults encodes the batch results. When options are set they are
+// returned as {"results":[{"tag":..,"index":..,"latencyMs":..}],"best":..},
+// filtered and sorted as asked, with best naming the fastest outbound and
+// index the outbound's position in the input, whose tag is tags[index].
+// Otherwise they are a tag→latency object. Both shapes list tags under
+// "tags", so failed outbounds can be mapped back to the input as well.
+// direct is the baseline latency, or nil when it was not measured or failed.
+func formatBatchResults(results map[string]uint16, tags []string, direct *uint16, options batchResultOptions) string {
+	var content []byte
+	var err error
+	if options.isDefault() {
+		shaped := make(map[string]any, len(results)+2)
+		for tag, latency := range results {
+			shaped[tag] = latency
+		}
+		if direct != nil {
+			shaped[directBaselineTag] = *direct
+		}
+		shaped[batchTagsKey] = tags
+		content, err = sjson.Marshal(shaped)
+	} else {
+		content, err = sjson.Marshal(shapeBatchResults(results, tags, direct, options))
+	}
+	if err != nil {
+		return "{}"
+	}
+	return string(content)
+}
+
+func shapeBatchResults(results map[string]uint16, tags []string, direct *uint16, options batchResultOptions) batchResults {
+	shaped := batchResults{Results: []batchResult{}, Direct: direct, Tags: tags}
+	for index, tag := range tags {
+		latency, loaded := results[tag]
+		if !loaded || options.MaxLatencyMs > 0 && latency > options.MaxLatencyMs {
+			continue
+		}
+		result := batchResult{Tag: tag, Index: index, LatencyMs: latency}
+		if direct != nil {
+			relative := int(latency) - int(*direct)
+			result.RelativeMs = &relative
+		}
+		shaped.Results = append(shaped.Results, result)
+	}
+	byLatency := func(i, j int) bool {
+		if shaped.Results[i].LatencyMs != shaped.Results[j].LatencyMs {
+			return shaped.Results[i].LatencyMs < shaped.Results[j].LatencyMs
+		}
+		return shaped.Results[i].Tag < shaped.Results[j].Tag
+	}
+	if options.Sort == "latency" {
+		sort.Slice(shaped.Results, byLatency)
+	} else {
+		// keep the output stable across calls
+		sort.Slice(shaped.Results, func(i, j int) bool {
+			return shaped.Results[i].Tag < shaped.Results[j].Tag
+		})
+	}
+	best := -1
+	for i := range shaped.Results {
+		if best < 0 || byLatency(i, best) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		shaped.Best = shaped.Results[best].Tag
+	}
+	return shaped
+}
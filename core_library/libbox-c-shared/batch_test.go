@@ -0,0 +1,63 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+func TestAssignBatchTagsCollisions(t *testing.T) {
+	rawOutbounds := []map[string]any{
+		{},
+		{},
+		{"tag": "test-1"},
+		{"tag": "a"},
+		{"tag": "a"},
+		{"tag": "direct"},
+		{"tag": "tags"},
+		{"tag": "a-2"},
+	}
+	expected := []string{"test-0", "test-1-2", "test-1", "a", "a-3", "direct-2", "tags-2", "a-2"}
+	tags := assignBatchTags(rawOutbounds)
+	if !slices.Equal(tags, expected) {
+		t.Fatalf("got tags %v, want %v", tags, expected)
+	}
+	for index, outbound := range rawOutbounds {
+		if outbound["tag"] != tags[index] {
+			t.Errorf("outbound %d is tagged %v, reported as %s", index, outbound["tag"], tags[index])
+		}
+	}
+}
+
+func TestFormatBatchResultsIndexes(t *testing.T) {
+	tags := []string{"a", "a-2", "test-2"}
+	results := map[string]uint16{"a": 120, "a-2": 80}
+
+	var plain map[string]any
+	if err := sjson.Unmarshal([]byte(formatBatchResults(results, tags, nil, batchResultOptions{})), &plain); err != nil {
+		t.Fatal(err)
+	}
+	if plain["a"] != float64(120) || plain["a-2"] != float64(80) {
+		t.Errorf("got latencies %v", plain)
+	}
+	if listed, _ := plain[batchTagsKey].([]any); len(listed) != len(tags) || listed[1] != "a-2" || listed[2] != "test-2" {
+		t.Errorf("got tags %v, want %v", plain[batchTagsKey], tags)
+	}
+
+	var shaped batchResults
+	if err := sjson.Unmarshal([]byte(formatBatchResults(results, tags, nil, batchResultOptions{Sort: "latency"})), &shaped); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(shaped.Tags, tags) {
+		t.Errorf("got tags %v, want %v", shaped.Tags, tags)
+	}
+	if len(shaped.Results) != 2 || shaped.Best != "a-2" {
+		t.Fatalf("got results %+v, best %s", shaped.Results, shaped.Best)
+	}
+	for _, result := range shaped.Results {
+		if tags[result.Index] != result.Tag {
+			t.Errorf("result %s has index %d, which was tested as %s", result.Tag, result.Index, tags[result.Index])
+		}
+	}
+}
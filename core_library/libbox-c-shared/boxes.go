@@ -0,0 +1,45 @@
+package main
+
+import "C"
+import (
+	"sync"
+	"sync/atomic"
+
+	box "github.com/sagernet/sing-box"
+)
+
+// liveBoxes counts the boxes created through newBox and not closed yet.
+var liveBoxes atomic.Int64
+
+// trackedBox is a box.Box counted in liveBoxes until it is closed, so leaks
+// on the many early-return paths around temporary boxes show up.
+type trackedBox struct {
+	*box.Box
+	closeOnce sync.Once
+}
+
+// LibboxDebugInstanceCount returns the number of box instances the library
+// has created and not closed: the running service, the test harness and the
+// temporary boxes of tests and fetches in progress. With no service or
+// harness it is 0 once every test has returned. For debugging only.
+//
+//export LibboxDebugInstanceCount
+func LibboxDebugInstanceCount() C.int {
+	return C.int(liveBoxes.Load())
+}
+
+func newBox(options box.Options) (*trackedBox, error) {
+	instance, err := box.New(options)
+	if err != nil {
+		return nil, err
+	}
+	liveBoxes.Add(1)
+	return &trackedBox{Box: instance}, nil
+}
+
+func (b *trackedBox) Close() error {
+	b.closeOnce.Do(func() {
+		liveBoxes.Add(-1)
+	})
+	return b.Box.Close()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testDirectOutbound = `{"type":"direct","tag":"direct"}`
+
+func TestFetchClosesEveryBox(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	for i := range 1000 {
+		switch i % 4 {
+		case 0:
+			// a refused connection fails after the box started
+			fetch(testDirectOutbound, "http://127.0.0.1:1/", time.Second)
+		case 1:
+			// an invalid outbound fails before the box starts
+			fetch(`{"type":"invalid"}`, server.URL, time.Second)
+		default:
+			body, err := fetch(testDirectOutbound, server.URL, 5*time.Second)
+			if err != nil {
+				t.Fatalf("fetch %d: %v", i, err)
+			}
+			if string(body) != "ok" {
+				t.Fatalf("fetch %d: got body %q", i, body)
+			}
+		}
+	}
+	if count := LibboxDebugInstanceCount(); count != 0 {
+		t.Fatalf("%d boxes left open after the fetches", count)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// buildRequiredParams lists, per type LibboxBuildOutbound accepts, the
+// parameters without which the outbound can't connect.
+var buildRequiredParams = map[string][]string{
+	constant.TypeVMess:       {"uuid"},
+	constant.TypeVLESS:       {"uuid"},
+	constant.TypeTrojan:      {"password"},
+	constant.TypeShadowsocks: {"method", "password"},
+	constant.TypeHysteria2:   {"password"},
+}
+
+// LibboxBuildOutbound assembles an outbound from a manual-entry form, the
+// inverse of LibboxParseLinks. outboundType is vmess, vless, trojan, ss (or
+// shadowsocks) or hysteria2 (or hy2). paramsJSON is a flat object whose keys
+// follow the share link parameters: uuid, password, method, flow, tag,
+// alterId and cipher (vmess, "auto" by default); security, sni, alpn, fp,
+// insecure, pbk and sid for TLS; transport (ws, grpc, http, httpupgrade) with
+// host, path and serviceName; obfs and obfsPassword for hysteria2. vmess and
+// vless enable TLS with security "tls" or "reality", trojan and hysteria2
+// always use it. Returns the outbound as sing-box writes it, or {"error"}
+// naming every missing required parameter.
+//
+//export LibboxBuildOutbound
+func LibboxBuildOutbound(outboundType *C.char, server *C.char, port C.int, paramsJSON *C.char) *C.char {
+	outbound, err := buildOutbound(C.GoString(outboundType), C.GoString(server), int(port), C.GoString(paramsJSON))
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	return C.CString(outbound)
+}
+
+func buildOutbound(outboundType string, server string, port int, paramsStr string) (string, error) {
+	outboundType = strings.ToLower(strings.TrimSpace(outboundType))
+	switch outboundType {
+	case "ss":
+		outboundType = constant.TypeShadowsocks
+	case "hy2":
+		outboundType = constant.TypeHysteria2
+	}
+	required, supported := buildRequiredParams[outboundType]
+	if !supported {
+		return "", fmt.Errorf("unsupported outbound type: %s", outboundType)
+	}
+	server = strings.TrimSpace(server)
+	if server == "" {
+		return "", errors.New("missing server")
+	}
+	if port < 1 || port > 65535 {
+		return "", fmt.Errorf("invalid port: %d", port)
+	}
+	params, err := buildParams(paramsStr)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for _, name := range required {
+		if params.Get(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing required fields for %s: %s", outboundType, strings.Join(missing, ", "))
+	}
+
+	outbound := map[string]any{
+		"type":        outboundType,
+		"tag":         defaultString(params.Get("tag"), outboundType),
+		"server":      server,
+		"server_port": port,
+	}
+	switch outboundType {
+	case constant.TypeVMess:
+		outbound["uuid"] = params.Get("uuid")
+		outbound["security"] = defaultString(params.Get("cipher"), "auto")
+		if alterID, err := strconv.Atoi(params.Get("alterId")); err == nil && alterID > 0 {
+			outbound["alter_id"] = alterID
+		}
+		applyLinkTLS(outbound, params, params.Get("security"))
+		applyLinkTransport(outbound, params)
+	case constant.TypeVLESS:
+		outbound["uuid"] = params.Get("uuid")
+		if flow := params.Get("flow"); flow != "" {
+			outbound["flow"] = flow
+		}
+		applyLinkTLS(outbound, params, params.Get("security"))
+		applyLinkTransport(outbound, params)
+	case constant.TypeTrojan:
+		outbound["password"] = params.Get("password")
+		applyLinkTLS(outbound, params, "tls")
+		applyLinkTransport(outbound, params)
+	case constant.TypeShadowsocks:
+		outbound["method"] = params.Get("method")
+		outbound["password"] = params.Get("password")
+	case constant.TypeHysteria2:
+		outbound["password"] = params.Get("password")
+		if obfs := params.Get("obfs"); obfs != "" {
+			outbound["obfs"] = map[string]any{"type": obfs, "password": params.Get("obfsPassword")}
+		}
+		applyLinkTLS(outbound, params, "tls")
+	}
+
+	// Round-trip through option.Outbound so sing-box validates the result
+	// and the JSON comes back in its canonical form.
+	ctx := include.Context(context.Background())
+	content, err := sjson.Marshal(outbound)
+	if err != nil {
+		return "", err
+	}
+	var options option.Outbound
+	if err := sjson.UnmarshalContext(ctx, content, &options); err != nil {
+		return "", fmt.Errorf("invalid outbound: %v", err)
+	}
+	content, err = sjson.MarshalContext(ctx, options)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// buildParams reads the flat parameter object into url.Values, the form
+// applyLinkTLS and applyLinkTransport take. Numbers and booleans are kept as
+// their text; "transport" becomes the link's "type".
+func buildParams(paramsStr string) (url.Values, error) {
+	params := url.Values{}
+	if strings.TrimSpace(paramsStr) == "" {
+		return params, nil
+	}
+	var raw map[string]any
+	if err := sjson.Unmarshal([]byte(paramsStr), &raw); err != nil {
+		return nil, fmt.Errorf("invalid params: %v", err)
+	}
+	for key, value := range raw {
+		var text string
+		switch value := value.(type) {
+		case string:
+			text = value
+		case float64:
+			text = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			text = strconv.FormatBool(value)
+		case nil:
+			continue
+		default:
+			return nil, fmt.Errorf("invalid params: %s must be a string, number or boolean", key)
+		}
+		if key == "transport" {
+			key = "type"
+		}
+		params.Set(key, strings.TrimSpace(text))
+	}
+	return params, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import "C"
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/sagernet/sing-box/constant"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+const singBoxModule = "github.com/sagernet/sing-box"
+
+type buildInfo struct {
+	SingBoxVersion string          `json:"singBoxVersion"`
+	GoVersion      string          `json:"goVersion"`
+	OS             string          `json:"os"`
+	Arch           string          `json:"arch"`
+	CGO            bool            `json:"cgo"`
+	Tags           []string        `json:"tags"`
+	Main           moduleVersion   `json:"main"`
+	Modules        []moduleVersion `json:"modules"`
+}
+
+type moduleVersion struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	// Replace is the module path or directory the module was replaced with.
+	Replace string `json:"replace,omitempty"`
+}
+
+// LibboxBuildInfo describes exactly what was shipped, for bug reports:
+// {"singBoxVersion","goVersion","os","arch","cgo","tags","main","modules"}
+// with the build tags the library was compiled with (with_clash_api,
+// with_quic, ...) and the version of every module linked in, from the
+// binary's embedded build information. singBoxVersion is the version
+// stamped at link time or else that of the sing-box module.
+//
+//export LibboxBuildInfo
+func LibboxBuildInfo() *C.char {
+	info := buildInfo{
+		SingBoxVersion: constant.Version,
+		GoVersion:      runtime.Version(),
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		Tags:           []string{},
+		Modules:        []moduleVersion{},
+	}
+	if embedded, loaded := debug.ReadBuildInfo(); loaded {
+		info.Main = newModuleVersion(&embedded.Main)
+		for _, module := range embedded.Deps {
+			info.Modules = append(info.Modules, newModuleVersion(module))
+			if module.Path == singBoxModule && info.SingBoxVersion == "unknown" {
+				info.SingBoxVersion = module.Version
+			}
+		}
+		for _, setting := range embedded.Settings {
+			switch setting.Key {
+			case "CGO_ENABLED":
+				info.CGO = setting.Value == "1"
+			case "-tags":
+				for _, tag := range strings.Split(setting.Value, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						info.Tags = append(info.Tags, tag)
+					}
+				}
+			}
+		}
+	}
+	jsonBytes, err := sjson.Marshal(info)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func newModuleVersion(module *debug.Module) moduleVersion {
+	version := moduleVersion{Path: module.Path, Version: module.Version}
+	if module.Replace != nil {
+		version.Replace = module.Replace.Path
+		if module.Replace.Version != "" {
+			version.Version = module.Replace.Version
+		}
+	}
+	return version
+}
@@ -0,0 +1,65 @@
+package main
+
+// #include "callback.h"
+import "C"
+import (
+	"sync"
+	"unsafe"
+)
+
+// callbackSink delivers JSON payloads to a host-registered C callback from a
+// dedicated goroutine, so a slow host never stalls the core. The payload
+// pointer is only valid for the duration of the callback.
+type callbackSink struct {
+	access   sync.Mutex
+	callback C.libbox_callback_t
+	queue    chan string
+	once     sync.Once
+}
+
+func newCallbackSink(size int) *callbackSink {
+	return &callbackSink{queue: make(chan string, size)}
+}
+
+func (s *callbackSink) set(callback C.libbox_callback_t) {
+	s.access.Lock()
+	s.callback = callback
+	s.access.Unlock()
+	if callback != nil {
+		s.once.Do(func() { go s.loop() })
+	}
+}
+
+func (s *callbackSink) registered() bool {
+	s.access.Lock()
+	defer s.access.Unlock()
+	return s.callback != nil
+}
+
+// post queues a payload without blocking. It is dropped when no callback is
+// registered or the host has fallen behind and the queue is full.
+func (s *callbackSink) post(payload string) bool {
+	if !s.registered() {
+		return false
+	}
+	select {
+	case s.queue <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *callbackSink) loop() {
+	for payload := range s.queue {
+		s.access.Lock()
+		callback := s.callback
+		s.access.Unlock()
+		if callback == nil {
+			continue
+		}
+		cPayload := C.CString(payload)
+		C.libbox_invoke_callback(callback, cPayload)
+		C.free(unsafe.Pointer(cPayload))
+	}
+}
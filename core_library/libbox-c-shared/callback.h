@@ -0,0 +1,12 @@
+#ifndef LIBBOX_CALLBACK_H
+#define LIBBOX_CALLBACK_H
+
+#include <stdlib.h>
+
+typedef void (*libbox_callback_t)(const char *payload);
+
+static inline void libbox_invoke_callback(libbox_callback_t callback, const char *payload) {
+	callback(payload);
+}
+
+#endif
@@ -0,0 +1,95 @@
+package main
+
+import "C"
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// LibboxCanonicalizeOutbound returns outboundJSON in canonical form: decoded
+// into sing-box's options and written back, so shorthands, empty and
+// zero-valued fields and field order no longer differ, with keys sorted at
+// every level. Returns {"error"} for an outbound sing-box rejects.
+//
+//export LibboxCanonicalizeOutbound
+func LibboxCanonicalizeOutbound(outboundJSON *C.char) *C.char {
+	ctx := include.Context(context.Background())
+	canonical, err := canonicalOutbound(ctx, []byte(C.GoString(outboundJSON)))
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	jsonBytes, err := sjson.Marshal(canonical)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// canonicalOutbound round-trips an outbound through option.Outbound. The
+// result is a map, which marshals with sorted keys.
+func canonicalOutbound(ctx context.Context, content []byte) (map[string]any, error) {
+	var options option.Outbound
+	if err := sjson.UnmarshalContext(ctx, content, &options); err != nil {
+		return nil, fmt.Errorf("decode outbound error: %v", err)
+	}
+	content, err := sjson.MarshalContext(ctx, &options)
+	if err != nil {
+		return nil, fmt.Errorf("encode outbound error: %v", err)
+	}
+	var canonical map[string]any
+	if err := sjson.Unmarshal(content, &canonical); err != nil {
+		return nil, fmt.Errorf("encode outbound error: %v", err)
+	}
+	return canonical, nil
+}
+
+// canonicalConfig is canonicalOutbound for a whole config, which may carry
+// comments and trailing commas as for LibboxStart.
+func canonicalConfig(ctx context.Context, configStr string) (map[string]any, error) {
+	options, err := decodeConfig(ctx, configStr)
+	if err != nil {
+		return nil, err
+	}
+	content, err := sjson.MarshalContext(ctx, &options)
+	if err != nil {
+		return nil, fmt.Errorf("encode config error: %v", err)
+	}
+	var canonical map[string]any
+	if err := sjson.Unmarshal(content, &canonical); err != nil {
+		return nil, fmt.Errorf("encode config error: %v", err)
+	}
+	return canonical, nil
+}
+
+// connectionFingerprint hashes the canonical form of the outbound without
+// its tag, with the server compared case-insensitively. Outbounds sing-box
+// can't decode, such as ones carrying test-only fields, are hashed as they
+// are.
+func connectionFingerprint(ctx context.Context, outbound map[string]any) ([sha256.Size]byte, error) {
+	content, err := sjson.Marshal(outbound)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	canonical, err := canonicalOutbound(ctx, content)
+	if err != nil {
+		canonical = make(map[string]any, len(outbound))
+		for key, value := range outbound {
+			canonical[key] = value
+		}
+	}
+	delete(canonical, "tag")
+	if server, isString := canonical["server"].(string); isString {
+		canonical["server"] = strings.ToLower(server)
+	}
+	content, err = sjson.Marshal(canonical)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(content), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/service"
+)
+
+// clashModeSetter is implemented by the clash API server; adapter.ClashServer
+// only exposes the getters.
+type clashModeSetter interface {
+	SetMode(newMode string)
+}
+
+// runningClashServer must be called with mu held.
+func runningClashServer() (adapter.ClashServer, error) {
+	if instance == nil {
+		return nil, errors.New("service not running")
+	}
+	server := service.FromContext[adapter.ClashServer](instanceCtx)
+	if server == nil {
+		return nil, errors.New("clash api is not enabled in config")
+	}
+	return server, nil
+}
+
+// LibboxSetClashMode switches the running instance between the configured
+// clash modes (e.g. Rule, Global, Direct). Matching is case-insensitive.
+//
+//export LibboxSetClashMode
+func LibboxSetClashMode(mode *C.char) *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	server, err := runningClashServer()
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	setter, ok := server.(clashModeSetter)
+	if !ok {
+		return C.CString(fmt.Sprintf("clash server does not support switching mode: %T", server))
+	}
+
+	requested := C.GoString(mode)
+	modeList := server.ModeList()
+	newMode := common.Find(modeList, func(it string) bool {
+		return strings.EqualFold(it, requested)
+	})
+	if newMode == "" {
+		return C.CString(fmt.Sprintf("unknown clash mode %q, available: %s", requested, strings.Join(modeList, ", ")))
+	}
+
+	if newMode != server.Mode() {
+		setter.SetMode(newMode)
+		postStatusEvent("clash_mode", map[string]any{"mode": newMode})
+	}
+	return nil
+}
+
+// LibboxGetClashMode returns the current clash mode, or NULL when the
+// service is not running or the clash API is disabled.
+//
+//export LibboxGetClashMode
+func LibboxGetClashMode() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	server, err := runningClashServer()
+	if err != nil {
+		return nil
+	}
+	return C.CString(server.Mode())
+}
@@ -0,0 +1,189 @@
+package main
+
+import "C"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"strings"
+	"unsafe"
+
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+const defaultClashAPIListen = "127.0.0.1:9090"
+
+var (
+	// clashConfigSecret is the clash_api secret of the running config, which
+	// the clash handler already enforces.
+	clashConfigSecret string
+
+	clashAPIServer *http.Server
+	clashAPIListen string
+	clashAPISecret string
+)
+
+type clashAPIState struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+}
+
+// LibboxSetClashAPIEnabled serves the clash API of the running instance on
+// listenAddr (127.0.0.1:9090 when empty) while enabled is non-zero, and stops
+// serving it otherwise. It needs experimental.clash_api in the config and is
+// independent of the config's external_controller. Without a secret in the
+// config a random one is generated for each enable; non-loopback addresses
+// are refused unless the config sets a secret. Returns
+// {"enabled","listen","secret"}.
+//
+//export LibboxSetClashAPIEnabled
+func LibboxSetClashAPIEnabled(enabled C.int, listenAddr *C.char) *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if enabled == 0 {
+		if err := closeClashAPI(); err != nil {
+			return jsonError("%v", err)
+		}
+		return marshalClashAPIState()
+	}
+
+	server, err := runningClashServer()
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	handler, err := clashHandler(server)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	listen := strings.TrimSpace(C.GoString(listenAddr))
+	if listen == "" {
+		listen = defaultClashAPIListen
+	}
+	if clashAPIServer != nil && listen == clashAPIListen {
+		return marshalClashAPIState()
+	}
+	if !isLoopbackListen(listen) && clashConfigSecret == "" {
+		return jsonError("refusing to serve clash api on non-loopback address %s without a secret in config", listen)
+	}
+
+	secret := clashConfigSecret
+	if secret == "" {
+		secret, err = randomClashSecret()
+		if err != nil {
+			return jsonError("%v", err)
+		}
+		handler = clashAuthentication(secret, handler)
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return jsonError("clash api listen error: %v", err)
+	}
+	if err := closeClashAPI(); err != nil {
+		listener.Close()
+		return jsonError("%v", err)
+	}
+	httpServer := &http.Server{Handler: handler}
+	go httpServer.Serve(listener)
+	clashAPIServer = httpServer
+	clashAPIListen = listener.Addr().String()
+	clashAPISecret = secret
+	return marshalClashAPIState()
+}
+
+// closeClashAPI stops the server started by LibboxSetClashAPIEnabled, if
+// any. It must be called with mu held.
+func closeClashAPI() error {
+	if clashAPIServer == nil {
+		return nil
+	}
+	err := clashAPIServer.Close()
+	clashAPIServer = nil
+	clashAPIListen = ""
+	clashAPISecret = ""
+	return err
+}
+
+func marshalClashAPIState() *C.char {
+	state := clashAPIState{
+		Enabled: clashAPIServer != nil,
+		Listen:  clashAPIListen,
+		Secret:  clashAPISecret,
+	}
+	jsonBytes, err := sjson.Marshal(state)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// clashHandler takes the router of the clash server, which sing-box only
+// exposes through the http.Server it listens with itself.
+func clashHandler(server any) (http.Handler, error) {
+	value := reflect.ValueOf(server)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("unsupported clash server: %T", server)
+	}
+	field := value.Elem().FieldByName("httpServer")
+	if !field.IsValid() || field.Type() != reflect.TypeOf((*http.Server)(nil)) {
+		return nil, fmt.Errorf("unsupported clash server: %T", server)
+	}
+	httpServer := *(**http.Server)(unsafe.Pointer(field.UnsafeAddr()))
+	if httpServer == nil || httpServer.Handler == nil {
+		return nil, errors.New("clash server has no handler")
+	}
+	return httpServer.Handler, nil
+}
+
+func isLoopbackListen(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+func randomClashSecret() (string, error) {
+	var secret [16]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret[:]), nil
+}
+
+// clashAuthentication checks the secret the way the clash API does: a bearer
+// token, or a token query parameter for browser websockets. Preflight
+// requests are passed on to the handler's CORS middleware.
+func clashAuthentication(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if r.Header.Get("Upgrade") != "websocket" || token == "" {
+			bearer, headerToken, found := strings.Cut(r.Header.Get("Authorization"), " ")
+			if bearer != "Bearer" || !found {
+				headerToken = ""
+			}
+			token = headerToken
+		}
+		if token == "" || token != secret {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"Unauthorized"}`))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
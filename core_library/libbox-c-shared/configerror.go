@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sagernet/sing-box/option"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// configError is a config decoding error with where it happened: Line and
+// Column (from 1) in the original text, 0 when unknown, and Path the JSON
+// path of the failing section, element or field, e.g. "outbounds[2].server".
+type configError struct {
+	err    error
+	Path   string
+	Line   int
+	Column int
+}
+
+func (e *configError) Error() string {
+	switch {
+	case e.Line == 0:
+		return e.err.Error()
+	case e.Path == "":
+		return fmt.Sprintf("%v (row %d, column %d)", e.err, e.Line, e.Column)
+	default:
+		return fmt.Sprintf("%v (at %s, row %d, column %d)", e.err, e.Path, e.Line, e.Column)
+	}
+}
+
+func (e *configError) Unwrap() error {
+	return e.err
+}
+
+// unknownFieldPattern finds the key named by a strict decoder rejecting it,
+// in the wording of encoding/json and of sing's badjson.
+var unknownFieldPattern = regexp.MustCompile(`unknown field "([^"]+)"|unexpected key: (\S+)`)
+
+// locateConfigError finds what in content, which must be syntactically
+// valid, fails to decode: sing-box's errors rarely carry a usable offset,
+// and offsets of nested decoders are relative to re-encoded fragments
+// anyway. Each part of the config is decoded on its own, wrapped back into
+// a config, narrowing down from top-level sections to array elements; the
+// field the error names, if any, then narrows down within the element.
+func locateConfigError(ctx context.Context, content []byte, err error) (string, int, bool) {
+	root := skipJSONSpace(content, 0)
+	if root >= len(content) || content[root] != '{' {
+		return "", 0, false
+	}
+	fails := func(candidate []byte) bool {
+		var options option.Options
+		return sjson.UnmarshalContext(ctx, candidate, &options) != nil
+	}
+	path, span, found := narrowConfigError(content, jsonSpan{start: root, end: jsonValueEnd(content, root)}, "", func(value []byte) []byte {
+		return value
+	}, fails)
+	if !found {
+		return "", 0, false
+	}
+	offset := span.keyStart
+	if field := errorField(err); field != "" {
+		for _, segment := range strings.Split(field, ".") {
+			if content[span.start] != '{' {
+				break
+			}
+			index := -1
+			children := jsonChildren(content, span.start)
+			for i, child := range children {
+				if child.key == segment {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				break
+			}
+			span = children[index]
+			path += "." + segment
+			offset = span.keyStart
+		}
+	}
+	return path, offset, true
+}
+
+// narrowConfigError returns the member or element of the object or array
+// in span that fails to decode once wrap puts it alone back into a config,
+// descending through objects and stopping at array elements, which are
+// whole sing-box options.
+func narrowConfigError(content []byte, span jsonSpan, path string, wrap func([]byte) []byte, fails func([]byte) bool) (string, jsonSpan, bool) {
+	if span.start >= len(content) {
+		return "", span, false
+	}
+	switch content[span.start] {
+	case '{':
+		for _, child := range jsonChildren(content, span.start) {
+			key := strconv.Quote(child.key)
+			childWrap := func(value []byte) []byte {
+				return wrap([]byte("{" + key + ":" + string(value) + "}"))
+			}
+			if !fails(childWrap(content[child.start:child.end])) {
+				continue
+			}
+			childPath := child.key
+			if path != "" {
+				childPath = path + "." + child.key
+			}
+			if narrowedPath, narrowed, found := narrowConfigError(content, child, childPath, childWrap, fails); found {
+				return narrowedPath, narrowed, true
+			}
+			return childPath, child, true
+		}
+	case '[':
+		for i, child := range jsonChildren(content, span.start) {
+			if fails(wrap([]byte("[" + string(content[child.start:child.end]) + "]"))) {
+				return fmt.Sprintf("%s[%d]", path, i), child, true
+			}
+		}
+	}
+	return "", span, false
+}
+
+// errorField returns the field a decode error names: the path of a json
+// UnmarshalTypeError, which sing's json package doesn't export, or a
+// rejected unknown key.
+func errorField(err error) string {
+	for current := err; current != nil; current = errors.Unwrap(current) {
+		value := reflect.ValueOf(current)
+		if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		if value.Elem().Type().Name() != "UnmarshalTypeError" {
+			continue
+		}
+		if field := value.Elem().FieldByName("Field"); field.Kind() == reflect.String && field.String() != "" {
+			return field.String()
+		}
+	}
+	if match := unknownFieldPattern.FindStringSubmatch(err.Error()); match != nil {
+		return match[1] + match[2]
+	}
+	return ""
+}
+
+// jsonSpan is a value in a JSON text: [start, end) and, for object members,
+// the key and where it starts. keyStart equals start for anything else.
+type jsonSpan struct {
+	key      string
+	keyStart int
+	start    int
+	end      int
+}
+
+// jsonChildren lists the members of the object, or the elements of the
+// array, opening at start. It stops at the first thing that isn't
+// well-formed.
+func jsonChildren(content []byte, start int) []jsonSpan {
+	var children []jsonSpan
+	isObject := content[start] == '{'
+	i := start + 1
+	for {
+		i = skipJSONSpace(content, i)
+		if i >= len(content) || content[i] == '}' || content[i] == ']' {
+			return children
+		}
+		child := jsonSpan{keyStart: i}
+		if isObject {
+			if content[i] != '"' {
+				return children
+			}
+			keyEnd := skipJSONString(content, i)
+			if keyEnd >= len(content) || sjson.Unmarshal(content[i:keyEnd+1], &child.key) != nil {
+				return children
+			}
+			i = skipJSONSpace(content, keyEnd+1)
+			if i >= len(content) || content[i] != ':' {
+				return children
+			}
+			i = skipJSONSpace(content, i+1)
+		}
+		child.start = i
+		child.end = jsonValueEnd(content, i)
+		children = append(children, child)
+		i = skipJSONSpace(content, child.end)
+		if i >= len(content) || content[i] != ',' {
+			return children
+		}
+		i++
+	}
+}
+
+// jsonValueEnd returns the index just past the value starting at start.
+func jsonValueEnd(content []byte, start int) int {
+	if start >= len(content) {
+		return start
+	}
+	switch content[start] {
+	case '"':
+		return min(skipJSONString(content, start)+1, len(content))
+	case '{', '[':
+		depth := 0
+		for i := start; i < len(content); i++ {
+			switch content[i] {
+			case '"':
+				i = skipJSONString(content, i)
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return len(content)
+	}
+	i := start
+	for i < len(content) && !isJSONSpace(content[i]) && content[i] != ',' && content[i] != '}' && content[i] != ']' {
+		i++
+	}
+	return i
+}
+
+func skipJSONSpace(content []byte, i int) int {
+	for i < len(content) && isJSONSpace(content[i]) {
+		i++
+	}
+	return i
+}
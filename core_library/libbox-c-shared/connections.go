@@ -0,0 +1,461 @@
+package main
+
+// #include "callback.h"
+import "C"
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/protocol/group"
+	"github.com/sagernet/sing/common/bufio"
+	sjson "github.com/sagernet/sing/common/json"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const (
+	// connectionFlushInterval is how long connection events are gathered
+	// before they are delivered as one batch.
+	connectionFlushInterval = 200 * time.Millisecond
+	// connectionBatchLimit caps a batch; events beyond it are counted in
+	// "dropped" rather than queued, so heavy churn can't grow memory.
+	connectionBatchLimit = 1024
+)
+
+var connectionSink = newCallbackSink(16)
+
+// LibboxSetConnectionCallback registers the host function that is told when
+// connections of the running instance open or close. Events are batched every
+// connectionFlushInterval into {"events":[{id,event,network,source,
+// destination,domain,inbound,outbound,process}...],"dropped":n}, process
+// being the executable that opened the connection when sing-box looked it up
+// for a process rule. Passing NULL unregisters it.
+//
+//export LibboxSetConnectionCallback
+func LibboxSetConnectionCallback(callback C.libbox_callback_t) {
+	connectionSink.set(callback)
+}
+
+// LibboxSetConnectionOutbound moves the connection with the given id (as
+// reported to the connection callback) to outboundTag. sing-box picks the
+// outbound before a connection reaches any tracker and cannot redial a live
+// flow, so the connection is closed and the client's reconnect is routed
+// anew. To make that reconnect use outboundTag, the connection must have gone
+// through a selector group containing it, and that selector is switched to
+// outboundTag, which affects every new connection through the group.
+//
+//export LibboxSetConnectionOutbound
+func LibboxSetConnectionOutbound(id *C.char, outboundTag *C.char) *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil || instanceConnections == nil {
+		return C.CString("service not running")
+	}
+	connectionID := C.GoString(id)
+	tag := C.GoString(outboundTag)
+	live, loaded := instanceConnections.connection(connectionID)
+	if !loaded {
+		return C.CString(fmt.Sprintf("connection not found: %s", connectionID))
+	}
+	if _, loaded := instance.Outbound().Outbound(tag); !loaded {
+		return C.CString(fmt.Sprintf("outbound not found: %s", tag))
+	}
+	if live.outbound == nil || live.outbound.Tag() != tag {
+		selector, isSelector := live.outbound.(*group.Selector)
+		if !isSelector || !selector.SelectOutbound(tag) {
+			return C.CString(fmt.Sprintf("connection %s is not routed through a selector containing %s", connectionID, tag))
+		}
+	}
+	live.conn.Close()
+	return nil
+}
+
+// LibboxFlushConnections closes every connection of the running instance,
+// which keeps running, so clients reconnect and the new connections follow
+// the current routing, selections and network, e.g. after switching from
+// Wi-Fi to cellular. Returns {"flushed"} with the number closed.
+//
+//export LibboxFlushConnections
+func LibboxFlushConnections() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil || instanceConnections == nil {
+		return jsonError("service not running")
+	}
+	jsonBytes, err := sjson.Marshal(map[string]int{"flushed": instanceConnections.closeAll()})
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+type connectionEvent struct {
+	ID          string `json:"id"`
+	Event       string `json:"event"`
+	Network     string `json:"network"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Domain      string `json:"domain,omitempty"`
+	Inbound     string `json:"inbound"`
+	Outbound    string `json:"outbound"`
+	// Process is the executable of the local process that opened the
+	// connection, when sing-box looked its owner up for a process rule.
+	Process string `json:"process,omitempty"`
+}
+
+type connectionBatch struct {
+	Events  []connectionEvent `json:"events"`
+	Dropped int               `json:"dropped,omitempty"`
+}
+
+// connectionTracker is appended to the router of every instance we start. It
+// counts the traffic of each outbound, keeps the live connections so they can
+// be rerouted, and reports them to connectionSink while a callback is
+// registered.
+type connectionTracker struct {
+	nextID  atomic.Uint64
+	access  sync.Mutex
+	pending connectionBatch
+	flush   *time.Timer
+
+	liveAccess sync.Mutex
+	live       map[string]*liveConnection
+	// draining refuses new connections while LibboxStopGraceful waits for
+	// the open ones.
+	draining atomic.Bool
+	// defaultInterface is the name of the current default interface, kept
+	// by watchDefaultInterface.
+	defaultInterface atomic.Pointer[string]
+
+	// manager resolves the groups connections are routed to, so traffic
+	// counts for the member that carries it.
+	manager     adapter.OutboundManager
+	statsAccess sync.Mutex
+	stats       map[string]*outboundCounters
+}
+
+type liveConnection struct {
+	event    connectionEvent
+	outbound adapter.Outbound
+	conn     io.Closer
+	notify   bool
+	// defaultInterface is the name of the default interface when the
+	// connection was routed, which auto_detect_interface binds it to.
+	defaultInterface string
+}
+
+type outboundCounters struct {
+	upload   atomic.Int64
+	download atomic.Int64
+}
+
+var _ adapter.ConnectionTracker = (*connectionTracker)(nil)
+
+func installConnectionTracker(router adapter.Router, manager adapter.OutboundManager) *connectionTracker {
+	tracker := &connectionTracker{
+		live:    make(map[string]*liveConnection),
+		manager: manager,
+		stats:   make(map[string]*outboundCounters),
+	}
+	router.AppendTracker(tracker)
+	return tracker
+}
+
+func (t *connectionTracker) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) net.Conn {
+	if t.draining.Load() {
+		conn.Close()
+		return conn
+	}
+	counters := t.counters(matchOutbound)
+	conn = bufio.NewInt64CounterConn(conn, []*atomic.Int64{&counters.upload}, []*atomic.Int64{&counters.download})
+	tracked := &trackedConn{Conn: conn, tracker: t}
+	tracked.live = t.join(metadata, matchOutbound, tracked)
+	return tracked
+}
+
+func (t *connectionTracker) RoutedPacketConnection(ctx context.Context, conn N.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) N.PacketConn {
+	if t.draining.Load() {
+		conn.Close()
+		return conn
+	}
+	counters := t.counters(matchOutbound)
+	conn = bufio.NewInt64CounterPacketConn(conn, []*atomic.Int64{&counters.upload}, nil, []*atomic.Int64{&counters.download}, nil)
+	tracked := &trackedPacketConn{PacketConn: conn, tracker: t}
+	tracked.live = t.join(metadata, matchOutbound, tracked)
+	return tracked
+}
+
+// counters returns the counters of an outbound, creating them on first use.
+// A group is resolved to the member it currently uses, which is what the
+// connection is dialed through. Reads from the routed connection are the
+// client's upload.
+func (t *connectionTracker) counters(outbound adapter.Outbound) *outboundCounters {
+	var tag string
+	if outbound != nil {
+		tag = leafOutbound(t.manager, outbound).Tag()
+	}
+	t.statsAccess.Lock()
+	defer t.statsAccess.Unlock()
+	counters, loaded := t.stats[tag]
+	if !loaded {
+		counters = &outboundCounters{}
+		t.stats[tag] = counters
+	}
+	return counters
+}
+
+// leafOutbound follows groups from out to the member they currently use.
+func leafOutbound(manager adapter.OutboundManager, out adapter.Outbound) adapter.Outbound {
+	path := outboundPath(manager, out, false)
+	return path[len(path)-1]
+}
+
+// outboundPath lists out and the outbounds behind it: the member a group
+// currently uses and, with detours, the detour of a proxy.
+func outboundPath(manager adapter.OutboundManager, out adapter.Outbound, detours bool) []adapter.Outbound {
+	path := []adapter.Outbound{out}
+	// bounded in case outbounds reference each other
+	for i := 0; i < 8; i++ {
+		var nextTag string
+		if outboundGroup, isGroup := out.(adapter.OutboundGroup); isGroup {
+			nextTag = outboundGroup.Now()
+		} else if dependencies := out.Dependencies(); detours && len(dependencies) == 1 {
+			nextTag = dependencies[0]
+		}
+		next, loaded := manager.Outbound(nextTag)
+		if nextTag == "" || !loaded {
+			break
+		}
+		out = next
+		path = append(path, out)
+	}
+	return path
+}
+
+// outboundStats snapshots the cumulative traffic of each outbound tag.
+func (t *connectionTracker) outboundStats() map[string]outboundStat {
+	t.statsAccess.Lock()
+	defer t.statsAccess.Unlock()
+	stats := make(map[string]outboundStat, len(t.stats))
+	for tag, counters := range t.stats {
+		stats[tag] = outboundStat{
+			Upload:   counters.upload.Load(),
+			Download: counters.download.Load(),
+		}
+	}
+	return stats
+}
+
+// resetStats zeroes the counters in place; live connections keep counting
+// into them.
+func (t *connectionTracker) resetStats() {
+	t.statsAccess.Lock()
+	defer t.statsAccess.Unlock()
+	for _, counters := range t.stats {
+		counters.upload.Store(0)
+		counters.download.Store(0)
+	}
+}
+
+// join records a routed connection and reports it as opened when a callback
+// is registered at that moment.
+func (t *connectionTracker) join(metadata adapter.InboundContext, matchOutbound adapter.Outbound, conn io.Closer) *liveConnection {
+	live := &liveConnection{
+		event: connectionEvent{
+			ID:          strconv.FormatUint(t.nextID.Add(1), 10),
+			Event:       "open",
+			Network:     metadata.Network,
+			Source:      metadata.Source.String(),
+			Destination: metadata.Destination.String(),
+			Domain:      metadata.Domain,
+			Inbound:     metadata.Inbound,
+		},
+		outbound: matchOutbound,
+		conn:     conn,
+		notify:   connectionSink.registered(),
+	}
+	if defaultInterface := t.defaultInterface.Load(); defaultInterface != nil {
+		live.defaultInterface = *defaultInterface
+	}
+	if matchOutbound != nil {
+		live.event.Outbound = matchOutbound.Tag()
+	}
+	if metadata.ProcessInfo != nil {
+		live.event.Process = metadata.ProcessInfo.ProcessPath
+	}
+	t.liveAccess.Lock()
+	t.live[live.event.ID] = live
+	t.liveAccess.Unlock()
+	if live.notify {
+		t.push(live.event)
+	}
+	return live
+}
+
+func (t *connectionTracker) leave(live *liveConnection) {
+	t.liveAccess.Lock()
+	delete(t.live, live.event.ID)
+	t.liveAccess.Unlock()
+	if live.notify {
+		event := live.event
+		event.Event = "close"
+		t.push(event)
+	}
+}
+
+func (t *connectionTracker) liveCount() int {
+	t.liveAccess.Lock()
+	defer t.liveAccess.Unlock()
+	return len(t.live)
+}
+
+func (t *connectionTracker) connection(id string) (*liveConnection, bool) {
+	t.liveAccess.Lock()
+	defer t.liveAccess.Unlock()
+	live, loaded := t.live[id]
+	return live, loaded
+}
+
+// snapshot returns the live connections as they were reported when opened,
+// ordered by id.
+func (t *connectionTracker) snapshot() []connectionEvent {
+	t.liveAccess.Lock()
+	events := make([]connectionEvent, 0, len(t.live))
+	for _, live := range t.live {
+		events = append(events, live.event)
+	}
+	t.liveAccess.Unlock()
+	slices.SortFunc(events, func(a, b connectionEvent) int {
+		aID, _ := strconv.ParseUint(a.ID, 10, 64)
+		bID, _ := strconv.ParseUint(b.ID, 10, 64)
+		return cmp.Compare(aID, bID)
+	})
+	return events
+}
+
+// inboundCounts returns the number of live connections by inbound tag.
+func (t *connectionTracker) inboundCounts() map[string]int {
+	t.liveAccess.Lock()
+	defer t.liveAccess.Unlock()
+	counts := make(map[string]int)
+	for _, live := range t.live {
+		counts[live.event.Inbound]++
+	}
+	return counts
+}
+
+// closeAll closes the live connections and returns how many there were.
+func (t *connectionTracker) closeAll() int {
+	return t.closeMatching(func(*liveConnection) bool {
+		return true
+	})
+}
+
+// closeInterface closes the live connections routed while interfaceName was
+// the default interface.
+func (t *connectionTracker) closeInterface(interfaceName string) int {
+	return t.closeMatching(func(connection *liveConnection) bool {
+		return connection.defaultInterface == interfaceName
+	})
+}
+
+func (t *connectionTracker) closeMatching(match func(*liveConnection) bool) int {
+	t.liveAccess.Lock()
+	live := make([]*liveConnection, 0, len(t.live))
+	for _, connection := range t.live {
+		if match(connection) {
+			live = append(live, connection)
+		}
+	}
+	t.liveAccess.Unlock()
+	// closing leaves the tracker, which takes liveAccess again
+	for _, connection := range live {
+		connection.conn.Close()
+	}
+	return len(live)
+}
+
+func (t *connectionTracker) push(event connectionEvent) {
+	t.access.Lock()
+	defer t.access.Unlock()
+	if len(t.pending.Events) >= connectionBatchLimit {
+		t.pending.Dropped++
+		return
+	}
+	t.pending.Events = append(t.pending.Events, event)
+	if t.flush == nil {
+		t.flush = time.AfterFunc(connectionFlushInterval, t.deliver)
+	}
+}
+
+func (t *connectionTracker) deliver() {
+	t.access.Lock()
+	batch := t.pending
+	t.pending = connectionBatch{}
+	t.flush = nil
+	t.access.Unlock()
+
+	content, err := sjson.Marshal(batch)
+	if err != nil {
+		return
+	}
+	connectionSink.post(string(content))
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker   *connectionTracker
+	live      *liveConnection
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() { c.tracker.leave(c.live) })
+	return c.Conn.Close()
+}
+
+func (c *trackedConn) Upstream() any {
+	return c.Conn
+}
+
+func (c *trackedConn) ReaderReplaceable() bool {
+	return true
+}
+
+func (c *trackedConn) WriterReplaceable() bool {
+	return true
+}
+
+type trackedPacketConn struct {
+	N.PacketConn
+	tracker   *connectionTracker
+	live      *liveConnection
+	closeOnce sync.Once
+}
+
+func (c *trackedPacketConn) Close() error {
+	c.closeOnce.Do(func() { c.tracker.leave(c.live) })
+	return c.PacketConn.Close()
+}
+
+func (c *trackedPacketConn) Upstream() any {
+	return c.PacketConn
+}
+
+func (c *trackedPacketConn) ReaderReplaceable() bool {
+	return true
+}
+
+func (c *trackedPacketConn) WriterReplaceable() bool {
+	return true
+}
@@ -0,0 +1,345 @@
+package main
+
+// #include <stdlib.h>
+import "C"
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/sagernet/sing-box/protocol/group"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// JSON-RPC 2.0 error codes; controlErrorFailed is used for operations that
+// were understood but failed, such as selecting while not running.
+const (
+	controlErrorParse          = -32700
+	controlErrorInvalidRequest = -32600
+	controlErrorMethod         = -32601
+	controlErrorParams         = -32602
+	controlErrorFailed         = -32000
+)
+
+// controlRequestLimit caps a request line so a misbehaving client can't grow
+// memory without bound.
+const controlRequestLimit = 4 << 20
+
+var (
+	controlAccess sync.Mutex
+	controlServer *controlListener
+)
+
+type controlListener struct {
+	listener net.Listener
+	path     string
+	access   sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+type controlRequest struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      sjson.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  sjson.RawMessage `json:"params,omitempty"`
+}
+
+type controlResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      sjson.RawMessage `json:"id"`
+	Result  sjson.RawMessage `json:"result,omitempty"`
+	Error   *controlError    `json:"error,omitempty"`
+}
+
+type controlError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// LibboxServeControl serves JSON-RPC 2.0 on the Unix socket socketPath, one
+// request per line and one response line each, so daemons, CLI tools and
+// GUIs can drive the library without linking it. Methods:
+//
+//	status                                  LibboxHealthCheck
+//	stats                                   LibboxGetOutboundStats
+//	connections                             the live connections
+//	select {"group","outbound"}             switch a selector group
+//	reload {"outbounds":[...]} or [...]     LibboxReloadOutbounds
+//
+// The socket is readable and writable by the owner only from the moment it
+// appears at socketPath. A stale socket left there is replaced; one another
+// server still answers on, or any other file, is not. Returns {"path"} or
+// {"error"}.
+//
+//export LibboxServeControl
+func LibboxServeControl(socketPath *C.char) *C.char {
+	controlAccess.Lock()
+	defer controlAccess.Unlock()
+
+	if controlServer != nil {
+		return jsonError("control socket already serving at %s", controlServer.path)
+	}
+	path := strings.TrimSpace(C.GoString(socketPath))
+	if path == "" {
+		return jsonError("missing socket path")
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return jsonError("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return jsonError("another server is listening on %s", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := listenControl(path)
+	if err != nil {
+		return jsonError("control listen error: %v", err)
+	}
+	controlServer = &controlListener{
+		listener: listener,
+		path:     path,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go controlServer.serve()
+
+	jsonBytes, err := sjson.Marshal(map[string]string{"path": path})
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// listenControl binds the socket inside a fresh 0700 directory next to path
+// and renames it into place once it is 0600, so no other user can connect
+// while it still carries the umask's permissions. The umask itself is
+// process-wide and not safe to change from a library.
+func listenControl(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".ctl")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	bindPath := filepath.Join(dir, "s")
+	listener, err := net.Listen("unix", bindPath)
+	if err != nil {
+		return nil, err
+	}
+	// close removes path itself; the bind path is gone after the rename.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(bindPath, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(bindPath, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// LibboxStopControl stops serving the control socket, closes its clients and
+// removes the socket file.
+//
+//export LibboxStopControl
+func LibboxStopControl() *C.char {
+	controlAccess.Lock()
+	defer controlAccess.Unlock()
+
+	if controlServer == nil {
+		return C.CString("control socket not serving")
+	}
+	err := controlServer.close()
+	controlServer = nil
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
+
+func (l *controlListener) serve() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		l.access.Lock()
+		if l.closed {
+			l.access.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.access.Unlock()
+		go l.handle(conn)
+	}
+}
+
+func (l *controlListener) handle(conn net.Conn) {
+	defer func() {
+		l.access.Lock()
+		delete(l.conns, conn)
+		l.access.Unlock()
+		conn.Close()
+	}()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), controlRequestLimit)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		response := handleControlRequest([]byte(line))
+		if response == nil {
+			// notifications get no response
+			continue
+		}
+		content, err := sjson.Marshal(response)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(append(content, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+func (l *controlListener) close() error {
+	l.access.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.access.Unlock()
+	err := l.listener.Close()
+	if removeErr := os.Remove(l.path); removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+func handleControlRequest(content []byte) *controlResponse {
+	var request controlRequest
+	if err := sjson.Unmarshal(content, &request); err != nil {
+		return newControlError(sjson.RawMessage("null"), controlErrorParse, "parse error: %v", err)
+	}
+	id := request.ID
+	if len(id) == 0 {
+		id = sjson.RawMessage("null")
+	}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		return newControlError(id, controlErrorInvalidRequest, "invalid request")
+	}
+	result, rpcErr := callControlMethod(request.Method, request.Params)
+	if len(request.ID) == 0 {
+		return nil
+	}
+	if rpcErr != nil {
+		return &controlResponse{JSONRPC: "2.0", ID: id, Error: rpcErr}
+	}
+	return &controlResponse{JSONRPC: "2.0", ID: id, Result: result}
+}
+
+func newControlError(id sjson.RawMessage, code int, format string, args ...any) *controlResponse {
+	return &controlResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &controlError{Code: code, Message: fmt.Sprintf(format, args...)},
+	}
+}
+
+func callControlMethod(method string, params sjson.RawMessage) (sjson.RawMessage, *controlError) {
+	switch method {
+	case "status":
+		return controlResult(takeCString(LibboxHealthCheck()))
+	case "stats":
+		return controlResult(takeCString(LibboxGetOutboundStats()))
+	case "connections":
+		mu.Lock()
+		events := []connectionEvent{}
+		if instance != nil && instanceConnections != nil {
+			events = instanceConnections.snapshot()
+		}
+		mu.Unlock()
+		content, err := sjson.Marshal(map[string]any{"connections": events})
+		if err != nil {
+			return nil, &controlError{Code: controlErrorFailed, Message: err.Error()}
+		}
+		return content, nil
+	case "select":
+		var selection struct {
+			Group    string `json:"group"`
+			Outbound string `json:"outbound"`
+		}
+		if err := sjson.Unmarshal(params, &selection); err != nil || selection.Group == "" || selection.Outbound == "" {
+			return nil, &controlError{Code: controlErrorParams, Message: `select needs {"group","outbound"}`}
+		}
+		if err := selectOutbound(selection.Group, selection.Outbound); err != nil {
+			return nil, &controlError{Code: controlErrorFailed, Message: err.Error()}
+		}
+		return controlResult(`{"ok":true}`)
+	case "reload":
+		if len(params) == 0 {
+			return nil, &controlError{Code: controlErrorParams, Message: "reload needs the outbounds"}
+		}
+		configStr := C.CString(string(params))
+		defer C.free(unsafe.Pointer(configStr))
+		return controlResult(takeCString(LibboxReloadOutbounds(configStr)))
+	default:
+		return nil, &controlError{Code: controlErrorMethod, Message: "method not found: " + method}
+	}
+}
+
+// controlResult turns the JSON answer of a library call into a result, or
+// into an error when it is {"error":...}.
+func controlResult(content string) (sjson.RawMessage, *controlError) {
+	var failure struct {
+		Error string `json:"error"`
+	}
+	if sjson.Unmarshal([]byte(content), &failure) == nil && failure.Error != "" {
+		return nil, &controlError{Code: controlErrorFailed, Message: failure.Error}
+	}
+	return sjson.RawMessage(content), nil
+}
+
+// takeCString copies and frees a string returned by one of our exports.
+func takeCString(content *C.char) string {
+	if content == nil {
+		return ""
+	}
+	defer C.free(unsafe.Pointer(content))
+	return C.GoString(content)
+}
+
+// selectOutbound switches the selector group groupTag of the running
+// instance to outboundTag.
+func selectOutbound(groupTag string, outboundTag string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		return errors.New("service not running")
+	}
+	out, loaded := instance.Outbound().Outbound(groupTag)
+	if !loaded {
+		return fmt.Errorf("outbound not found: %s", groupTag)
+	}
+	selector, isSelector := out.(*group.Selector)
+	if !isSelector {
+		return fmt.Errorf("outbound %s is not a selector group", groupTag)
+	}
+	if !selector.SelectOutbound(outboundTag) {
+		return fmt.Errorf("outbound %s is not in selector %s", outboundTag, groupTag)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import "C"
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/sagernet/sing-box/include"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+type dedupResult struct {
+	Outbounds []map[string]any `json:"outbounds"`
+	Removed   int              `json:"removed"`
+}
+
+// LibboxDedupOutbounds drops outbounds that connect exactly like an earlier
+// one, keeping the first occurrence and its tag. It accepts the same input as
+// LibboxTestBatch and returns {"outbounds":[...],"removed":n}. Two outbounds
+// are the same when their canonical forms, as LibboxCanonicalizeOutbound
+// writes them, are equal apart from the tag, with the server compared
+// case-insensitively.
+//
+//export LibboxDedupOutbounds
+func LibboxDedupOutbounds(outboundsJSON *C.char) *C.char {
+	var wrapper struct {
+		Outbounds []map[string]any `json:"outbounds"`
+	}
+	var rawOutbounds []map[string]any
+	configStr := C.GoString(outboundsJSON)
+	if err := sjson.Unmarshal([]byte(configStr), &wrapper); err == nil && len(wrapper.Outbounds) > 0 {
+		rawOutbounds = wrapper.Outbounds
+	} else if err := sjson.Unmarshal([]byte(configStr), &rawOutbounds); err != nil {
+		return jsonError("decode config error: %v", err)
+	}
+
+	ctx := include.Context(context.Background())
+	result := dedupResult{Outbounds: []map[string]any{}}
+	seen := make(map[[sha256.Size]byte]bool)
+	for _, outbound := range rawOutbounds {
+		fingerprint, err := connectionFingerprint(ctx, outbound)
+		if err != nil {
+			return jsonError("%v", err)
+		}
+		if seen[fingerprint] {
+			result.Removed++
+			continue
+		}
+		seen[fingerprint] = true
+		result.Outbounds = append(result.Outbounds, outbound)
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
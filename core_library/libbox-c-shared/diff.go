@@ -0,0 +1,192 @@
+package main
+
+import "C"
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/sagernet/sing-box/include"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// taggedDiff compares a list of tagged entries. Untagged entries are named
+// by their position, as "#<index>".
+type taggedDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// indexedDiff compares a list of untagged entries, such as rules, position
+// by position.
+type indexedDiff struct {
+	Added    []int `json:"added"`
+	Removed  []int `json:"removed"`
+	Modified []int `json:"modified"`
+}
+
+type sectionDiff struct {
+	// Servers or RuleSets are the tagged lists of the section.
+	Servers  *taggedDiff `json:"servers,omitempty"`
+	RuleSets *taggedDiff `json:"ruleSets,omitempty"`
+	Rules    indexedDiff `json:"rules"`
+	// Fields are the other fields of the section that changed.
+	Fields []string `json:"fields"`
+}
+
+type configDiff struct {
+	Changed   bool        `json:"changed"`
+	Inbounds  taggedDiff  `json:"inbounds"`
+	Outbounds taggedDiff  `json:"outbounds"`
+	Endpoints taggedDiff  `json:"endpoints"`
+	DNS       sectionDiff `json:"dns"`
+	Route     sectionDiff `json:"route"`
+	// Other are the remaining top-level sections that changed, such as log
+	// or experimental.
+	Other []string `json:"other"`
+	// OutboundsOnly is set when nothing but the outbounds changed, which
+	// LibboxReloadOutbounds can apply without a restart.
+	OutboundsOnly bool `json:"outboundsOnly"`
+}
+
+// LibboxDiffConfig compares two configs after canonicalizing both, so
+// formatting, shorthands and defaults don't count as changes. Inbounds,
+// outbounds, endpoints, DNS servers and rule-sets are matched by tag; route
+// and DNS rules by position. Returns {"changed","inbounds","outbounds",
+// "endpoints","dns","route","other","outboundsOnly"}, the lists holding
+// "added", "removed" and "modified" entries, or {"error"} when either config
+// fails to decode.
+//
+//export LibboxDiffConfig
+func LibboxDiffConfig(oldJSON *C.char, newJSON *C.char) *C.char {
+	ctx := include.Context(context.Background())
+	oldConfig, err := canonicalConfig(ctx, C.GoString(oldJSON))
+	if err != nil {
+		return jsonError("old config: %v", err)
+	}
+	newConfig, err := canonicalConfig(ctx, C.GoString(newJSON))
+	if err != nil {
+		return jsonError("new config: %v", err)
+	}
+	jsonBytes, err := sjson.Marshal(diffConfigs(oldConfig, newConfig))
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func diffConfigs(oldConfig map[string]any, newConfig map[string]any) configDiff {
+	diff := configDiff{
+		Inbounds:  diffTagged(oldConfig["inbounds"], newConfig["inbounds"]),
+		Outbounds: diffTagged(oldConfig["outbounds"], newConfig["outbounds"]),
+		Endpoints: diffTagged(oldConfig["endpoints"], newConfig["endpoints"]),
+		Other:     []string{},
+	}
+	oldDNS, _ := oldConfig["dns"].(map[string]any)
+	newDNS, _ := newConfig["dns"].(map[string]any)
+	servers := diffTagged(oldDNS["servers"], newDNS["servers"])
+	diff.DNS = sectionDiff{
+		Servers: &servers,
+		Rules:   diffIndexed(oldDNS["rules"], newDNS["rules"]),
+		Fields:  diffFields(oldDNS, newDNS, "servers", "rules"),
+	}
+	oldRoute, _ := oldConfig["route"].(map[string]any)
+	newRoute, _ := newConfig["route"].(map[string]any)
+	ruleSets := diffTagged(oldRoute["rule_set"], newRoute["rule_set"])
+	diff.Route = sectionDiff{
+		RuleSets: &ruleSets,
+		Rules:    diffIndexed(oldRoute["rules"], newRoute["rules"]),
+		Fields:   diffFields(oldRoute, newRoute, "rule_set", "rules"),
+	}
+	diff.Other = diffFields(oldConfig, newConfig, "inbounds", "outbounds", "endpoints", "dns", "route")
+
+	outbounds := !diff.Outbounds.empty()
+	rest := !diff.Inbounds.empty() || !diff.Endpoints.empty() || !diff.DNS.empty() || !diff.Route.empty() || len(diff.Other) > 0
+	diff.Changed = outbounds || rest
+	diff.OutboundsOnly = outbounds && !rest
+	return diff
+}
+
+func diffTagged(oldValue any, newValue any) taggedDiff {
+	entries := func(value any) (map[string]any, []string) {
+		list, _ := value.([]any)
+		byTag := make(map[string]any, len(list))
+		order := make([]string, 0, len(list))
+		for i, entry := range list {
+			object, _ := entry.(map[string]any)
+			tag, _ := object["tag"].(string)
+			if tag == "" {
+				tag = fmt.Sprintf("#%d", i)
+			}
+			byTag[tag] = entry
+			order = append(order, tag)
+		}
+		return byTag, order
+	}
+	oldEntries, oldOrder := entries(oldValue)
+	newEntries, newOrder := entries(newValue)
+	diff := taggedDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	for _, tag := range newOrder {
+		oldEntry, loaded := oldEntries[tag]
+		if !loaded {
+			diff.Added = append(diff.Added, tag)
+		} else if !reflect.DeepEqual(oldEntry, newEntries[tag]) {
+			diff.Modified = append(diff.Modified, tag)
+		}
+	}
+	for _, tag := range oldOrder {
+		if _, loaded := newEntries[tag]; !loaded {
+			diff.Removed = append(diff.Removed, tag)
+		}
+	}
+	return diff
+}
+
+func diffIndexed(oldValue any, newValue any) indexedDiff {
+	oldList, _ := oldValue.([]any)
+	newList, _ := newValue.([]any)
+	diff := indexedDiff{Added: []int{}, Removed: []int{}, Modified: []int{}}
+	for i := range max(len(oldList), len(newList)) {
+		switch {
+		case i >= len(oldList):
+			diff.Added = append(diff.Added, i)
+		case i >= len(newList):
+			diff.Removed = append(diff.Removed, i)
+		case !reflect.DeepEqual(oldList[i], newList[i]):
+			diff.Modified = append(diff.Modified, i)
+		}
+	}
+	return diff
+}
+
+// diffFields returns the sorted keys of two objects whose values differ,
+// leaving out the keys compared separately.
+func diffFields(oldObject map[string]any, newObject map[string]any, skip ...string) []string {
+	fields := []string{}
+	for key, value := range oldObject {
+		if !slices.Contains(skip, key) && !reflect.DeepEqual(value, newObject[key]) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range newObject {
+		if _, loaded := oldObject[key]; !loaded && !slices.Contains(skip, key) {
+			fields = append(fields, key)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+func (d taggedDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+func (d indexedDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+func (d sectionDiff) empty() bool {
+	return (d.Servers == nil || d.Servers.empty()) && (d.RuleSets == nil || d.RuleSets.empty()) && d.Rules.empty() && len(d.Fields) == 0
+}
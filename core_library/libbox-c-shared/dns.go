@@ -0,0 +1,233 @@
+package main
+
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/bufio"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/service"
+)
+
+const dnsResolveTimeout = 10 * time.Second
+
+// dnsQueryTypes are the query types LibboxResolveDNS accepts.
+var dnsQueryTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"MX":    dns.TypeMX,
+	"NS":    dns.TypeNS,
+	"TXT":   dns.TypeTXT,
+}
+
+type dnsRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+type dnsResult struct {
+	Domain  string      `json:"domain"`
+	Type    string      `json:"type"`
+	Rcode   string      `json:"rcode"`
+	Answers []dnsRecord `json:"answers"`
+}
+
+// LibboxResolveDNS resolves domain through the DNS router of the running
+// instance, so DNS rules apply as they would for real traffic. queryType is
+// one of A (the default when empty), AAAA, CNAME, MX, NS and TXT; the answers
+// come back with their TTLs and record data in presentation format.
+//
+//export LibboxResolveDNS
+func LibboxResolveDNS(domain *C.char, queryType *C.char) *C.char {
+	mu.Lock()
+	ctx := instanceCtx
+	mu.Unlock()
+	if ctx == nil {
+		return jsonError("service not running")
+	}
+	dnsRouter := service.FromContext[adapter.DNSRouter](ctx)
+	if dnsRouter == nil {
+		return jsonError("dns router not available")
+	}
+
+	name, typeName, message, err := newDNSQuery(C.GoString(domain), C.GoString(queryType))
+	if err != nil {
+		return jsonError("%v", err)
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, dnsResolveTimeout)
+	defer cancel()
+	response, err := dnsRouter.Exchange(queryCtx, message, adapter.DNSQueryOptions{})
+	if err != nil {
+		return jsonError("exchange error: %v", err)
+	}
+
+	jsonBytes, err := sjson.Marshal(newDNSResult(name, typeName, response))
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func newDNSQuery(domain string, queryType string) (string, string, *dns.Msg, error) {
+	typeName := strings.ToUpper(strings.TrimSpace(queryType))
+	if typeName == "" {
+		typeName = "A"
+	}
+	qtype, supported := dnsQueryTypes[typeName]
+	if !supported {
+		return "", "", nil, fmt.Errorf("unsupported query type: %s", typeName)
+	}
+	name := strings.TrimSpace(domain)
+	if name == "" {
+		return "", "", nil, errors.New("empty domain")
+	}
+	message := new(dns.Msg)
+	message.SetQuestion(dns.Fqdn(name), qtype)
+	message.RecursionDesired = true
+	return name, typeName, message, nil
+}
+
+func newDNSResult(domain string, typeName string, response *dns.Msg) dnsResult {
+	result := dnsResult{
+		Domain:  domain,
+		Type:    typeName,
+		Rcode:   dns.RcodeToString[response.Rcode],
+		Answers: []dnsRecord{},
+	}
+	for _, rr := range response.Answer {
+		header := rr.Header()
+		result.Answers = append(result.Answers, dnsRecord{
+			Name: header.Name,
+			Type: dns.TypeToString[header.Rrtype],
+			TTL:  header.Ttl,
+			Data: strings.TrimPrefix(rr.String(), header.String()),
+		})
+	}
+	return result
+}
+
+// defaultDetourDNSServer is queried by LibboxResolveDNSVia when no server is
+// given.
+const defaultDetourDNSServer = "1.1.1.1:53"
+
+type detourDNSResult struct {
+	dnsResult
+	Server    string `json:"server"`
+	Network   string `json:"network"`
+	Outbound  string `json:"outbound"`
+	Exit      string `json:"exit"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// LibboxResolveDNSVia sends a plain DNS query for domain to server (host or
+// host:port, 1.1.1.1:53 by default) through the named outbound of the running
+// instance, bypassing DNS rules, to check a node's DNS on its own. The query
+// uses UDP and falls back to TCP when the outbound has no UDP support. "exit"
+// names the outbound that actually carried the query when detour is a group.
+//
+//export LibboxResolveDNSVia
+func LibboxResolveDNSVia(domain *C.char, queryType *C.char, detour *C.char, server *C.char) *C.char {
+	mu.Lock()
+	ctx := instanceCtx
+	runningInstance := instance
+	mu.Unlock()
+	if ctx == nil || runningInstance == nil {
+		return jsonError("service not running")
+	}
+
+	detourTag := C.GoString(detour)
+	out, loaded := runningInstance.Outbound().Outbound(detourTag)
+	if !loaded {
+		return jsonError("outbound not found: %s", detourTag)
+	}
+	serverAddr := strings.TrimSpace(C.GoString(server))
+	if serverAddr == "" {
+		serverAddr = defaultDetourDNSServer
+	}
+	destination := metadata.ParseSocksaddr(serverAddr)
+	if destination.Port == 0 {
+		destination = metadata.ParseSocksaddrHostPort(serverAddr, 53)
+	}
+	if !destination.IsValid() {
+		return jsonError("invalid dns server: %s", serverAddr)
+	}
+	name, typeName, message, err := newDNSQuery(C.GoString(domain), C.GoString(queryType))
+	if err != nil {
+		return jsonError("%v", err)
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, dnsResolveTimeout)
+	defer cancel()
+
+	network := N.NetworkUDP
+	if !common.Contains(out.Network(), N.NetworkUDP) {
+		network = N.NetworkTCP
+	}
+	start := time.Now()
+	response, err := exchangeThrough(queryCtx, out, network, destination, message)
+	if err != nil {
+		return jsonError("exchange error: %v", err)
+	}
+
+	result := detourDNSResult{
+		dnsResult: newDNSResult(name, typeName, response),
+		Server:    destination.String(),
+		Network:   network,
+		Outbound:  out.Tag(),
+		Exit:      outboundExit(runningInstance.Outbound(), out),
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func exchangeThrough(ctx context.Context, out adapter.Outbound, network string, destination metadata.Socksaddr, message *dns.Msg) (*dns.Msg, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if network == N.NetworkUDP {
+		var packetConn net.PacketConn
+		packetConn, err = out.ListenPacket(ctx, destination)
+		if err == nil {
+			conn = bufio.NewBindPacketConn(packetConn, destination.UDPAddr())
+		}
+	} else {
+		conn, err = out.DialContext(ctx, N.NetworkTCP, destination)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, loaded := ctx.Deadline(); loaded {
+		conn.SetDeadline(deadline)
+	}
+	dnsConn := &dns.Conn{Conn: conn}
+	if err := dnsConn.WriteMsg(message); err != nil {
+		return nil, err
+	}
+	return dnsConn.ReadMsg()
+}
+
+// outboundExit follows group selections down to the outbound that really
+// carries traffic for out.
+func outboundExit(manager adapter.OutboundManager, out adapter.Outbound) string {
+	return leafOutbound(manager, out).Tag()
+}
@@ -0,0 +1,212 @@
+package main
+
+import "C"
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sagernet/sing-box/adapter"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/service"
+)
+
+// dnsEchoQueries name DNS-echo endpoints whose answer is the address of the
+// recursive resolver that asked their authoritative servers:
+// whoami.akamai.net (A), o-o.myaddr.l.google.com (TXT) and
+// resolver.dnscrypt.info (TXT, "Resolver IP: <addr>").
+var dnsEchoQueries = []struct {
+	Domain string
+	Type   uint16
+}{
+	{"whoami.akamai.net", dns.TypeA},
+	{"o-o.myaddr.l.google.com", dns.TypeTXT},
+	{"resolver.dnscrypt.info", dns.TypeTXT},
+}
+
+type dnsLeakResult struct {
+	ExitIP      string        `json:"exitIp,omitempty"`
+	ExitCountry string        `json:"exitCountry"`
+	Resolvers   []dnsResolver `json:"resolvers"`
+	Leak        *bool         `json:"leak"`
+	Errors      []string      `json:"errors,omitempty"`
+}
+
+type dnsResolver struct {
+	IP      string `json:"ip"`
+	Country string `json:"country"`
+	Via     string `json:"via"`
+	// Expected is nil when the countries can't be compared.
+	Expected *bool `json:"expected"`
+}
+
+// LibboxDNSLeakTest resolves the DNS-echo domains of dnsEchoQueries through
+// the DNS router of the running instance, bypassing the cache, and lists the
+// resolvers that answered. Each is compared with the exit of the instance,
+// found by asking the LibboxSetIPEchoURL endpoint through the router like
+// any app traffic: a resolver in another country than the exit is not the
+// proxied one and is flagged with "expected": false, which sets "leak" to
+// true. "leak" is false once every resolver was found in the exit's country,
+// and null when that can't be told: without LibboxSetGeoIPDirectory, when
+// the exit IP lookup failed or no resolver answered, "expected" and "leak"
+// stay null. The country match is a heuristic: a resolver outside the
+// tunnel that happens to be in the exit's country, such as the ISP's when
+// the node is in the same country, is never flagged. Queries that fail are
+// listed in "errors".
+//
+//export LibboxDNSLeakTest
+func LibboxDNSLeakTest(timeoutMS C.longlong) *C.char {
+	mu.Lock()
+	ctx := instanceCtx
+	var router adapter.Router
+	if instance != nil {
+		router = instance.Router()
+	}
+	mu.Unlock()
+	if ctx == nil || router == nil {
+		return jsonError("service not running")
+	}
+	dnsRouter := service.FromContext[adapter.DNSRouter](ctx)
+	if dnsRouter == nil {
+		return jsonError("dns router not available")
+	}
+
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	testCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		result   = dnsLeakResult{Resolvers: []dnsResolver{}}
+		access   sync.Mutex
+		wg       sync.WaitGroup
+		exitAddr netip.Addr
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		addr, err := routedExitIP(testCtx, router, timeout)
+		access.Lock()
+		defer access.Unlock()
+		if err != nil {
+			result.Errors = append(result.Errors, "exit ip: "+err.Error())
+			return
+		}
+		exitAddr = addr
+	}()
+	for _, query := range dnsEchoQueries {
+		wg.Add(1)
+		go func(domain string, queryType uint16) {
+			defer wg.Done()
+			message := new(dns.Msg)
+			message.SetQuestion(dns.Fqdn(domain), queryType)
+			message.RecursionDesired = true
+			response, err := dnsRouter.Exchange(testCtx, message, adapter.DNSQueryOptions{DisableCache: true})
+			access.Lock()
+			defer access.Unlock()
+			if err != nil {
+				result.Errors = append(result.Errors, domain+": "+err.Error())
+				return
+			}
+			for _, addr := range echoedResolvers(response) {
+				result.Resolvers = append(result.Resolvers, dnsResolver{
+					IP:  addr.String(),
+					Via: domain,
+				})
+			}
+		}(query.Domain, query.Type)
+	}
+	wg.Wait()
+
+	result.ExitCountry = "unknown"
+	if exitAddr.IsValid() {
+		result.ExitIP = exitAddr.String()
+		result.ExitCountry = lookupGeoIP(exitAddr)
+	}
+	leak := false
+	compared := 0
+	for i := range result.Resolvers {
+		resolver := &result.Resolvers[i]
+		resolver.Country = lookupGeoIP(netip.MustParseAddr(resolver.IP))
+		if resolver.Country == "unknown" || result.ExitCountry == "unknown" {
+			continue
+		}
+		expected := resolver.Country == result.ExitCountry
+		resolver.Expected = &expected
+		leak = leak || !expected
+		compared++
+	}
+	if leak || compared > 0 && compared == len(result.Resolvers) {
+		result.Leak = &leak
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// echoedResolvers reads the resolver addresses out of an echo answer: A and
+// AAAA records as they are, and TXT strings whose last word is an address.
+func echoedResolvers(response *dns.Msg) []netip.Addr {
+	var addresses []netip.Addr
+	for _, rr := range response.Answer {
+		switch record := rr.(type) {
+		case *dns.A:
+			if addr, ok := netip.AddrFromSlice(record.A); ok {
+				addresses = append(addresses, addr.Unmap())
+			}
+		case *dns.AAAA:
+			if addr, ok := netip.AddrFromSlice(record.AAAA); ok {
+				addresses = append(addresses, addr)
+			}
+		case *dns.TXT:
+			for _, text := range record.Txt {
+				fields := strings.Fields(text)
+				if len(fields) == 0 {
+					continue
+				}
+				// o-o.myaddr also echoes the client subnet, as a prefix
+				if addr, err := netip.ParseAddr(fields[len(fields)-1]); err == nil {
+					addresses = append(addresses, addr.Unmap())
+				}
+			}
+		}
+	}
+	return addresses
+}
+
+// routedExitIP asks the IP echo endpoint for the address the running
+// instance's router sends the request out from.
+func routedExitIP(ctx context.Context, router adapter.Router, timeout time.Duration) (netip.Addr, error) {
+	ipEchoURLAccess.Lock()
+	target := ipEchoURL
+	ipEchoURLAccess.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	resp, err := outboundHTTPClient(&routedDialer{router: router}, timeout).Do(req)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
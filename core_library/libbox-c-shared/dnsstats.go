@@ -0,0 +1,187 @@
+package main
+
+import "C"
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/miekg/dns"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/contrab/freelru"
+	"github.com/sagernet/sing/service"
+)
+
+type dnsServerStats struct {
+	Queries uint64 `json:"queries"`
+	Errors  uint64 `json:"errors"`
+}
+
+type dnsStats struct {
+	CacheEnabled bool                      `json:"cacheEnabled"`
+	Hits         uint64                    `json:"hits"`
+	Misses       uint64                    `json:"misses"`
+	Entries      int                       `json:"entries"`
+	Servers      map[string]dnsServerStats `json:"servers"`
+}
+
+// dnsQueryCounters count the queries sent to one DNS server, after the
+// cache.
+type dnsQueryCounters struct {
+	queries atomic.Uint64
+	errors  atomic.Uint64
+}
+
+// instanceDNSCounters holds the counters of the running instance by server
+// tag. It is set together with instance.
+var instanceDNSCounters map[string]*dnsQueryCounters
+
+// LibboxGetDNSStats reports the DNS cache of the running instance, hits,
+// misses and the entries it holds, and the queries each DNS server of the
+// config was sent, with how many failed, to tell a working DoH server from
+// one the cache hides. Everything is zero when the service is not running or
+// the cache is disabled.
+//
+//export LibboxGetDNSStats
+func LibboxGetDNSStats() *C.char {
+	mu.Lock()
+	ctx := instanceCtx
+	counters := instanceDNSCounters
+	mu.Unlock()
+
+	stats := dnsStats{Servers: make(map[string]dnsServerStats)}
+	if ctx != nil {
+		for tag, counter := range counters {
+			stats.Servers[tag] = dnsServerStats{
+				Queries: counter.queries.Load(),
+				Errors:  counter.errors.Load(),
+			}
+		}
+		for _, cache := range dnsClientCaches(ctx) {
+			metrics := cache.Metrics()
+			stats.CacheEnabled = true
+			stats.Hits += metrics.Hits
+			stats.Misses += metrics.Misses
+			stats.Entries += cache.Len()
+		}
+	}
+	jsonBytes, err := sjson.Marshal(stats)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// dnsCache is what the stats need of the caches of sing-box's DNS client,
+// whose keys differ.
+type dnsCache interface {
+	Len() int
+	Metrics() freelru.Metrics
+}
+
+// dnsClientCaches returns the caches the DNS client of the router in ctx
+// uses: the shared one, or the per-server one with independent_cache. Both
+// are unexported and nil while caching is disabled.
+func dnsClientCaches(ctx context.Context) []dnsCache {
+	router := service.FromContext[adapter.DNSRouter](ctx)
+	if router == nil {
+		return nil
+	}
+	client := unexportedField(reflect.ValueOf(router), "client")
+	if !client.IsValid() || client.IsNil() {
+		return nil
+	}
+	var caches []dnsCache
+	for _, name := range []string{"cache", "transportCache"} {
+		field := unexportedField(client.Elem(), name)
+		if !field.IsValid() || field.IsNil() {
+			continue
+		}
+		if cache, isCache := field.Interface().(dnsCache); isCache {
+			caches = append(caches, cache)
+		}
+	}
+	return caches
+}
+
+// unexportedField returns the named field of the struct value points to,
+// made readable and writable.
+func unexportedField(value reflect.Value, name string) reflect.Value {
+	for value.Kind() == reflect.Interface || value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	field := value.FieldByName(name)
+	if !field.IsValid() || !field.CanAddr() {
+		return reflect.Value{}
+	}
+	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+}
+
+// countingDNSTransport counts the queries a DNS server is sent.
+type countingDNSTransport struct {
+	adapter.DNSTransport
+	counters *dnsQueryCounters
+}
+
+func (t *countingDNSTransport) Exchange(ctx context.Context, message *dns.Msg) (*dns.Msg, error) {
+	t.counters.queries.Add(1)
+	response, err := t.DNSTransport.Exchange(ctx, message)
+	if err != nil {
+		t.counters.errors.Add(1)
+	}
+	return response, err
+}
+
+// countingLegacyDNSTransport keeps the legacy strategy and client subnet of
+// a legacy server visible to the router.
+type countingLegacyDNSTransport struct {
+	*countingDNSTransport
+	adapter.LegacyDNSTransport
+}
+
+// installDNSCounters wraps the DNS servers the transport manager in ctx
+// created from the config, before the box starts, so their queries are
+// counted. The fakeip server, which sends no queries, is left alone.
+func installDNSCounters(ctx context.Context) map[string]*dnsQueryCounters {
+	counters := make(map[string]*dnsQueryCounters)
+	manager := service.FromContext[adapter.DNSTransportManager](ctx)
+	if manager == nil {
+		return counters
+	}
+	transports := unexportedField(reflect.ValueOf(manager), "transports")
+	byTag := unexportedField(reflect.ValueOf(manager), "transportByTag")
+	defaultTransport := unexportedField(reflect.ValueOf(manager), "defaultTransport")
+	if !transports.IsValid() || !byTag.IsValid() || !defaultTransport.IsValid() {
+		return counters
+	}
+	for i := range transports.Len() {
+		transport, isTransport := transports.Index(i).Interface().(adapter.DNSTransport)
+		if !isTransport || transport == nil || transport.Type() == constant.DNSTypeFakeIP {
+			continue
+		}
+		counter := &dnsQueryCounters{}
+		counters[transport.Tag()] = counter
+		var wrapped adapter.DNSTransport = &countingDNSTransport{DNSTransport: transport, counters: counter}
+		if legacy, isLegacy := transport.(adapter.LegacyDNSTransport); isLegacy {
+			wrapped = &countingLegacyDNSTransport{
+				countingDNSTransport: wrapped.(*countingDNSTransport),
+				LegacyDNSTransport:   legacy,
+			}
+		}
+		transports.Index(i).Set(reflect.ValueOf(&wrapped).Elem())
+		byTag.SetMapIndex(reflect.ValueOf(transport.Tag()), reflect.ValueOf(&wrapped).Elem())
+		if current, _ := defaultTransport.Interface().(adapter.DNSTransport); current == transport {
+			defaultTransport.Set(reflect.ValueOf(&wrapped).Elem())
+		}
+	}
+	return counters
+}
@@ -0,0 +1,74 @@
+package main
+
+import "C"
+import (
+	"time"
+
+	"github.com/sagernet/sing-box/constant"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+const drainPollInterval = 100 * time.Millisecond
+
+type drainResult struct {
+	Drained     int    `json:"drained"`
+	ForceClosed int    `json:"forceClosed"`
+	Error       string `json:"error,omitempty"`
+}
+
+// LibboxStopGraceful stops the service without cutting connections short
+// where it can: listening inbounds are closed so no new connections arrive,
+// connections still coming in through a TUN inbound are refused, and the open
+// ones get up to timeoutMS to finish before the instance is closed. The rest
+// of the library keeps answering while it waits. The result counts the
+// connections that finished in time and those closed by force.
+//
+//export LibboxStopGraceful
+func LibboxStopGraceful(timeoutMS C.longlong) *C.char {
+	mu.Lock()
+	stopWatching()
+	if instance == nil {
+		mu.Unlock()
+		return jsonError("service not running")
+	}
+
+	draining := instance
+	var result drainResult
+	if tracker := instanceConnections; tracker != nil {
+		if tracker.draining.Swap(true) {
+			mu.Unlock()
+			return jsonError("service already stopping")
+		}
+		inboundManager := instance.Inbound()
+		for _, inbound := range inboundManager.Inbounds() {
+			// closing a TUN inbound tears down every flow inside it
+			if inbound.Type() != constant.TypeTun {
+				inboundManager.Remove(inbound.Tag())
+			}
+		}
+
+		open := tracker.liveCount()
+		// wait without mu, the tracker has its own locks
+		mu.Unlock()
+		deadline := time.Now().Add(time.Duration(timeoutMS) * time.Millisecond)
+		for tracker.liveCount() > 0 && time.Now().Before(deadline) {
+			time.Sleep(drainPollInterval)
+		}
+		result.ForceClosed = tracker.liveCount()
+		result.Drained = max(open-result.ForceClosed, 0)
+		mu.Lock()
+	}
+	defer mu.Unlock()
+
+	if instance != draining {
+		// LibboxStop got there first
+		result.Error = "service stopped while draining"
+	} else if err := stopInstance(); err != nil {
+		result.Error = err.Error()
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
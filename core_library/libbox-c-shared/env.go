@@ -0,0 +1,65 @@
+package main
+
+import "C"
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+var configVariablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// LibboxStartWithEnv starts like LibboxStart, reporting failures with the
+// envelope of LibboxStartWithTimeout, after replacing ${NAME} in configJSON
+// so secrets need not be written into the config. Values come from varsJSON,
+// a JSON object of strings that may be NULL or empty, and then from the
+// process environment. They are inserted escaped as JSON string content, so
+// references belong inside strings. Unknown names are left as they are, or
+// fail with INVALID_CONFIG when strict is non-zero.
+//
+//export LibboxStartWithEnv
+func LibboxStartWithEnv(configJSON *C.char, logFD C.longlong, varsJSON *C.char, strict C.int) *C.char {
+	var vars map[string]string
+	if varsStr := strings.TrimSpace(C.GoString(varsJSON)); varsStr != "" {
+		if err := sjson.Unmarshal([]byte(varsStr), &vars); err != nil {
+			return newCodedError(errorCodeInvalidConfig, "decode variables error: %s", err).envelope()
+		}
+	}
+	configStr, err := expandConfigVariables(C.GoString(configJSON), vars, strict != 0)
+	if err != nil {
+		return newCodedError(errorCodeInvalidConfig, "%s", err).envelope()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	redirectLog(logFD)
+	if err := startDesktop(configStr, 0); err != nil {
+		return err.envelope()
+	}
+	return nil
+}
+
+func expandConfigVariables(configStr string, vars map[string]string, strict bool) (string, error) {
+	var undefined []string
+	expanded := configVariablePattern.ReplaceAllStringFunc(configStr, func(reference string) string {
+		name := reference[2 : len(reference)-1]
+		value, loaded := vars[name]
+		if !loaded {
+			value, loaded = os.LookupEnv(name)
+		}
+		if !loaded {
+			undefined = append(undefined, name)
+			return reference
+		}
+		quoted, _ := sjson.Marshal(value)
+		return string(quoted[1 : len(quoted)-1])
+	})
+	if strict && len(undefined) > 0 {
+		return "", fmt.Errorf("undefined config variables: %s", strings.Join(undefined, ", "))
+	}
+	return expanded, nil
+}
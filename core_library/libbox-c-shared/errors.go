@@ -0,0 +1,73 @@
+package main
+
+import "C"
+import (
+	"errors"
+	"fmt"
+
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// Codes of the error envelope returned by LibboxValidateConfig and the
+// LibboxStart variants other than LibboxStart and LibboxStartMobile, which
+// use it only for ALREADY_RUNNING.
+const (
+	errorCodeAlreadyRunning = "ALREADY_RUNNING"
+	errorCodeFileRead       = "FILE_READ"
+	errorCodeDecompress     = "DECOMPRESS"
+	errorCodeFetch          = "FETCH_FAILED"
+	errorCodeInvalidConfig  = "INVALID_CONFIG"
+	errorCodeDuplicateTag   = "DUPLICATE_TAG"
+	errorCodeCreate         = "CREATE_FAILED"
+	errorCodeStart          = "START_FAILED"
+	errorCodeTimeout        = "START_TIMEOUT"
+	errorCodePortInUse      = "PORT_IN_USE"
+	errorCodeTunPermission  = "TUN_PERMISSION"
+)
+
+// codedError is a failure the host can tell apart by Code. PORT_IN_USE
+// additionally names the network and the address that could not be bound,
+// DUPLICATE_TAG the tags used more than once, INVALID_CONFIG, when it is
+// known, the line, column and JSON path of the problem, and ALREADY_RUNNING
+// the uptime of the instance in the way.
+type codedError struct {
+	Code          string   `json:"code"`
+	Message       string   `json:"message"`
+	UptimeSeconds int64    `json:"uptimeSeconds,omitempty"`
+	Network       string   `json:"network,omitempty"`
+	Address       string   `json:"address,omitempty"`
+	Port          int      `json:"port,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	Line          int      `json:"line,omitempty"`
+	Column        int      `json:"column,omitempty"`
+	Path          string   `json:"path,omitempty"`
+}
+
+func (e *codedError) Error() string {
+	return e.Message
+}
+
+func newCodedError(code string, format string, args ...any) *codedError {
+	return &codedError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// invalidConfigError is INVALID_CONFIG for an error of decodeConfig,
+// carrying its position.
+func invalidConfigError(err error) *codedError {
+	result := newCodedError(errorCodeInvalidConfig, "decode config error: %s", err)
+	var located *configError
+	if errors.As(err, &located) {
+		result.Line = located.Line
+		result.Column = located.Column
+		result.Path = located.Path
+	}
+	return result
+}
+
+func (e *codedError) envelope() *C.char {
+	content, err := sjson.Marshal(e)
+	if err != nil {
+		return C.CString("{\"code\": \"" + e.Code + "\", \"message\": \"internal error\"}")
+	}
+	return C.CString(string(content))
+}
@@ -0,0 +1,110 @@
+package main
+
+import "C"
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing-box/include"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// defaultIPEchoURL answers with the caller's address as plain text over
+// both IPv4 and IPv6.
+const defaultIPEchoURL = "https://api64.ipify.org"
+
+var (
+	ipEchoURL       = defaultIPEchoURL
+	ipEchoURLAccess sync.Mutex
+)
+
+type exitIPResult struct {
+	IP        string `json:"ip"`
+	Family    string `json:"family"`
+	Country   string `json:"country"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// LibboxSetIPEchoURL replaces the endpoint used by LibboxGetOutboundIP. It
+// must answer with the client address as plain text. An empty string
+// restores the default.
+//
+//export LibboxSetIPEchoURL
+func LibboxSetIPEchoURL(url *C.char) {
+	ipEchoURLAccess.Lock()
+	defer ipEchoURLAccess.Unlock()
+	ipEchoURL = C.GoString(url)
+	if ipEchoURL == "" {
+		ipEchoURL = defaultIPEchoURL
+	}
+}
+
+// LibboxGetOutboundIP reports the address the outbound exits from, with its
+// country when a geoip directory is configured.
+//
+//export LibboxGetOutboundIP
+func LibboxGetOutboundIP(outboundJSON *C.char, timeoutMS C.longlong) *C.char {
+	configStr := C.GoString(outboundJSON)
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+
+	ipEchoURLAccess.Lock()
+	target := ipEchoURL
+	ipEchoURLAccess.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-outbound", currentLogLevel)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	defer tempInstance.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return jsonError("create request error: %v", err)
+	}
+
+	start := time.Now()
+	resp, err := outboundHTTPClient(out, timeout).Do(req)
+	if err != nil {
+		return jsonError("request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return jsonError("read body error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return jsonError("unexpected status code: %d", resp.StatusCode)
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return jsonError("invalid ip echo response: %q", strings.TrimSpace(string(body)))
+	}
+	addr = addr.Unmap()
+
+	result := exitIPResult{
+		IP:        addr.String(),
+		Family:    "ipv4",
+		Country:   lookupGeoIP(addr),
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if addr.Is6() {
+		result.Family = "ipv6"
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
@@ -0,0 +1,132 @@
+package main
+
+import "C"
+import (
+	"reflect"
+	"strings"
+	"unsafe"
+
+	"github.com/sagernet/sing-box/adapter"
+	R "github.com/sagernet/sing-box/route/rule"
+	sjson "github.com/sagernet/sing/common/json"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+type explainResult struct {
+	routeResult
+	Rules []evaluatedRule `json:"rules"`
+}
+
+type evaluatedRule struct {
+	Index     int              `json:"index"`
+	Rule      string           `json:"rule"`
+	Action    string           `json:"action"`
+	Matched   bool             `json:"matched"`
+	MatchedBy []matchCondition `json:"matchedBy,omitempty"`
+}
+
+// matchCondition is one condition of a rule that the destination satisfied,
+// e.g. {"field":"domain_suffix","condition":"domain_suffix=example.com"}.
+type matchCondition struct {
+	Field     string `json:"field"`
+	Condition string `json:"condition"`
+}
+
+// ruleItemFields are the fields of sing-box's default rule holding the
+// conditions, in the order the rule checks them.
+var ruleItemFields = []string{
+	"destinationAddressItems",
+	"destinationIPCIDRItems",
+	"destinationPortItems",
+	"items",
+}
+
+// LibboxExplainRoute is LibboxRouteDestination for TCP with every rule that
+// was evaluated listed in order under "rules", each marked matched or not.
+// Matched rules name the conditions the destination satisfied in
+// "matchedBy"; inverted and logical rules only report that they matched.
+// When nothing matches, ruleIndex is -1 and the default outbound is given.
+//
+//export LibboxExplainRoute
+func LibboxExplainRoute(host *C.char, port C.int) *C.char {
+	destination := M.ParseSocksaddrHostPort(C.GoString(host), uint16(port))
+	if !destination.IsValid() {
+		return jsonError("invalid destination")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		return jsonError("service not running")
+	}
+	result := explainResult{Rules: []evaluatedRule{}}
+	result.routeResult = dryRoute(N.NetworkTCP, destination, func(index int, rule adapter.Rule, metadata *adapter.InboundContext, matched bool) {
+		evaluated := evaluatedRule{
+			Index:   index,
+			Rule:    rule.String(),
+			Action:  rule.Action().String(),
+			Matched: matched,
+		}
+		if matched {
+			evaluated.MatchedBy = matchedConditions(rule, metadata)
+		}
+		result.Rules = append(result.Rules, evaluated)
+	})
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// matchedConditions re-checks the conditions of a matched default rule one
+// by one. sing-box keeps them unexported, so they are read by reflection.
+func matchedConditions(rule adapter.Rule, metadata *adapter.InboundContext) []matchCondition {
+	defaultRule, isDefault := rule.(*R.DefaultRule)
+	if !isDefault {
+		return nil
+	}
+	value := reflect.ValueOf(defaultRule).Elem()
+	if invert := value.FieldByName("invert"); !invert.IsValid() || invert.Kind() != reflect.Bool || invert.Bool() {
+		return nil
+	}
+	var conditions []matchCondition
+	for _, name := range ruleItemFields {
+		for _, item := range readRuleItems(value.FieldByName(name)) {
+			metadata.ResetRuleCache()
+			if item.Match(metadata) {
+				conditions = append(conditions, newMatchCondition(item))
+				if name != "items" {
+					// any one item of a destination field is enough
+					break
+				}
+			}
+		}
+	}
+	if ruleSetItem := readRuleItem(value.FieldByName("ruleSetItem")); ruleSetItem != nil {
+		conditions = append(conditions, newMatchCondition(ruleSetItem))
+	}
+	return conditions
+}
+
+func readRuleItems(field reflect.Value) []R.RuleItem {
+	if !field.IsValid() || field.Type() != reflect.TypeOf([]R.RuleItem(nil)) {
+		return nil
+	}
+	return *(*[]R.RuleItem)(unsafe.Pointer(field.UnsafeAddr()))
+}
+
+func readRuleItem(field reflect.Value) R.RuleItem {
+	if !field.IsValid() || field.Type() != reflect.TypeOf((*R.RuleItem)(nil)).Elem() {
+		return nil
+	}
+	return *(*R.RuleItem)(unsafe.Pointer(field.UnsafeAddr()))
+}
+
+func newMatchCondition(item R.RuleItem) matchCondition {
+	description := item.String()
+	field, _, _ := strings.Cut(description, "=")
+	return matchCondition{Field: field, Condition: description}
+}
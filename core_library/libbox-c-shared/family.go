@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// testResolverTag names the DNS server a test box resolves through when the
+// test sets a domain strategy.
+const testResolverTag = "test-resolver"
+
+// applyTestDomainStrategy resolves every domain the test box dials, the
+// node's server and, for a direct outbound, the target, with the system
+// resolver under strategy (prefer_ipv4, prefer_ipv6, ipv4_only or
+// ipv6_only), so the address family doesn't depend on the resolver's mood.
+func applyTestDomainStrategy(options *option.Options, strategy string) error {
+	var domainStrategy option.DomainStrategy
+	if err := domainStrategy.UnmarshalJSON([]byte(strconv.Quote(strategy))); err != nil {
+		return fmt.Errorf("invalid domainStrategy: %v", err)
+	}
+	options.DNS = &option.DNSOptions{
+		RawDNSOptions: option.RawDNSOptions{
+			Servers: []option.DNSServerOptions{{
+				Type:    constant.DNSTypeLocal,
+				Tag:     testResolverTag,
+				Options: &option.LocalDNSServerOptions{},
+			}},
+		},
+	}
+	options.Route = &option.RouteOptions{
+		DefaultDomainResolver: &option.DomainResolveOptions{
+			Server:   testResolverTag,
+			Strategy: domainStrategy,
+		},
+	}
+	return nil
+}
+
+// testFamily returns the family the probe's connection was dialed over, as
+// recorded by familyDialer, when the test asked for it by setting a domain
+// strategy.
+func testFamily(tempInstance *trackedBox, dialed string) string {
+	queryOptions := tempInstance.Network().DefaultOptions().DomainResolveOptions
+	if queryOptions.Strategy == constant.DomainStrategyAsIS {
+		return ""
+	}
+	return dialed
+}
+
+// familyDialer records the address family of the socket under the last
+// connection it dialed: the one to the server of the hop of the chain that
+// dials directly, or to the target for a direct outbound.
+type familyDialer struct {
+	N.Dialer
+	access sync.Mutex
+	family string
+}
+
+func (d *familyDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, destination)
+	if err != nil {
+		return nil, err
+	}
+	if family := connFamily(conn); family != "" {
+		d.access.Lock()
+		d.family = family
+		d.access.Unlock()
+	}
+	return conn, nil
+}
+
+func (d *familyDialer) dialedFamily() string {
+	d.access.Lock()
+	defer d.access.Unlock()
+	return d.family
+}
+
+// connFamily returns the family of the TCP socket conn wraps, or "" when
+// it doesn't wrap one, like the pipe of a routed test.
+func connFamily(conn net.Conn) string {
+	tcpConn, isTCP := common.Top(conn).(*net.TCPConn)
+	if !isTCP {
+		return ""
+	}
+	remoteAddr, isTCPAddr := tcpConn.RemoteAddr().(*net.TCPAddr)
+	if !isTCPAddr {
+		return ""
+	}
+	return addressFamily(remoteAddr.AddrPort().Addr())
+}
+
+func addressFamily(addr netip.Addr) string {
+	if addr.Unmap().Is4() {
+		return "ipv4"
+	}
+	return "ipv6"
+}
@@ -0,0 +1,38 @@
+//go:build darwin && !ios
+
+package main
+
+// #include <libproc.h>
+// #include <stdlib.h>
+// #include <unistd.h>
+//
+// static int libbox_open_fd_count(void) {
+// 	pid_t pid = getpid();
+// 	int size = proc_pidinfo(pid, PROC_PIDLISTFDS, 0, NULL, 0);
+// 	if (size <= 0) {
+// 		return -1;
+// 	}
+// 	void *buffer = malloc(size);
+// 	if (buffer == NULL) {
+// 		return -1;
+// 	}
+// 	int filled = proc_pidinfo(pid, PROC_PIDLISTFDS, 0, buffer, size);
+// 	free(buffer);
+// 	if (filled <= 0) {
+// 		return -1;
+// 	}
+// 	return filled / PROC_PIDLISTFD_SIZE;
+// }
+import "C"
+import "errors"
+
+// openFDCount lists the descriptor table of the process with proc_pidinfo.
+// Asked without a buffer it only estimates the size, with some slack, so
+// the table is read for real to count it.
+func openFDCount() (int, error) {
+	count := int(C.libbox_open_fd_count())
+	if count < 0 {
+		return 0, errors.New("proc_pidinfo failed")
+	}
+	return count, nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import "os"
+
+// openFDCount counts the entries of /proc/self/fd, less the descriptor
+// reading the directory takes itself.
+func openFDCount() (int, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	return max(len(entries)-1, 0), nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux && (!darwin || ios)
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func openFDCount() (int, error) {
+	return 0, fmt.Errorf("counting open descriptors is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,292 @@
+package main
+
+import "C"
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing-box/include"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// fetchBatchConcurrency bounds the requests LibboxFetchBatch has in flight,
+// kept low for constrained devices.
+const fetchBatchConcurrency = 4
+
+// defaultMaxRedirects is what net/http follows when not told otherwise.
+const defaultMaxRedirects = 10
+
+type fetchResult struct {
+	URL         string `json:"url"`
+	StatusCode  int    `json:"statusCode"`
+	Body        string `json:"body"`
+	FirstByteMs int64  `json:"firstByteMs"`
+	LatencyMs   int64  `json:"latencyMs"`
+	// Redirects are the URLs redirected to, in order; FinalURL is the one
+	// that answered. Location is set when a redirect was not followed.
+	Redirects []string `json:"redirects,omitempty"`
+	FinalURL  string   `json:"finalUrl"`
+	Location  string   `json:"location,omitempty"`
+	// ContentLength is the Content-Length the server announced, if any, and
+	// Userinfo its Subscription-Userinfo quota.
+	ContentLength *int64            `json:"contentLength,omitempty"`
+	Userinfo      *subscriptionInfo `json:"userinfo,omitempty"`
+}
+
+// fetchOptions are the options of LibboxFetchFull.
+type fetchOptions struct {
+	FollowRedirects *bool `json:"followRedirects,omitempty"`
+	MaxRedirects    int   `json:"maxRedirects,omitempty"`
+}
+
+type fetchBatchEntry struct {
+	URL         string `json:"url"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	Body        string `json:"body,omitempty"`
+	FirstByteMs int64  `json:"firstByteMs,omitempty"`
+	LatencyMs   int64  `json:"latencyMs,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// LibboxFetchTimed is LibboxFetch with timing: it returns the body together
+// with the time to the first response byte and to the end of the body, both
+// measured from the start of the request, so callers don't need a separate
+// LibboxTestOutbound round-trip. Redirects are followed and reported as in
+// LibboxFetchFull.
+//
+//export LibboxFetchTimed
+func LibboxFetchTimed(outboundJSON *C.char, targetURL *C.char, timeoutMS C.longlong) *C.char {
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	return C.CString(fetchFull(C.GoString(outboundJSON), C.GoString(targetURL), fetchOptions{}, timeout))
+}
+
+// LibboxFetchFull is LibboxFetchTimed with options, given as a JSON object
+// (NULL or empty for the defaults): "followRedirects" (true by default) and
+// "maxRedirects" (10 by default). The result lists the URLs redirected to in
+// "redirects" and the one that answered in "finalUrl"; a redirect that is
+// not followed is returned as the response, with its target in "location".
+// "contentLength" is the announced Content-Length and "userinfo" the
+// subscription quota of a Subscription-Userinfo header, {upload,download,
+// total,expire} as for LibboxStartSubscriptionUpdater; both are left out
+// when the server doesn't send them.
+//
+//export LibboxFetchFull
+func LibboxFetchFull(outboundJSON *C.char, targetURL *C.char, optionsJSON *C.char, timeoutMS C.longlong) *C.char {
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	var options fetchOptions
+	if optionsJSON != nil {
+		if optionsStr := strings.TrimSpace(C.GoString(optionsJSON)); optionsStr != "" {
+			if err := sjson.Unmarshal([]byte(optionsStr), &options); err != nil {
+				return jsonError("invalid options: %v", err)
+			}
+		}
+	}
+	return C.CString(fetchFull(C.GoString(outboundJSON), C.GoString(targetURL), options, timeout))
+}
+
+func fetchFull(configStr string, targetStr string, options fetchOptions, timeout time.Duration) string {
+	targets := parseTargetURLs(targetStr)
+	if len(targets) == 0 {
+		return jsonErrorString("no target url")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	ctx, configStr, err := takeUserAgent(ctx, configStr)
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	configStr, limits, err := takeRateLimits(configStr)
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	configStr, keepAlive, err := takeKeepAlive(configStr)
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-fetch", fetchLogLevel(ctx, configStr))
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	defer tempInstance.Close()
+
+	client := outboundHTTPClient(limits.dialer(out), timeout)
+	keepAlive.apply(client)
+	defer client.CloseIdleConnections()
+	client.CheckRedirect = options.redirectPolicy()
+
+	var result *fetchResult
+	for i, target := range targets {
+		last := i == len(targets)-1
+		result, err = fetchTimed(ctx, client, target, !last)
+		if err == nil || last || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return "{}"
+	}
+	return string(jsonBytes)
+}
+
+func (o fetchOptions) redirectPolicy() func(req *http.Request, via []*http.Request) error {
+	if o.FollowRedirects != nil && !*o.FollowRedirects {
+		return func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	maxRedirects := o.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return nil
+	}
+}
+
+// LibboxFetchBatch fetches every URL in urlsJSON, a JSON array, through one
+// temporary box holding the outbound, fetchBatchConcurrency at a time, all
+// within timeoutMS. Returns an array in the order of urlsJSON with, per URL,
+// LibboxFetchTimed's fields, or "error" when that URL failed. Unlike
+// LibboxFetch an error status is a result, not a failure. Connections are
+// kept alive and reused across the URLs unless the outbound JSON sets
+// "keepAlive": false; "idleTimeoutMS" applies as for LibboxTestOutbound.
+//
+//export LibboxFetchBatch
+func LibboxFetchBatch(outboundJSON *C.char, urlsJSON *C.char, timeoutMS C.longlong) *C.char {
+	configStr := C.GoString(outboundJSON)
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	var targets []string
+	if err := sjson.Unmarshal([]byte(C.GoString(urlsJSON)), &targets); err != nil {
+		return jsonError("invalid urls: %v", err)
+	}
+	if len(targets) == 0 {
+		return jsonError("no target url")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	ctx, configStr, err := takeUserAgent(ctx, configStr)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	configStr, limits, err := takeRateLimits(configStr)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	configStr, keepAlive, err := takeKeepAliveDefault(configStr, true)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-fetch", fetchLogLevel(ctx, configStr))
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	defer tempInstance.Close()
+
+	client := outboundHTTPClient(limits.dialer(out), timeout)
+	keepAlive.apply(client)
+	defer client.CloseIdleConnections()
+
+	var (
+		results = make([]fetchBatchEntry, len(targets))
+		wg      sync.WaitGroup
+		slots   = make(chan struct{}, fetchBatchConcurrency)
+	)
+	for i, target := range targets {
+		wg.Add(1)
+		go func(entry *fetchBatchEntry, target string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			entry.URL = target
+			result, err := fetchTimed(ctx, client, target, false)
+			if err != nil {
+				entry.Error = err.Error()
+				return
+			}
+			entry.StatusCode = result.StatusCode
+			entry.Body = result.Body
+			entry.FirstByteMs = result.FirstByteMs
+			entry.LatencyMs = result.LatencyMs
+		}(&results[i], target)
+	}
+	wg.Wait()
+
+	jsonBytes, err := sjson.Marshal(results)
+	if err != nil {
+		return C.CString("[]")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func fetchTimed(ctx context.Context, client *http.Client, target string, checkStatus bool) (*fetchResult, error) {
+	req, err := newTimedRequest(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// record redirects on a copy, the client may be shared
+	var redirects []string
+	recording := *client
+	recording.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if client.CheckRedirect != nil {
+			if err := client.CheckRedirect(req, via); err != nil {
+				return err
+			}
+		} else if len(via) > defaultMaxRedirects {
+			return fmt.Errorf("stopped after %d redirects", defaultMaxRedirects)
+		}
+		redirects = append(redirects, req.URL.String())
+		return nil
+	}
+
+	resp, err := req.do(&recording)
+	if err != nil {
+		return nil, fmt.Errorf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if checkStatus && resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body error: %v", err)
+	}
+	result := &fetchResult{
+		URL:        target,
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		LatencyMs:  time.Since(req.start).Milliseconds(),
+		Redirects:  redirects,
+		FinalURL:   resp.Request.URL.String(),
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		result.Location = resp.Header.Get("Location")
+	}
+	if resp.ContentLength >= 0 {
+		result.ContentLength = &resp.ContentLength
+	}
+	result.Userinfo = parseSubscriptionInfo(resp.Header.Get("Subscription-Userinfo"))
+	result.FirstByteMs = req.ttfb().Milliseconds()
+	return result, nil
+}
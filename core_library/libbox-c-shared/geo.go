@@ -0,0 +1,151 @@
+package main
+
+import "C"
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	sjson "github.com/sagernet/sing/common/json"
+	"go4.org/netipx"
+)
+
+// sing-box 1.12 dropped the geoip/geosite databases in favour of rule-sets
+// (geoip-cn.srs, geosite-*.srs), so those files back the offline lookups
+// below. Temporary test instances carry no route and so never load
+// rule-sets, and the main instance keeps loading its own so sing-box's file
+// watcher keeps working. The geoip rule-sets of geoIPDirectory are loaded on
+// the first lookup and kept until LibboxClearGeoCache is called or the
+// directory changes.
+var (
+	geoCacheAccess sync.Mutex
+	geoIPDirectory string
+	// geoIPCountries is nil until the rule-sets have been loaded.
+	geoIPCountries []geoIPCountry
+)
+
+// geoIPCountry holds the addresses of one geoip-<country>.srs rule-set.
+type geoIPCountry struct {
+	country string
+	set     *netipx.IPSet
+}
+
+// loadGeoIPCountries reads and merges every geoip rule-set in dir. Files that
+// can't be read or decoded are skipped.
+func loadGeoIPCountries(dir string) []geoIPCountry {
+	paths, _ := filepath.Glob(filepath.Join(dir, "geoip-*.srs"))
+	countries := make([]geoIPCountry, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		rules, err := parseRuleSet(content, true)
+		if err != nil {
+			continue
+		}
+		set, err := headlessRulesIPSet(rules)
+		if err != nil {
+			continue
+		}
+		countries = append(countries, geoIPCountry{
+			country: strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "geoip-"), ".srs"),
+			set:     set,
+		})
+	}
+	return countries
+}
+
+// LibboxClearGeoCache drops the cached rule-sets. Call it after updating the
+// files on disk.
+//
+//export LibboxClearGeoCache
+func LibboxClearGeoCache() {
+	geoCacheAccess.Lock()
+	defer geoCacheAccess.Unlock()
+	geoIPCountries = nil
+}
+
+// LibboxSetGeoIPDirectory points offline IP lookups at a directory of
+// geoip-<country>.srs rule-sets, as shipped with the app.
+//
+//export LibboxSetGeoIPDirectory
+func LibboxSetGeoIPDirectory(dir *C.char) {
+	geoCacheAccess.Lock()
+	defer geoCacheAccess.Unlock()
+	geoIPDirectory = C.GoString(dir)
+	geoIPCountries = nil
+}
+
+// LibboxGeoLookup looks ip up in the geoip rule-sets of
+// LibboxSetGeoIPDirectory without any network call and returns
+// {"ip","country"}. country is "unknown" for private and unmapped addresses
+// and when no directory is set. The rule-sets carry countries only, so no
+// ASN or organization is reported. The rule-sets are loaded on the first
+// lookup and cached until LibboxClearGeoCache.
+//
+//export LibboxGeoLookup
+func LibboxGeoLookup(ip *C.char) *C.char {
+	addr, err := netip.ParseAddr(strings.TrimSpace(C.GoString(ip)))
+	if err != nil {
+		return jsonError("invalid ip: %v", err)
+	}
+	content, err := sjson.Marshal(map[string]string{
+		"ip":      addr.Unmap().String(),
+		"country": lookupGeoIP(addr),
+	})
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(content))
+}
+
+// lookupGeoIP returns the country code of the first geoip rule-set that
+// contains addr, or "unknown" when none does or no directory is configured.
+func lookupGeoIP(addr netip.Addr) string {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return "unknown"
+	}
+
+	geoCacheAccess.Lock()
+	defer geoCacheAccess.Unlock()
+	if geoIPDirectory == "" {
+		return "unknown"
+	}
+	if geoIPCountries == nil {
+		geoIPCountries = loadGeoIPCountries(geoIPDirectory)
+	}
+	for _, country := range geoIPCountries {
+		if country.set.Contains(addr) {
+			return country.country
+		}
+	}
+	return "unknown"
+}
+
+// headlessRulesIPSet merges the addresses the default rules of a rule-set
+// match into one set.
+func headlessRulesIPSet(rules []option.HeadlessRule) (*netipx.IPSet, error) {
+	var builder netipx.IPSetBuilder
+	for _, rule := range rules {
+		if rule.Type != constant.RuleTypeDefault && rule.Type != "" {
+			continue
+		}
+		if rule.DefaultOptions.IPSet != nil {
+			builder.AddSet(rule.DefaultOptions.IPSet)
+		}
+		for _, cidr := range rule.DefaultOptions.IPCIDR {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				continue
+			}
+			builder.AddPrefix(prefix)
+		}
+	}
+	return builder.IPSet()
+}
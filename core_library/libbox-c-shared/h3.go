@@ -0,0 +1,76 @@
+package main
+
+import "C"
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing/common"
+	sjson "github.com/sagernet/sing/common/json"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// LibboxTestOutboundH3 is LibboxTestOutbound over HTTP/3: the request runs
+// on QUIC through the outbound's UDP path, the path hysteria2 and TUIC nodes
+// exist for and an HTTP/1.1 test never exercises. targetURL must be https
+// and served over HTTP/3; fallback URLs are tried as by LibboxTestOutbound.
+// Returns {"latencyMs","url"}, or {"error"}, which is "outbound does not
+// support UDP" when the outbound can't carry UDP. Needs a build with
+// with_quic.
+//
+//export LibboxTestOutboundH3
+func LibboxTestOutboundH3(outboundJSON *C.char, targetURL *C.char, timeoutMS C.longlong) *C.char {
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	return C.CString(testOutboundH3(C.GoString(outboundJSON), C.GoString(targetURL), timeout))
+}
+
+func testOutboundH3(configStr string, targetStr string, timeout time.Duration) string {
+	targets := parseTargetURLs(targetStr)
+	if len(targets) == 0 {
+		return jsonErrorString("create request error: no target url")
+	}
+	for _, target := range targets {
+		if targetURL, err := url.Parse(target); err != nil || targetURL.Scheme != "https" {
+			return jsonErrorString("http/3 needs an https target: %s", target)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	ctx, configStr, err := takeUserAgent(ctx, configStr)
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-outbound", currentLogLevel)
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	defer tempInstance.Close()
+
+	if !common.Contains(out.Network(), N.NetworkUDP) {
+		return jsonErrorString("%v", errUDPUnsupported)
+	}
+	client, err := outboundHTTP3Client(out, timeout)
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	defer client.CloseIdleConnections()
+
+	timing, target, err := probeTargets(ctx, client, targets, false)
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	jsonBytes, err := sjson.Marshal(map[string]any{
+		"latencyMs": timing.Headers.Milliseconds(),
+		"url":       target,
+	})
+	if err != nil {
+		return "{}"
+	}
+	return string(jsonBytes)
+}
@@ -0,0 +1,44 @@
+//go:build with_quic
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/sagernet/quic-go"
+	"github.com/sagernet/quic-go/http3"
+	"github.com/sagernet/sing/common/bufio"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// outboundHTTP3Client returns an HTTP/3 client whose QUIC connections run
+// over UDP dialed through the outbound, as sing-box's own fetch tool does.
+func outboundHTTP3Client(out N.Dialer, timeout time.Duration) (*http.Client, error) {
+	return &http.Client{
+		Transport: &http3.Transport{
+			Dial: func(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+				destination := M.ParseSocksaddr(addr)
+				udpConn, err := out.DialContext(ctx, N.NetworkUDP, destination)
+				if err != nil {
+					return nil, err
+				}
+				quicConn, err := quic.DialEarly(ctx, bufio.NewUnbindPacketConn(udpConn), udpConn.RemoteAddr(), tlsConfig, quicConfig)
+				if err != nil {
+					udpConn.Close()
+					return nil, err
+				}
+				// quic-go leaves a caller-supplied packet conn open, so
+				// release it once the QUIC connection is gone.
+				context.AfterFunc(quicConn.Context(), func() {
+					udpConn.Close()
+				})
+				return quicConn, nil
+			},
+		},
+		Timeout: timeout,
+	}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !with_quic
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	N "github.com/sagernet/sing/common/network"
+)
+
+func outboundHTTP3Client(out N.Dialer, timeout time.Duration) (*http.Client, error) {
+	return nil, errors.New("http/3 is not included in this build, rebuild with -tags with_quic")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// happyEyeballsAttemptDelay is the Connection Attempt Delay RFC 8305
+// recommends between starting attempts to successive addresses.
+const happyEyeballsAttemptDelay = 250 * time.Millisecond
+
+// testHappyEyeballs is the "happyEyeballs" field LibboxTestOutbound takes
+// from its outbound JSON. true (or "race") resolves the target itself and
+// races the test over its IPv6 and IPv4 addresses as RFC 8305 describes,
+// so the fastest family reaches the node instead of whichever one the
+// node's resolver returns first; "ipv4" or "ipv6" only tries that family.
+// Off by default, when the node resolves the target as usual.
+type testHappyEyeballs struct {
+	Mode string
+}
+
+// takeHappyEyeballs removes the happyEyeballs test field from configStr.
+func takeHappyEyeballs(configStr string) (string, testHappyEyeballs, error) {
+	var eyeballs testHappyEyeballs
+	configStr, value, err := takeTestValue(configStr, "happyEyeballs")
+	if err != nil {
+		return configStr, eyeballs, err
+	}
+	switch value := value.(type) {
+	case nil:
+	case bool:
+		if value {
+			eyeballs.Mode = "race"
+		}
+	case string:
+		switch value {
+		case "", "race", "ipv4", "ipv6":
+			eyeballs.Mode = value
+		default:
+			return configStr, eyeballs, fmt.Errorf("invalid happyEyeballs: %s", value)
+		}
+	default:
+		return configStr, eyeballs, fmt.Errorf("invalid happyEyeballs: %v", value)
+	}
+	return configStr, eyeballs, nil
+}
+
+// families returns the address families to try in order, or nil when the
+// field was not set.
+func (h testHappyEyeballs) families() []string {
+	switch h.Mode {
+	case "":
+		return nil
+	case "race":
+		return []string{"ipv6", "ipv4"}
+	default:
+		return []string{h.Mode}
+	}
+}
+
+type happyEyeballsAttempt struct {
+	family string
+	err    error
+}
+
+// race runs probe once per family, each through a dialer that only dials
+// the target's addresses of that family, and returns the family whose probe
+// succeeded first. A family starts happyEyeballsAttemptDelay after the
+// previous one, or as soon as it failed; once one wins the others are
+// cancelled and waited for. probe must only succeed once the target
+// answered: through a proxy the node connection comes up alike for every
+// family, so only traffic that made it to the target tells them apart, and a
+// node without IPv6 egress falls back to IPv4 instead of failing.
+func (h testHappyEyeballs) race(ctx context.Context, out N.Dialer, dnsRouter adapter.DNSRouter, probe func(ctx context.Context, dialer N.Dialer, family string) error) (string, error) {
+	families := h.families()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan happyEyeballsAttempt, len(families))
+	started := 0
+	start := func() {
+		family := families[started]
+		started++
+		dialer := &happyEyeballsDialer{Dialer: out, family: family, dnsRouter: dnsRouter}
+		go func() {
+			results <- happyEyeballsAttempt{family: family, err: probe(ctx, dialer, family)}
+		}()
+	}
+	start()
+	timer := time.NewTimer(happyEyeballsAttemptDelay)
+	defer timer.Stop()
+	pending := 1
+	var errs []error
+	for pending > 0 {
+		select {
+		case attempt := <-results:
+			pending--
+			if attempt.err == nil {
+				cancel()
+				for range pending {
+					<-results
+				}
+				return attempt.family, nil
+			}
+			if len(families) > 1 {
+				attempt.err = fmt.Errorf("%s: %w", attempt.family, attempt.err)
+			}
+			errs = append(errs, attempt.err)
+			if started < len(families) {
+				// a failed family starts the next one right away
+				start()
+				pending++
+				timer.Reset(happyEyeballsAttemptDelay)
+			}
+		case <-timer.C:
+			if started < len(families) {
+				start()
+				pending++
+				timer.Reset(happyEyeballsAttemptDelay)
+			}
+		}
+	}
+	return "", errors.Join(errs...)
+}
+
+// happyEyeballsDialer dials the target's addresses of one family through
+// the wrapped dialer, one after another until one connects.
+type happyEyeballsDialer struct {
+	N.Dialer
+	family    string
+	dnsRouter adapter.DNSRouter
+}
+
+func (d *happyEyeballsDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if !destination.IsFqdn() {
+		if addressFamily(destination.Addr) != d.family {
+			return nil, fmt.Errorf("%s is not an %s address", destination.Addr, d.family)
+		}
+		return d.Dialer.DialContext(ctx, network, destination)
+	}
+	resolved, err := d.dnsRouter.Lookup(ctx, destination.Fqdn, adapter.DNSQueryOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", destination.Fqdn, err)
+	}
+	var errs []error
+	for _, addr := range resolved {
+		if addressFamily(addr) != d.family {
+			continue
+		}
+		conn, err := d.Dialer.DialContext(ctx, network, M.Socksaddr{Addr: addr.Unmap(), Port: destination.Port})
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("%s has no %s address", destination.Fqdn, d.family)
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,320 @@
+package main
+
+import "C"
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// testHarness is a long-lived minimal box that test calls register their
+// outbounds into, instead of paying for box.New/Start/Close every time.
+type testHarness struct {
+	box    *trackedBox
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// users counts the callers holding the harness; a closed harness is only
+	// torn down once the last of them released it.
+	users  int
+	closed bool
+	// testing counts the batches testing each tag. A tag being tested is
+	// neither replaced nor removed until they are done.
+	testing map[string]int
+	// added are the tags registered by LibboxTestAddOutbounds, which stay
+	// after the batches testing them; outbounds a batch registers itself are
+	// removed again when it is done.
+	added map[string]bool
+}
+
+var (
+	harness   *testHarness
+	harnessMu sync.Mutex
+	// harnessDone is signalled whenever a batch stops testing its tags.
+	harnessDone = sync.NewCond(&harnessMu)
+)
+
+// acquireTestHarness returns the open harness, or nil, held for the caller
+// until it calls release so that closing the harness can't pull the box from
+// under a test.
+func acquireTestHarness() *testHarness {
+	harnessMu.Lock()
+	defer harnessMu.Unlock()
+	if harness != nil {
+		harness.users++
+	}
+	return harness
+}
+
+func (h *testHarness) release() {
+	harnessMu.Lock()
+	defer harnessMu.Unlock()
+	h.unhold()
+}
+
+// unhold drops a hold on the harness. It must be called with harnessMu held.
+func (h *testHarness) unhold() {
+	h.users--
+	if h.closed && h.users == 0 {
+		h.shutdown()
+	}
+}
+
+// LibboxTestInit opens the persistent test harness. While it is open,
+// LibboxTestBatch registers its outbounds into the harness and tests them
+// there. An empty logLevel inherits the running instance's level.
+//
+//export LibboxTestInit
+func LibboxTestInit(logLevel *C.char) *C.char {
+	harnessMu.Lock()
+	defer harnessMu.Unlock()
+
+	if harness != nil {
+		return C.CString("test harness already initialized")
+	}
+
+	level := C.GoString(logLevel)
+	if level == "" {
+		level = currentLogLevel
+	}
+	if err := openTestHarness(level); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
+
+// openTestHarness must be called with harnessMu held and no harness open.
+func openTestHarness(level string) error {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	ctx = include.Context(ctx)
+
+	configBytes, err := sjson.Marshal(testBoxConfig(level, nil))
+	if err != nil {
+		cancelFunc()
+		return fmt.Errorf("marshal config error: %v", err)
+	}
+	var options option.Options
+	if err := sjson.UnmarshalContext(ctx, configBytes, &options); err != nil {
+		cancelFunc()
+		return fmt.Errorf("unmarshal options error: %v", err)
+	}
+
+	harnessInstance, err := newBox(box.Options{
+		Context: ctx,
+		Options: options,
+	})
+	if err != nil {
+		cancelFunc()
+		return fmt.Errorf("create service error: %v", err)
+	}
+	if err := harnessInstance.Start(); err != nil {
+		harnessInstance.Close()
+		cancelFunc()
+		return fmt.Errorf("start test service error: %v", err)
+	}
+
+	harness = &testHarness{
+		box:     harnessInstance,
+		ctx:     ctx,
+		cancel:  cancelFunc,
+		testing: make(map[string]int),
+		added:   make(map[string]bool),
+	}
+	return nil
+}
+
+// LibboxTestAddOutbounds registers outbounds into the harness, replacing any
+// with the same tag once no batch is testing it. They stay registered until
+// the harness is closed or a batch replaces them. It accepts the same input
+// as LibboxTestBatch and returns {"tags": [...]} with the registered tags.
+//
+//export LibboxTestAddOutbounds
+func LibboxTestAddOutbounds(outboundsJSON *C.char) *C.char {
+	harnessMu.Lock()
+	defer harnessMu.Unlock()
+
+	if harness == nil {
+		return jsonError("test harness not initialized")
+	}
+	// register may wait for batches with harnessMu released
+	h := harness
+	h.users++
+	defer h.unhold()
+
+	var wrapper struct {
+		Outbounds []map[string]interface{} `json:"outbounds"`
+	}
+	var rawOutbounds []map[string]interface{}
+	configStr := C.GoString(outboundsJSON)
+	if err := sjson.UnmarshalContext(h.ctx, []byte(configStr), &wrapper); err == nil && len(wrapper.Outbounds) > 0 {
+		rawOutbounds = wrapper.Outbounds
+	} else if err := sjson.UnmarshalContext(h.ctx, []byte(configStr), &rawOutbounds); err != nil {
+		return jsonError("decode config error: %v", err)
+	}
+	assignBatchTags(rawOutbounds)
+
+	tags, err := h.register(rawOutbounds)
+	for _, tag := range tags {
+		h.added[tag] = true
+	}
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	result, err := sjson.Marshal(map[string]interface{}{"tags": tags})
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(result))
+}
+
+// LibboxTestClose closes the harness; later tests create throwaway boxes
+// again. Tests still running in the harness finish first, the box is torn
+// down when the last one returns.
+//
+//export LibboxTestClose
+func LibboxTestClose() *C.char {
+	harnessMu.Lock()
+	defer harnessMu.Unlock()
+
+	if harness == nil {
+		return C.CString("test harness not initialized")
+	}
+	if err := closeTestHarness(); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
+
+// closeTestHarness must be called with harnessMu held and the harness open.
+func closeTestHarness() error {
+	h := harness
+	harness = nil
+	h.closed = true
+	if h.users > 0 {
+		return nil
+	}
+	return h.shutdown()
+}
+
+// shutdown must be called with harnessMu held.
+func (h *testHarness) shutdown() error {
+	h.cancel()
+	if err := h.box.Close(); err != nil {
+		return fmt.Errorf("close test service error: %v", err)
+	}
+	return nil
+}
+
+// register creates the outbounds, replacing any with the same tag once no
+// batch tests it anymore, and returns the tags created, also when one
+// failed. It must be called with harnessMu held, which it releases while
+// waiting.
+func (h *testHarness) register(rawOutbounds []map[string]interface{}) ([]string, error) {
+	content, err := sjson.Marshal(rawOutbounds)
+	if err != nil {
+		return nil, fmt.Errorf("marshal outbounds error: %v", err)
+	}
+	var outbounds []option.Outbound
+	if err := sjson.UnmarshalContext(h.ctx, content, &outbounds); err != nil {
+		return nil, fmt.Errorf("decode config error: %v", err)
+	}
+	for slices.ContainsFunc(outbounds, func(it option.Outbound) bool {
+		return h.testing[it.Tag] > 0
+	}) {
+		harnessDone.Wait()
+	}
+
+	manager := h.box.Outbound()
+	tags := make([]string, 0, len(outbounds))
+	for _, outbound := range outbounds {
+		logger := h.box.LogFactory().NewLogger(fmt.Sprintf("outbound/%s[%s]", outbound.Type, outbound.Tag))
+		if err := manager.Create(h.ctx, h.box.Router(), logger, outbound.Tag, outbound.Type, outbound.Options); err != nil {
+			return tags, fmt.Errorf("create outbound %s error: %v", outbound.Tag, err)
+		}
+		delete(h.added, outbound.Tag)
+		tags = append(tags, outbound.Tag)
+	}
+	return tags, nil
+}
+
+// testBatch registers the outbounds and URL-tests them concurrently, producing
+// the same tag→latency results as the throwaway-box path. Failed outbounds are
+// omitted, and their errors stored in failures unless it is nil. The caller
+// holds the harness.
+func (h *testHarness) testBatch(ctx context.Context, rawOutbounds []map[string]interface{}, targets []string, failures map[string]error) (map[string]uint16, error) {
+	harnessMu.Lock()
+	tags, err := h.register(rawOutbounds)
+	for _, tag := range tags {
+		h.testing[tag]++
+	}
+	harnessMu.Unlock()
+	defer h.finishBatch(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	outbounds := make([]adapter.Outbound, 0, len(tags))
+	for _, tag := range tags {
+		if out, ok := h.box.Outbound().Outbound(tag); ok {
+			outbounds = append(outbounds, out)
+		}
+	}
+	results := make(map[string]uint16)
+	urlTestOutbounds(ctx, outbounds, targets, results, failures)
+	return results, nil
+}
+
+// finishBatch stops testing tags and removes the outbounds no other batch
+// tests and LibboxTestAddOutbounds didn't add.
+func (h *testHarness) finishBatch(tags []string) {
+	harnessMu.Lock()
+	defer harnessMu.Unlock()
+	var unused []string
+	for _, tag := range tags {
+		h.testing[tag]--
+		if h.testing[tag] > 0 {
+			continue
+		}
+		delete(h.testing, tag)
+		if !h.added[tag] {
+			unused = append(unused, tag)
+		}
+	}
+	h.unregister(unused)
+	harnessDone.Broadcast()
+}
+
+// unregister removes the outbounds of tags, dependents first since the
+// manager refuses to remove an outbound another one detours through. Those
+// an outbound outside of tags still depends on are kept. It must be called
+// with harnessMu held.
+func (h *testHarness) unregister(tags []string) {
+	manager := h.box.Outbound()
+	for len(tags) > 0 {
+		dependedOn := make(map[string]bool)
+		for _, out := range manager.Outbounds() {
+			for _, dependency := range out.Dependencies() {
+				dependedOn[dependency] = true
+			}
+		}
+		kept := tags[:0]
+		for _, tag := range tags {
+			if dependedOn[tag] {
+				kept = append(kept, tag)
+			} else {
+				manager.Remove(tag)
+			}
+		}
+		if len(kept) == len(tags) {
+			return
+		}
+		tags = kept
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "C"
+import (
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/service"
+)
+
+// lastInstanceError is the message of the last failed start or stop, cleared
+// by a successful start. It is guarded by mu.
+var lastInstanceError string
+
+type healthResult struct {
+	Running            bool   `json:"running"`
+	UptimeSeconds      int64  `json:"uptimeSeconds"`
+	Connections        int    `json:"connections"`
+	DefaultInterfaceUp bool   `json:"defaultInterfaceUp"`
+	LastError          string `json:"lastError"`
+}
+
+// LibboxHealthCheck is a single probe for supervisors of a headless service:
+// {"running","uptimeSeconds","connections","defaultInterfaceUp","lastError"}
+// with the number of open connections, whether the system has a default
+// interface to send traffic out of, and the last start or stop failure,
+// empty after a successful start. It holds mu only to read the state, and
+// the connection tracker's lock to count, so it stays cheap to poll; without
+// an interface monitor the default interface is looked up after releasing
+// mu.
+//
+//export LibboxHealthCheck
+func LibboxHealthCheck() *C.char {
+	mu.Lock()
+	running := instance != nil
+	var uptime int64
+	if running && !instanceStartedAt.IsZero() {
+		uptime = int64(time.Since(instanceStartedAt) / time.Second)
+	}
+	var connections int
+	if instanceConnections != nil {
+		connections = instanceConnections.liveCount()
+	}
+	interfaceUp := false
+	monitored := false
+	if running {
+		networkManager := service.FromContext[adapter.NetworkManager](instanceCtx)
+		if networkManager != nil && networkManager.InterfaceMonitor() != nil {
+			monitored = true
+			interfaceUp = networkManager.InterfaceMonitor().DefaultInterface() != nil
+		}
+	}
+	lastError := lastInstanceError
+	mu.Unlock()
+
+	if !monitored {
+		_, err := lookupDefaultInterface()
+		interfaceUp = err == nil
+	}
+
+	jsonBytes, err := sjson.Marshal(healthResult{
+		Running:            running,
+		UptimeSeconds:      uptime,
+		Connections:        connections,
+		DefaultInterfaceUp: interfaceUp,
+		LastError:          lastError,
+	})
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
@@ -0,0 +1,168 @@
+package main
+
+import "C"
+import (
+	"net"
+	"reflect"
+	"syscall"
+	"unsafe"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/common/listener"
+	sjson "github.com/sagernet/sing/common/json"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+type inboundInfo struct {
+	Tag    string          `json:"tag"`
+	Type   string          `json:"type"`
+	Listen []listenAddress `json:"listen"`
+}
+
+type listenAddress struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Port    uint16 `json:"port"`
+}
+
+// LibboxGetInbounds lists the inbounds of the running instance as
+// [{"tag","type","listen":[{"network","address","port"}]}], with the
+// addresses the listeners actually bound, so ports chosen by the system for
+// listen_port 0 are visible. Inbounds without a socket listener, such as TUN,
+// have an empty listen list. An empty list is returned when the service is
+// not running.
+//
+//export LibboxGetInbounds
+func LibboxGetInbounds() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	inbounds := []inboundInfo{}
+	if instance != nil {
+		for _, inbound := range instance.Inbound().Inbounds() {
+			inbounds = append(inbounds, inboundInfo{
+				Tag:    inbound.Tag(),
+				Type:   inbound.Type(),
+				Listen: inboundListenAddresses(inbound),
+			})
+		}
+	}
+	jsonBytes, err := sjson.Marshal(inbounds)
+	if err != nil {
+		return C.CString("[]")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+type inboundStatus struct {
+	Tag         string `json:"tag"`
+	Type        string `json:"type"`
+	Listening   bool   `json:"listening"`
+	Connections int    `json:"connections"`
+}
+
+// LibboxGetInboundStatus reports the health of each inbound of the running
+// instance as [{"tag","type","listening","connections"}]. listening turns
+// false when a listener died, e.g. sing-box closed it after a fatal accept
+// error, while the instance kept running; inbounds without a socket
+// listener, such as TUN, count as listening. connections is the number of
+// open connections that came in through the inbound. An empty list is
+// returned when the service is not running.
+//
+//export LibboxGetInboundStatus
+func LibboxGetInboundStatus() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	statuses := []inboundStatus{}
+	if instance != nil {
+		var connections map[string]int
+		if instanceConnections != nil {
+			connections = instanceConnections.inboundCounts()
+		}
+		for _, inbound := range instance.Inbound().Inbounds() {
+			statuses = append(statuses, inboundStatus{
+				Tag:         inbound.Tag(),
+				Type:        inbound.Type(),
+				Listening:   inboundListening(inbound),
+				Connections: connections[inbound.Tag()],
+			})
+		}
+	}
+	jsonBytes, err := sjson.Marshal(statuses)
+	if err != nil {
+		return C.CString("[]")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// inboundListening reports whether the sockets of the inbound's listener are
+// still open.
+func inboundListening(inbound adapter.Inbound) bool {
+	inboundListener := inboundSocketListener(inbound)
+	if inboundListener == nil {
+		return true
+	}
+	if tcpListener := inboundListener.TCPListener(); tcpListener != nil && !socketOpen(tcpListener) {
+		return false
+	}
+	if udpConn := inboundListener.UDPConn(); udpConn != nil && !socketOpen(udpConn) {
+		return false
+	}
+	return true
+}
+
+// socketOpen reports whether the socket behind socket has not been closed;
+// control calls fail on closed descriptors.
+func socketOpen(socket any) bool {
+	syscallConn, isSyscallConn := socket.(syscall.Conn)
+	if !isSyscallConn {
+		return true
+	}
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	return rawConn.Control(func(fd uintptr) {}) == nil
+}
+
+// inboundSocketListener returns the inbound's listener. sing-box keeps it in
+// an unexported "listener" field and has no accessor on the inbound.
+func inboundSocketListener(inbound adapter.Inbound) *listener.Listener {
+	value := reflect.ValueOf(inbound)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	field := value.Elem().FieldByName("listener")
+	if !field.IsValid() || field.Type() != reflect.TypeOf((*listener.Listener)(nil)) {
+		return nil
+	}
+	return *(**listener.Listener)(unsafe.Pointer(field.UnsafeAddr()))
+}
+
+// inboundListenAddresses reads the bound addresses from the inbound's
+// listener.
+func inboundListenAddresses(inbound adapter.Inbound) []listenAddress {
+	addresses := []listenAddress{}
+	inboundListener := inboundSocketListener(inbound)
+	if inboundListener == nil {
+		return addresses
+	}
+	if tcpListener := inboundListener.TCPListener(); tcpListener != nil {
+		addresses = append(addresses, newListenAddress(N.NetworkTCP, tcpListener.Addr()))
+	}
+	if udpConn := inboundListener.UDPConn(); udpConn != nil {
+		addresses = append(addresses, newListenAddress(N.NetworkUDP, udpConn.LocalAddr()))
+	}
+	return addresses
+}
+
+func newListenAddress(network string, addr net.Addr) listenAddress {
+	socksaddr := M.SocksaddrFromNet(addr)
+	return listenAddress{
+		Network: network,
+		Address: socksaddr.AddrString(),
+		Port:    socksaddr.Port,
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import "C"
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/sagernet/sing-box/include"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+type jitterResult struct {
+	Samples   int     `json:"samples"`
+	Success   int     `json:"success"`
+	MinMs     int64   `json:"minMs"`
+	MaxMs     int64   `json:"maxMs"`
+	AvgMs     float64 `json:"avgMs"`
+	JitterMs  float64 `json:"jitterMs"`
+	LatencyMs []int64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// LibboxTestOutboundJitter probes target through the outbound samples times
+// in a row over the same temporary box and reports min/max/avg latency and
+// jitter (standard deviation) of the successful probes. timeoutMS applies to
+// each probe. Each probe opens a new connection unless the outbound JSON
+// sets "keepAlive", as for LibboxTestOutbound.
+//
+//export LibboxTestOutboundJitter
+func LibboxTestOutboundJitter(outboundJSON *C.char, targetURL *C.char, samples C.int, timeoutMS C.longlong) *C.char {
+	configStr := C.GoString(outboundJSON)
+	target := C.GoString(targetURL)
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	count := int(samples)
+	if count <= 0 {
+		return jsonError("samples must be positive")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout*time.Duration(count))
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	configStr, keepAlive, err := takeKeepAlive(configStr)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-outbound", currentLogLevel)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	defer tempInstance.Close()
+
+	client := outboundHTTPClient(out, timeout)
+	keepAlive.apply(client)
+	defer client.CloseIdleConnections()
+	result := jitterResult{
+		Samples:   count,
+		LatencyMs: []int64{},
+	}
+	for i := 0; i < count; i++ {
+		latency, err := probeURL(ctx, client, target, keepAlive.Enabled)
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		result.LatencyMs = append(result.LatencyMs, latency.Milliseconds())
+	}
+	result.Success = len(result.LatencyMs)
+	if result.Success > 0 {
+		result.Error = ""
+		result.MinMs, result.MaxMs, result.AvgMs, result.JitterMs = latencyStats(result.LatencyMs)
+	}
+
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func latencyStats(latencies []int64) (min int64, max int64, avg float64, stddev float64) {
+	min, max = latencies[0], latencies[0]
+	var sum int64
+	for _, latency := range latencies {
+		if latency < min {
+			min = latency
+		}
+		if latency > max {
+			max = latency
+		}
+		sum += latency
+	}
+	avg = float64(sum) / float64(len(latencies))
+	var variance float64
+	for _, latency := range latencies {
+		delta := float64(latency) - avg
+		variance += delta * delta
+	}
+	stddev = math.Sqrt(variance / float64(len(latencies)))
+	return
+}
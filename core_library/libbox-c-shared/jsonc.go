@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sagernet/sing-box/option"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// decodeConfig decodes a full config that may be JSONC. Errors are
+// *configError, located in configStr itself since stripJSONC keeps every
+// offset in place: syntax errors at the offending byte, others at the
+// section, element or field that fails to decode.
+func decodeConfig(ctx context.Context, configStr string) (option.Options, error) {
+	content := stripJSONC([]byte(configStr))
+	var options option.Options
+	err := sjson.UnmarshalContext(ctx, content, &options)
+	if err == nil {
+		return options, nil
+	}
+	located := &configError{err: err}
+	var syntaxError *sjson.SyntaxError
+	if errors.As(err, &syntaxError) {
+		located.Line, located.Column = textPosition(configStr, int(syntaxError.Offset))
+		return options, located
+	}
+	path, offset, found := locateConfigError(ctx, content, err)
+	if found {
+		located.Path = path
+		located.Line, located.Column = textPosition(configStr, offset)
+	}
+	return options, located
+}
+
+func textPosition(content string, offset int) (int, int) {
+	offset = min(max(offset, 0), len(content))
+	row, column := 1, 1
+	for _, char := range content[:offset] {
+		if char == '\n' {
+			row++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return row, column
+}
+
+// stripJSONC blanks out // and /* */ comments and trailing commas before a
+// closing bracket, replacing them with spaces so that offsets, and the
+// newlines inside block comments, stay where they were. Plain JSON is
+// returned unchanged.
+func stripJSONC(content []byte) []byte {
+	result := make([]byte, len(content))
+	copy(result, content)
+	blank := func(from, to int) {
+		for i := from; i < to; i++ {
+			if result[i] != '\n' && result[i] != '\r' {
+				result[i] = ' '
+			}
+		}
+	}
+	// first pass: comments
+	for i := 0; i < len(result); i++ {
+		switch result[i] {
+		case '"':
+			i = skipJSONString(result, i)
+		case '/':
+			if i+1 >= len(result) {
+				continue
+			}
+			switch result[i+1] {
+			case '/':
+				end := i + 2
+				for end < len(result) && result[end] != '\n' {
+					end++
+				}
+				blank(i, end)
+				i = end
+			case '*':
+				end := i + 2
+				for end+1 < len(result) && !(result[end] == '*' && result[end+1] == '/') {
+					end++
+				}
+				end = min(end+2, len(result))
+				blank(i, end)
+				i = end - 1
+			}
+		}
+	}
+	// second pass: trailing commas, with comments already gone
+	for i := 0; i < len(result); i++ {
+		switch result[i] {
+		case '"':
+			i = skipJSONString(result, i)
+		case ',':
+			next := i + 1
+			for next < len(result) && isJSONSpace(result[next]) {
+				next++
+			}
+			if next < len(result) && (result[next] == '}' || result[next] == ']') {
+				result[i] = ' '
+			}
+		}
+	}
+	return result
+}
+
+// skipJSONString returns the index of the quote closing the string opened
+// at start.
+func skipJSONString(content []byte, start int) int {
+	for i := start + 1; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return len(content)
+}
+
+func isJSONSpace(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\n' || char == '\r'
+}
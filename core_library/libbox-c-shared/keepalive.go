@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// testKeepAlive are the "keepAlive" and "idleTimeoutMS" fields the test and
+// fetch functions take from their outbound JSON. Test transports close every
+// connection after its request by default, so each sample pays for a fresh
+// handshake; with keepAlive set, connections stay pooled across the samples
+// of a call, idle for at most idleTimeoutMS (no limit when 0), to measure
+// the latency of a reused connection.
+type testKeepAlive struct {
+	Enabled     bool
+	IdleTimeout time.Duration
+}
+
+// takeKeepAlive removes the keep-alive test fields from configStr.
+func takeKeepAlive(configStr string) (string, testKeepAlive, error) {
+	return takeKeepAliveDefault(configStr, false)
+}
+
+// takeKeepAliveDefault is takeKeepAlive for a call that pools connections
+// unless "keepAlive" is false when enabled is set.
+func takeKeepAliveDefault(configStr string, enabled bool) (string, testKeepAlive, error) {
+	var keepAlive testKeepAlive
+	configStr, value, err := takeTestValue(configStr, "keepAlive")
+	if err != nil {
+		return configStr, keepAlive, err
+	}
+	if flag, isFlag := value.(bool); isFlag {
+		enabled = flag
+	}
+	configStr, idleTimeoutMS, err := takeTestNumber(configStr, "idleTimeoutMS")
+	if err != nil {
+		return configStr, keepAlive, err
+	}
+	keepAlive.Enabled = enabled
+	keepAlive.IdleTimeout = time.Duration(max(idleTimeoutMS, 0)) * time.Millisecond
+	return configStr, keepAlive, nil
+}
+
+// apply lets the client's transport pool connections when keep-alive was
+// asked for. The caller closes the client's idle connections when done.
+func (k testKeepAlive) apply(client *http.Client) {
+	if !k.Enabled {
+		return
+	}
+	if transport, isTransport := client.Transport.(*http.Transport); isTransport {
+		transport.DisableKeepAlives = false
+		transport.IdleConnTimeout = k.IdleTimeout
+	}
+}
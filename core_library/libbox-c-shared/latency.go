@@ -0,0 +1,173 @@
+package main
+
+import "C"
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing-box/include"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// latencyCache holds the latest latency measured for each outbound
+// fingerprint, for LibboxSaveLatencies.
+var (
+	latencyAccess sync.Mutex
+	latencyCache  = make(map[string]cachedLatency)
+)
+
+type cachedLatency struct {
+	Tag       string `json:"tag"`
+	LatencyMs uint16 `json:"latencyMs"`
+	TestedAt  int64  `json:"testedAt"`
+}
+
+type latencyFile struct {
+	Latencies map[string]cachedLatency `json:"latencies"`
+}
+
+// LibboxOutboundFingerprint returns the fingerprint latencies are saved
+// under and LibboxDedupOutbounds compares: the hex SHA-256 of the canonical
+// form of the outbound, as LibboxCanonicalizeOutbound writes it, without the
+// tag, so it survives tag renames and reformatting. It returns an empty
+// string for outbounds without a server, such as groups.
+//
+//export LibboxOutboundFingerprint
+func LibboxOutboundFingerprint(outboundJSON *C.char) *C.char {
+	var outbound map[string]any
+	if err := sjson.Unmarshal([]byte(C.GoString(outboundJSON)), &outbound); err != nil {
+		return C.CString("")
+	}
+	return C.CString(outboundFingerprint(include.Context(context.Background()), outbound))
+}
+
+// LibboxSaveLatencies writes the latest latency of every outbound tested
+// since launch, by LibboxTestOutbound or LibboxTestBatch, to path, together
+// with the entries loaded by LibboxLoadLatencies.
+//
+//export LibboxSaveLatencies
+func LibboxSaveLatencies(path *C.char) *C.char {
+	latencyAccess.Lock()
+	content, err := sjson.Marshal(latencyFile{Latencies: latencyCache})
+	latencyAccess.Unlock()
+	if err != nil {
+		return C.CString(fmt.Sprintf("encode latencies error: %v", err))
+	}
+	if err := writeFileAtomic(C.GoString(path), content); err != nil {
+		return C.CString(fmt.Sprintf("save latencies error: %v", err))
+	}
+	return nil
+}
+
+// LibboxLoadLatencies reads latencies saved by LibboxSaveLatencies and
+// returns them as {"<fingerprint>":{"tag","latencyMs","testedAt"}}, testedAt
+// being Unix seconds. Entries newer than what was measured since launch are
+// kept, so the next save carries them on. A missing file yields {}.
+//
+//export LibboxLoadLatencies
+func LibboxLoadLatencies(path *C.char) *C.char {
+	content, err := os.ReadFile(C.GoString(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return C.CString("{}")
+	}
+	if err != nil {
+		return jsonError("read latencies error: %v", err)
+	}
+	var saved latencyFile
+	if err := sjson.Unmarshal(content, &saved); err != nil {
+		return jsonError("decode latencies error: %v", err)
+	}
+	if saved.Latencies == nil {
+		saved.Latencies = make(map[string]cachedLatency)
+	}
+
+	latencyAccess.Lock()
+	for fingerprint, entry := range saved.Latencies {
+		if current, loaded := latencyCache[fingerprint]; !loaded || current.TestedAt < entry.TestedAt {
+			latencyCache[fingerprint] = entry
+		}
+	}
+	latencyAccess.Unlock()
+
+	jsonBytes, err := sjson.Marshal(saved.Latencies)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func outboundFingerprint(ctx context.Context, outbound map[string]any) string {
+	outboundType, _ := outbound["type"].(string)
+	server, _ := outbound["server"].(string)
+	if outboundType == "" || server == "" {
+		return ""
+	}
+	sum, err := connectionFingerprint(ctx, outbound)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+// recordLatencies remembers the latencies of the outbounds in results, keyed
+// by the fingerprint of the matching entry of rawOutbounds.
+func recordLatencies(rawOutbounds []map[string]any, results map[string]uint16) {
+	ctx := include.Context(context.Background())
+	testedAt := time.Now().Unix()
+	latencyAccess.Lock()
+	defer latencyAccess.Unlock()
+	for _, outbound := range rawOutbounds {
+		tag, _ := outbound["tag"].(string)
+		latency, loaded := results[tag]
+		if !loaded {
+			continue
+		}
+		fingerprint := outboundFingerprint(ctx, outbound)
+		if fingerprint == "" {
+			continue
+		}
+		latencyCache[fingerprint] = cachedLatency{Tag: tag, LatencyMs: latency, TestedAt: testedAt}
+	}
+}
+
+// recordTestLatency remembers the latency of a single-outbound test, given the
+// outbound or chain it ran with.
+func recordTestLatency(configStr string, latency time.Duration) {
+	var outbounds []map[string]any
+	if strings.HasPrefix(strings.TrimSpace(configStr), "[") {
+		if err := sjson.Unmarshal([]byte(configStr), &outbounds); err != nil || len(outbounds) == 0 {
+			return
+		}
+		outbounds = outbounds[len(outbounds)-1:]
+	} else {
+		var outbound map[string]any
+		if err := sjson.Unmarshal([]byte(configStr), &outbound); err != nil {
+			return
+		}
+		outbounds = []map[string]any{outbound}
+	}
+	// test-only fields aren't part of the node
+	for _, key := range []string{"expectedFingerprint", "bindInterface", "domainStrategy"} {
+		delete(outbounds[0], key)
+	}
+	tag, _ := outbounds[0]["tag"].(string)
+	recordLatencies(outbounds, map[string]uint16{tag: uint16(min(latency.Milliseconds(), 0xffff))})
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
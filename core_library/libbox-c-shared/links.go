@@ -0,0 +1,395 @@
+package main
+
+import "C"
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sagernet/sing-box/constant"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+type parsedLinks struct {
+	Outbounds []map[string]any `json:"outbounds"`
+	Errors    []linkError      `json:"errors"`
+}
+
+type linkError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// LibboxParseLinks turns newline-separated share links (vmess, vless,
+// trojan, ss, hysteria2/hy2, tuic, anytls) into sing-box outbounds. Returns
+// {"outbounds":[...],"errors":[{"line","message"}]}: outbounds keep the order
+// of their links, lines are counted from 1, and blank lines are skipped.
+// Tags come from the link's name, made unique with a numeric suffix.
+//
+//export LibboxParseLinks
+func LibboxParseLinks(content *C.char) *C.char {
+	result, err := parseLinks(C.GoString(content))
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func parseLinks(content string) (parsedLinks, error) {
+	result := parsedLinks{
+		Outbounds: []map[string]any{},
+		Errors:    []linkError{},
+	}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		link := strings.TrimSpace(scanner.Text())
+		if link == "" {
+			continue
+		}
+		outbound, err := parseLink(link)
+		if err != nil {
+			result.Errors = append(result.Errors, linkError{Line: line, Message: err.Error()})
+			continue
+		}
+		tag, _ := outbound["tag"].(string)
+		if tag == "" {
+			tag = fmt.Sprintf("%s-%d", outbound["type"], line)
+		}
+		outbound["tag"] = tag
+		result.Outbounds = append(result.Outbounds, outbound)
+	}
+	if err := scanner.Err(); err != nil {
+		return parsedLinks{}, fmt.Errorf("read links error: %v", err)
+	}
+	uniqueLinkTags(result.Outbounds)
+	return result, nil
+}
+
+// uniqueLinkTags suffixes repeated tags with -2, -3, ..., skipping suffixed
+// names another link already uses literally, so a later "a-2" doesn't
+// collide with a renamed second "a".
+func uniqueLinkTags(outbounds []map[string]any) {
+	literal := make(map[string]bool, len(outbounds))
+	for _, outbound := range outbounds {
+		literal[outbound["tag"].(string)] = true
+	}
+	used := make(map[string]bool, len(outbounds))
+	for _, outbound := range outbounds {
+		tag := outbound["tag"].(string)
+		if used[tag] {
+			base := tag
+			for count := 2; used[tag] || literal[tag]; count++ {
+				tag = fmt.Sprintf("%s-%d", base, count)
+			}
+			outbound["tag"] = tag
+		}
+		used[tag] = true
+	}
+}
+
+func parseLink(link string) (map[string]any, error) {
+	scheme, _, found := strings.Cut(link, "://")
+	if !found {
+		return nil, errors.New("not a share link")
+	}
+	scheme = strings.ToLower(scheme)
+	switch scheme {
+	case "vmess":
+		return parseVMessLink(link)
+	case "ss":
+		return parseShadowsocksLink(link)
+	case "vless", "trojan", "hysteria2", "hy2", "tuic", "anytls":
+	default:
+		return nil, fmt.Errorf("unsupported link scheme: %s", scheme)
+	}
+	linkURL, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link: %v", err)
+	}
+	server, port, err := linkServer(linkURL)
+	if err != nil {
+		return nil, err
+	}
+	query := linkURL.Query()
+	outbound := map[string]any{
+		"tag":         linkURL.Fragment,
+		"server":      server,
+		"server_port": port,
+	}
+	switch scheme {
+	case "vless":
+		outbound["type"] = constant.TypeVLESS
+		outbound["uuid"] = linkURL.User.Username()
+		if flow := query.Get("flow"); flow != "" {
+			outbound["flow"] = flow
+		}
+		applyLinkTLS(outbound, query, query.Get("security"))
+		applyLinkTransport(outbound, query)
+	case "trojan":
+		outbound["type"] = constant.TypeTrojan
+		outbound["password"] = linkURL.User.Username()
+		applyLinkTLS(outbound, query, defaultString(query.Get("security"), "tls"))
+		applyLinkTransport(outbound, query)
+	case "hysteria2", "hy2":
+		outbound["type"] = constant.TypeHysteria2
+		outbound["password"] = linkUserPassword(linkURL)
+		if obfs := query.Get("obfs"); obfs != "" {
+			outbound["obfs"] = map[string]any{"type": obfs, "password": query.Get("obfs-password")}
+		}
+		applyLinkTLS(outbound, query, "tls")
+	case "tuic":
+		outbound["type"] = constant.TypeTUIC
+		outbound["uuid"] = linkURL.User.Username()
+		outbound["password"], _ = linkURL.User.Password()
+		if congestion := query.Get("congestion_control"); congestion != "" {
+			outbound["congestion_control"] = congestion
+		}
+		if mode := query.Get("udp_relay_mode"); mode != "" {
+			outbound["udp_relay_mode"] = mode
+		}
+		applyLinkTLS(outbound, query, "tls")
+	case "anytls":
+		outbound["type"] = constant.TypeAnyTLS
+		outbound["password"] = linkUserPassword(linkURL)
+		applyLinkTLS(outbound, query, "tls")
+	}
+	return outbound, nil
+}
+
+// parseVMessLink reads the v2rayN format: vmess://base64(JSON).
+func parseVMessLink(link string) (map[string]any, error) {
+	encoded, _, _ := strings.Cut(link[len("vmess://"):], "?")
+	payload, err := decodeLinkBase64(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vmess link: %v", err)
+	}
+	var share map[string]any
+	if err := sjson.Unmarshal(payload, &share); err != nil {
+		return nil, fmt.Errorf("invalid vmess link: %v", err)
+	}
+	field := func(key string) string {
+		switch value := share[key].(type) {
+		case string:
+			return value
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		return ""
+	}
+	port, err := strconv.ParseUint(field("port"), 10, 16)
+	if err != nil || field("add") == "" {
+		return nil, errors.New("invalid vmess link: missing server or port")
+	}
+	outbound := map[string]any{
+		"type":        constant.TypeVMess,
+		"tag":         field("ps"),
+		"server":      field("add"),
+		"server_port": port,
+		"uuid":        field("id"),
+		"security":    defaultString(field("scy"), "auto"),
+	}
+	if alterID, err := strconv.Atoi(field("aid")); err == nil && alterID > 0 {
+		outbound["alter_id"] = alterID
+	}
+	query := url.Values{}
+	query.Set("sni", field("sni"))
+	query.Set("alpn", field("alpn"))
+	query.Set("fp", field("fp"))
+	query.Set("allowInsecure", field("allowInsecure"))
+	query.Set("type", field("net"))
+	query.Set("host", field("host"))
+	query.Set("path", field("path"))
+	query.Set("serviceName", field("path"))
+	applyLinkTLS(outbound, query, field("tls"))
+	applyLinkTransport(outbound, query)
+	return outbound, nil
+}
+
+// parseShadowsocksLink reads SIP002 (ss://base64(method:password)@host:port
+// or with the user info percent-encoded) and the legacy
+// ss://base64(method:password@host:port). A SIP002 ?plugin= becomes plugin
+// and plugin_opts; links naming a plugin sing-box lacks are rejected rather
+// than imported without it.
+func parseShadowsocksLink(link string) (map[string]any, error) {
+	body, name, _ := strings.Cut(link[len("ss://"):], "#")
+	body, rawQuery, _ := strings.Cut(body, "?")
+	body = strings.TrimSuffix(body, "/")
+	if !strings.Contains(body, "@") {
+		decoded, err := decodeLinkBase64(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ss link: %v", err)
+		}
+		body = string(decoded)
+	}
+	userInfo, hostPort, found := strings.Cut(body, "@")
+	if !found {
+		return nil, errors.New("invalid ss link: missing server")
+	}
+	if decoded, err := decodeLinkBase64(userInfo); err == nil && strings.Contains(string(decoded), ":") {
+		userInfo = string(decoded)
+	} else if unescaped, err := url.PathUnescape(userInfo); err == nil {
+		userInfo = unescaped
+	}
+	method, password, found := strings.Cut(userInfo, ":")
+	if !found {
+		return nil, errors.New("invalid ss link: missing method or password")
+	}
+	server, portString, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ss link: %v", err)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ss link: bad port %s", portString)
+	}
+	tag, _ := url.PathUnescape(name)
+	outbound := map[string]any{
+		"type":        constant.TypeShadowsocks,
+		"tag":         tag,
+		"server":      server,
+		"server_port": port,
+		"method":      method,
+		"password":    password,
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ss link: %v", err)
+	}
+	if plugin := query.Get("plugin"); plugin != "" {
+		pluginName, pluginOptions, _ := strings.Cut(plugin, ";")
+		switch pluginName {
+		case "obfs-local", "simple-obfs":
+			pluginName = "obfs-local"
+		case "v2ray-plugin":
+		default:
+			return nil, fmt.Errorf("unsupported ss plugin: %s", pluginName)
+		}
+		outbound["plugin"] = pluginName
+		if pluginOptions != "" {
+			outbound["plugin_opts"] = pluginOptions
+		}
+	}
+	return outbound, nil
+}
+
+func linkServer(linkURL *url.URL) (string, uint64, error) {
+	server := linkURL.Hostname()
+	if server == "" {
+		return "", 0, errors.New("invalid link: missing server")
+	}
+	port, err := strconv.ParseUint(defaultString(linkURL.Port(), "443"), 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid link: bad port %s", linkURL.Port())
+	}
+	return server, port, nil
+}
+
+// linkUserPassword returns the secret of links that carry it either as the
+// user (hysteria2://secret@) or as user:password.
+func linkUserPassword(linkURL *url.URL) string {
+	if password, found := linkURL.User.Password(); found {
+		return linkURL.User.Username() + ":" + password
+	}
+	return linkURL.User.Username()
+}
+
+// applyLinkTLS maps the common TLS query parameters (sni/peer, alpn, fp,
+// allowInsecure/insecure, and pbk/sid for REALITY) to a tls object.
+func applyLinkTLS(outbound map[string]any, query url.Values, security string) {
+	switch strings.ToLower(security) {
+	case "tls", "reality", "xtls":
+	default:
+		return
+	}
+	tls := map[string]any{"enabled": true}
+	if serverName := defaultString(query.Get("sni"), query.Get("peer")); serverName != "" {
+		tls["server_name"] = serverName
+	}
+	if alpn := query.Get("alpn"); alpn != "" {
+		tls["alpn"] = strings.Split(alpn, ",")
+	}
+	if insecure := defaultString(query.Get("allowInsecure"), query.Get("insecure")); insecure == "1" || insecure == "true" {
+		tls["insecure"] = true
+	}
+	if fingerprint := query.Get("fp"); fingerprint != "" {
+		tls["utls"] = map[string]any{"enabled": true, "fingerprint": fingerprint}
+	}
+	if strings.EqualFold(security, "reality") {
+		reality := map[string]any{"enabled": true, "public_key": query.Get("pbk")}
+		if shortID := query.Get("sid"); shortID != "" {
+			reality["short_id"] = shortID
+		}
+		tls["reality"] = reality
+	}
+	outbound["tls"] = tls
+}
+
+// applyLinkTransport maps type=ws/grpc/http/h2/httpupgrade with host, path
+// and serviceName to a transport object.
+func applyLinkTransport(outbound map[string]any, query url.Values) {
+	host := query.Get("host")
+	path := query.Get("path")
+	var transport map[string]any
+	switch strings.ToLower(query.Get("type")) {
+	case "ws", "websocket":
+		transport = map[string]any{"type": constant.V2RayTransportTypeWebsocket}
+		if path != "" {
+			transport["path"] = path
+		}
+		if host != "" {
+			transport["headers"] = map[string]any{"Host": host}
+		}
+	case "grpc":
+		transport = map[string]any{"type": constant.V2RayTransportTypeGRPC}
+		if serviceName := query.Get("serviceName"); serviceName != "" {
+			transport["service_name"] = serviceName
+		}
+	case "http", "h2":
+		transport = map[string]any{"type": constant.V2RayTransportTypeHTTP}
+		if path != "" {
+			transport["path"] = path
+		}
+		if host != "" {
+			transport["host"] = strings.Split(host, ",")
+		}
+	case "httpupgrade":
+		transport = map[string]any{"type": constant.V2RayTransportTypeHTTPUpgrade}
+		if path != "" {
+			transport["path"] = path
+		}
+		if host != "" {
+			transport["host"] = host
+		}
+	default:
+		return
+	}
+	outbound["transport"] = transport
+}
+
+// decodeLinkBase64 accepts standard and URL-safe base64, padded or not.
+func decodeLinkBase64(content string) ([]byte, error) {
+	content = strings.TrimSpace(content)
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(content); err == nil {
+			return decoded, nil
+		}
+	}
+	return nil, errors.New("invalid base64")
+}
+
+func defaultString(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
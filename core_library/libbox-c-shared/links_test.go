@@ -0,0 +1,36 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseLinksKeepsTagsUnique(t *testing.T) {
+	content := "trojan://secret@a.example:443#a\n" +
+		"trojan://secret@b.example:443#a\n" +
+		"trojan://secret@c.example:443#a-2\n"
+	result, err := parseLinks(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, outbound := range result.Outbounds {
+		tags = append(tags, outbound["tag"].(string))
+	}
+	if want := []string{"a", "a-3", "a-2"}; !slices.Equal(tags, want) {
+		t.Fatalf("got tags %q, want %q", tags, want)
+	}
+}
+
+func TestParseShadowsocksLinkPlugin(t *testing.T) {
+	outbound, err := parseShadowsocksLink("ss://YWVzLTI1Ni1nY206c2VjcmV0@example.com:8388/?plugin=simple-obfs%3Bobfs%3Dhttp%3Bobfs-host%3Dexample.org#node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outbound["plugin"] != "obfs-local" || outbound["plugin_opts"] != "obfs=http;obfs-host=example.org" {
+		t.Fatalf("got plugin %v with options %v", outbound["plugin"], outbound["plugin_opts"])
+	}
+	if _, err := parseShadowsocksLink("ss://YWVzLTI1Ni1nY206c2VjcmV0@example.com:8388/?plugin=kcptun#node"); err == nil {
+		t.Fatal("link with an unsupported plugin was accepted")
+	}
+}
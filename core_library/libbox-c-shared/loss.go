@@ -0,0 +1,112 @@
+package main
+
+import "C"
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing/common"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+type lossResult struct {
+	Sent        int     `json:"sent"`
+	Received    int     `json:"received"`
+	LossPercent float64 `json:"lossPercent"`
+	AvgRttMs    float64 `json:"avgRttMs"`
+}
+
+var errUDPUnsupported = errors.New("outbound does not support UDP")
+
+// LibboxTestOutboundLoss sends count UDP probes to an echo server at
+// host:port through the outbound and reports how many came back. Each probe
+// waits up to timeoutMS for its echo before it is counted as lost.
+//
+//export LibboxTestOutboundLoss
+func LibboxTestOutboundLoss(outboundJSON *C.char, host *C.char, port C.int, count C.int, timeoutMS C.longlong) *C.char {
+	configStr := C.GoString(outboundJSON)
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	probes := int(count)
+	if probes <= 0 {
+		return jsonError("count must be positive")
+	}
+	destination := metadata.ParseSocksaddrHostPort(C.GoString(host), uint16(port))
+	if !destination.IsValid() {
+		return jsonError("invalid destination")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout*time.Duration(probes+1))
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-outbound", currentLogLevel)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	defer tempInstance.Close()
+
+	result, err := measureLoss(ctx, out, destination, probes, timeout)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func measureLoss(ctx context.Context, out adapter.Outbound, destination metadata.Socksaddr, probes int, timeout time.Duration) (*lossResult, error) {
+	if !common.Contains(out.Network(), N.NetworkUDP) {
+		return nil, errUDPUnsupported
+	}
+	conn, err := out.ListenPacket(ctx, destination)
+	if err != nil {
+		return nil, fmt.Errorf("listen packet error: %v", err)
+	}
+	defer conn.Close()
+
+	result := &lossResult{Sent: probes}
+	var totalRTT time.Duration
+	buffer := make([]byte, 1500)
+	for seq := 0; seq < probes; seq++ {
+		payload := make([]byte, 16)
+		binary.BigEndian.PutUint64(payload, uint64(seq))
+		binary.BigEndian.PutUint64(payload[8:], uint64(time.Now().UnixNano()))
+
+		start := time.Now()
+		if _, err := conn.WriteTo(payload, destination); err != nil {
+			return nil, fmt.Errorf("write probe error: %v", err)
+		}
+		deadline := start.Add(timeout)
+		for {
+			conn.SetReadDeadline(deadline)
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				// timed out: this probe is lost
+				break
+			}
+			if bytes.Equal(buffer[:n], payload) {
+				result.Received++
+				totalRTT += time.Since(start)
+				break
+			}
+			// a late echo of an earlier probe; keep waiting for ours
+		}
+	}
+
+	result.LossPercent = float64(result.Sent-result.Received) * 100 / float64(result.Sent)
+	if result.Received > 0 {
+		result.AvgRttMs = float64(totalRTT.Milliseconds()) / float64(result.Received)
+	}
+	return result, nil
+}
@@ -3,10 +3,12 @@ package main
 import "C"
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
 	"sync"
 	"time"
@@ -14,19 +16,26 @@ import (
 	"os"
 
 	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
 	"github.com/sagernet/sing-box/include"
 	"github.com/sagernet/sing-box/option"
-	"github.com/sagernet/sing-box/protocol/group"
 	sjson "github.com/sagernet/sing/common/json"
 	"github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/service"
 
 	_ "github.com/anytls/sing-anytls"
 )
 
 var (
-	instance *box.Box
-	mu       sync.Mutex
-	cancel   context.CancelFunc
+	instance            *trackedBox
+	instanceCtx         context.Context
+	instanceOptions     option.Options
+	instanceConnections *connectionTracker
+	instanceStartedAt   time.Time
+	mu                  sync.Mutex
+	cancel              context.CancelFunc
 
 	currentLogLevel string = "info"
 )
@@ -36,62 +45,35 @@ func LibboxHello() *C.char {
 	return C.CString("Hello from Go Libbox!")
 }
 
+// LibboxStart starts the service with configJSON. It returns NULL on success
+// and otherwise the error message, except when an instance is already
+// running: then it returns the ALREADY_RUNNING envelope of
+// LibboxStartWithTimeout, whose message still reads "service already
+// running", with the running instance's uptime.
+//
 //export LibboxStart
 func LibboxStart(configJSON *C.char, logFD C.longlong) *C.char {
 	mu.Lock()
 	defer mu.Unlock()
 
+	redirectLog(logFD)
+	if err := startDesktop(C.GoString(configJSON), 0); err != nil {
+		if err.Code == errorCodeAlreadyRunning {
+			return err.envelope()
+		}
+		return C.CString(err.Message)
+	}
+	return nil // Success
+}
+
+// redirectLog points the process's stdout and stderr at the host-provided
+// descriptor, when there is one.
+func redirectLog(logFD C.longlong) {
 	if logFD > 0 {
 		f := os.NewFile(uintptr(logFD), "log")
 		os.Stdout = f
 		os.Stderr = f
 	}
-
-	if instance != nil {
-		return C.CString("service already running")
-	}
-
-	configStr := C.GoString(configJSON)
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	cancel = cancelFunc
-	ctx = include.Context(ctx)
-
-	var options option.Options
-	if err := sjson.UnmarshalContext(ctx, []byte(configStr), &options); err != nil {
-		cancel()
-		cancel = nil
-		return C.CString(fmt.Sprintf("decode config error: %s", err))
-	}
-
-	// Sync current log level
-	if options.Log != nil {
-		currentLogLevel = options.Log.Level
-	}
-
-	var err error
-	// v1.12+ box.New might fail if registries are not in context?
-	// But usually importing 'include' registers them globally or makes New work.
-	// If this fails, we need to inspect how to initialize registries.
-	instance, err = box.New(box.Options{
-		Context: ctx,
-		Options: options,
-	})
-	if err != nil {
-		cancel()
-		cancel = nil
-		return C.CString(fmt.Sprintf("create service error: %s", err))
-	}
-
-	if err := instance.Start(); err != nil {
-		instance.Close()
-		instance = nil
-		cancel()
-		cancel = nil
-		return C.CString(fmt.Sprintf("start service error: %s", err))
-	}
-
-	return nil // Success
 }
 
 //export LibboxStop
@@ -99,10 +81,19 @@ func LibboxStop() *C.char {
 	mu.Lock()
 	defer mu.Unlock()
 
+	stopWatching()
 	if instance == nil {
 		return C.CString("service not running")
 	}
+	if err := stopInstance(); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
 
+// stopInstance closes the running instance and clears its state. It must be
+// called with mu held.
+func stopInstance() error {
 	// CRITICAL: Cancel context FIRST to signal all goroutines to stop
 	// This allows instance.Close() to complete without waiting for context cancellation
 	if cancel != nil {
@@ -110,32 +101,41 @@ func LibboxStop() *C.char {
 		cancel = nil
 	}
 
+	closeClashAPI()
+
 	// Then close the instance
 	if err := instance.Close(); err != nil {
 		if strings.Contains(err.Error(), "service not running") {
 			// ignore
 		} else {
-			return C.CString(fmt.Sprintf("close service error: %s", err))
+			lastInstanceError = fmt.Sprintf("close service error: %s", err)
+			return fmt.Errorf("close service error: %s", err)
 		}
 	}
 
 	instance = nil
+	instanceCtx = nil
+	instanceOptions = option.Options{}
+	instanceConnections = nil
+	instanceDNSCounters = nil
+	instanceStartedAt = time.Time{}
 	return nil
 }
 
+// LibboxStartMobile starts the service with a TUN descriptor opened by the
+// host. fd is handed to the config's TUN inbound; configs with more than one
+// TUN inbound are rejected. Errors are reported as by LibboxStart, sharing
+// its ALREADY_RUNNING guard.
+//
 //export LibboxStartMobile
 func LibboxStartMobile(fd C.int, configJSON *C.char, logFD C.longlong) *C.char {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if logFD > 0 {
-		f := os.NewFile(uintptr(logFD), "log")
-		os.Stdout = f
-		os.Stderr = f
-	}
+	redirectLog(logFD)
 
-	if instance != nil {
-		return C.CString("service already running")
+	if err := checkNotRunning(); err != nil {
+		return err.envelope()
 	}
 
 	configStr := C.GoString(configJSON)
@@ -144,157 +144,276 @@ func LibboxStartMobile(fd C.int, configJSON *C.char, logFD C.longlong) *C.char {
 	cancel = cancelFunc
 	ctx = include.Context(ctx)
 
-	// Inject FD into TUN inbounds if they don't have one
-	var rawConfig map[string]any
-	if err := sjson.UnmarshalContext(ctx, []byte(configStr), &rawConfig); err != nil {
-		cancel()
-		cancel = nil
-		return C.CString(fmt.Sprintf("decode config error (map): %s", err))
-	}
-
-	if inbounds, ok := rawConfig["inbounds"].([]any); ok {
-		for i, inbound := range inbounds {
-			if inboundMap, ok := inbound.(map[string]any); ok {
-				if inboundMap["type"] == "tun" {
-					if _, exists := inboundMap["file_descriptor"]; !exists {
-						inboundMap["file_descriptor"] = int(fd)
-						inbounds[i] = inboundMap
-					}
-				}
-			}
-		}
-		rawConfig["inbounds"] = inbounds
-	}
-
-	updatedConfig, err := sjson.Marshal(rawConfig)
+	options, err := decodeConfig(ctx, configStr)
 	if err != nil {
-		cancel()
-		cancel = nil
-		return C.CString(fmt.Sprintf("encode updated config error: %s", err))
+		return C.CString(abortStart(invalidConfigError(err)).Message)
 	}
 
-	var options option.Options
-	if err := sjson.UnmarshalContext(ctx, updatedConfig, &options); err != nil {
-		cancel()
-		cancel = nil
-		return C.CString(fmt.Sprintf("decode config error: %s", err))
+	// Only one TUN inbound can take fd: binding two tunnels to one descriptor
+	// would silently break both.
+	var tunInbounds int
+	for _, inbound := range options.Inbounds {
+		if inbound.Type == constant.TypeTun {
+			tunInbounds++
+		}
 	}
-
-	// Sync current log level
-	if options.Log != nil {
-		currentLogLevel = options.Log.Level
+	if tunInbounds > 1 {
+		return C.CString(abortStart(newCodedError(errorCodeInvalidConfig, "config has %d tun inbounds, only one can use the provided fd", tunInbounds)).Message)
 	}
+	ctx = service.ContextWith[adapter.PlatformInterface](ctx, newMobilePlatform(ctx, int(fd)))
 
-	instance, err = box.New(box.Options{
-		Context: ctx,
-		Options: options,
-	})
-	if err != nil {
-		cancel()
-		cancel = nil
-		return C.CString(fmt.Sprintf("create service error: %s", err))
+	// With a platform interface present sing-box discards logs that have no
+	// explicit output; keep writing them to stderr as on desktop.
+	if options.Log == nil {
+		options.Log = &option.LogOptions{}
 	}
-
-	if err := instance.Start(); err != nil {
-		instance.Close()
-		instance = nil
-		cancel()
-		cancel = nil
-		return C.CString(fmt.Sprintf("start service error: %s", err))
+	if options.Log.Output == "" {
+		options.Log.Output = "stderr"
 	}
 
+	if err := launchInstance(ctx, options, 0); err != nil {
+		return C.CString(err.Message)
+	}
 	return nil
 }
 
 func main() {}
 
+// LibboxTestOutbound times a GET to targetURL through the outbound and
+// returns {"latencyMs","url"} or {"error"}. The test fields the outbound JSON
+// may carry, and what each adds to the result, are listed on testOutbound.
+//
 //export LibboxTestOutbound
 func LibboxTestOutbound(outboundJSON *C.char, targetURL *C.char, timeoutMS C.longlong) *C.char {
-	configStr := C.GoString(outboundJSON)
-	target := C.GoString(targetURL)
 	timeout := time.Duration(timeoutMS) * time.Millisecond
+	return C.CString(testOutbound(C.GoString(outboundJSON), C.GoString(targetURL), 0, timeout, false))
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+// LibboxTestOutboundWithTimeouts is LibboxTestOutbound with separate budgets:
+// connectTimeoutMS bounds dialing through the outbound and requestTimeoutMS
+// the whole request. A non-positive connectTimeoutMS defaults to half of
+// requestTimeoutMS, which is also what LibboxTestOutbound uses.
+//
+//export LibboxTestOutboundWithTimeouts
+func LibboxTestOutboundWithTimeouts(outboundJSON *C.char, targetURL *C.char, connectTimeoutMS C.longlong, requestTimeoutMS C.longlong) *C.char {
+	connectTimeout := time.Duration(connectTimeoutMS) * time.Millisecond
+	requestTimeout := time.Duration(requestTimeoutMS) * time.Millisecond
+	return C.CString(testOutbound(C.GoString(outboundJSON), C.GoString(targetURL), connectTimeout, requestTimeout, false))
+}
 
-	// Ensure registries are initialized
-	ctx = include.Context(ctx)
+// LibboxTestOutboundVerbose is LibboxTestOutbound that, when verbose is
+// non-zero, adds "ttfbMs" and "totalMs" to the result, splitting the time
+// to the first response byte from the time to the end of the body.
+// For https targets a "tls" object adds the negotiated version, cipher suite
+// and ALPN, and whether the server certificate verified; a certificate that
+// fails verification is reported there rather than failing the test.
+//
+//export LibboxTestOutboundVerbose
+func LibboxTestOutboundVerbose(outboundJSON *C.char, targetURL *C.char, timeoutMS C.longlong, verbose C.int) *C.char {
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	return C.CString(testOutbound(C.GoString(outboundJSON), C.GoString(targetURL), 0, timeout, verbose != 0))
+}
 
-	var options option.Outbound
-	if err := sjson.UnmarshalContext(ctx, []byte(configStr), &options); err != nil {
-		return C.CString(fmt.Sprintf("decode config error: %v", err))
+// defaultConnectTimeoutDivisor derives the dial budget of a test from its
+// overall timeout when none is given.
+const defaultConnectTimeoutDivisor = 2
+
+// testOutbound runs the test of LibboxTestOutbound and its variants and
+// always answers one object: {"latencyMs","url"}, url naming the entry of
+// targetStr that answered, or {"error"}. targetStr may list fallback URLs,
+// comma-separated or as a JSON array, tried in order. These test fields are
+// taken out of the outbound JSON before it is built:
+//
+//	expectedFingerprint  hex SHA-256 the https target's leaf certificate must have
+//	bindInterface        local interface, e.g. "en0", to dial the node from
+//	userAgent            User-Agent of this test's requests
+//	warmup               true times a second request over the first's connection
+//	keepAlive            true pools connections, idle for idleTimeoutMS, across retries
+//	retries              retries, waiting retryBackoffMS, doubled each time, first
+//	captureRoute         true routes through the test box's router; adds "route"
+//	domainStrategy       fixes the family domains resolve to; adds "family"
+//	happyEyeballs        true races IPv6 and IPv4, "ipv4"/"ipv6" picks one; adds "targetFamily"
+//
+// Retries never run past the timeout. "family" is left out when there is no
+// socket to read it from, as with captureRoute.
+func testOutbound(configStr string, targetStr string, connectTimeout time.Duration, timeout time.Duration, verbose bool) string {
+	targets := parseTargetURLs(targetStr)
+	if connectTimeout <= 0 {
+		connectTimeout = timeout / defaultConnectTimeoutDivisor
+	}
+	configStr, expectedFingerprint, err := takeTestOption(configStr, "expectedFingerprint")
+	if err != nil {
+		return jsonErrorString("%v", err)
 	}
-	if options.Tag == "" {
-		options.Tag = "test-outbound"
+	var fingerprint []byte
+	if expectedFingerprint != "" {
+		fingerprint, err = parseFingerprint(expectedFingerprint)
+		if err != nil {
+			return jsonErrorString("%v", err)
+		}
 	}
-
-	// Prepare minimal box options
-	boxOptions := box.Options{
-		Context: ctx,
-		Options: option.Options{
-			Log: &option.LogOptions{
-				Level: currentLogLevel,
-			},
-			Outbounds: []option.Outbound{options},
-		},
+	configStr, warmup, err := takeTestFlag(configStr, "warmup")
+	if err != nil {
+		return jsonErrorString("%v", err)
 	}
-
-	// box.New initializes everything but does not start anything until Start() is called.
-	tempInstance, err := box.New(boxOptions)
+	configStr, retries, err := takeTestNumber(configStr, "retries")
 	if err != nil {
-		return C.CString(fmt.Sprintf("create service error: %v", err))
+		return jsonErrorString("%v", err)
 	}
-	defer tempInstance.Close()
-
-	if err := tempInstance.Start(); err != nil {
-		return C.CString(fmt.Sprintf("start test service error: %v", err))
+	configStr, retryBackoffMS, err := takeTestNumber(configStr, "retryBackoffMS")
+	if err != nil {
+		return jsonErrorString("%v", err)
 	}
-
-	out, ok := tempInstance.Outbound().Outbound(options.Tag)
-	if !ok {
-		return C.CString("outbound not found after creation")
+	retry := testRetry{
+		Retries: min(max(int(retries), 0), maxTestRetries),
+		Backoff: time.Duration(max(retryBackoffMS, 0)) * time.Millisecond,
+	}
+	configStr, captureRoute, err := takeTestFlag(configStr, "captureRoute")
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	configStr, keepAlive, err := takeKeepAlive(configStr)
+	if err != nil {
+		return jsonErrorString("%v", err)
+	}
+	configStr, happyEyeballs, err := takeHappyEyeballs(configStr)
+	if err != nil {
+		return jsonErrorString("%v", err)
 	}
 
-	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	transport := &http.Transport{
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			mAddr := metadata.ParseSocksaddr(addr)
-			return out.DialContext(ctx, "tcp", mAddr)
-		},
-		DisableKeepAlives: true,
-	}
+	// Ensure registries are initialized
+	ctx = include.Context(ctx)
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   timeout,
+	ctx, configStr, err = takeUserAgent(ctx, configStr)
+	if err != nil {
+		return jsonErrorString("%v", err)
 	}
-
-	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-outbound", currentLogLevel)
 	if err != nil {
-		return C.CString(fmt.Sprintf("create request error: %v", err))
+		return jsonErrorString("%v", err)
 	}
+	defer tempInstance.Close()
 
+	var (
+		dialer   N.Dialer = out
+		recorder *routeRecorder
+	)
+	if captureRoute {
+		recorder = recordTestRoute(tempInstance)
+		dialer = &routedDialer{router: tempInstance.Router()}
+	}
 	// sing-box head requests might be blocked by some firewalls, but generate_204 usually works.
-	resp, err := client.Do(req)
+	probe := func(ctx context.Context, dialer N.Dialer, outcome *testOutcome) error {
+		familyRecorder := &familyDialer{Dialer: dialer}
+		defer func() {
+			outcome.family = familyRecorder.dialedFamily()
+		}()
+		client := outboundHTTPClientWithDialTimeout(familyRecorder, connectTimeout, timeout)
+		if verbose {
+			outcome.inspector = inspectTLS(client)
+		}
+		if fingerprint != nil {
+			pinCertificate(client, fingerprint)
+		}
+		keepAlive.apply(client)
+		if warmup || keepAlive.Enabled {
+			defer client.CloseIdleConnections()
+		}
+		if warmup {
+			warmUpClient(ctx, client, targets)
+		}
+		var err error
+		outcome.timing, outcome.target, err = retry.probeTargets(ctx, client, targets, verbose)
+		return err
+	}
+	var (
+		outcome      testOutcome
+		targetFamily string
+	)
+	dnsRouter := service.FromContext[adapter.DNSRouter](ctx)
+	if families := happyEyeballs.families(); families != nil && dnsRouter != nil {
+		outcomes := make(map[string]*testOutcome, len(families))
+		for _, family := range families {
+			outcomes[family] = &testOutcome{}
+		}
+		targetFamily, err = happyEyeballs.race(ctx, dialer, dnsRouter, func(ctx context.Context, dialer N.Dialer, family string) error {
+			return probe(ctx, dialer, outcomes[family])
+		})
+		if err == nil {
+			outcome = *outcomes[targetFamily]
+		}
+	} else {
+		err = probe(ctx, dialer, &outcome)
+	}
 	if err != nil {
-		return C.CString(fmt.Sprintf("request error: %v", err))
+		return jsonErrorString("%v", err)
+	}
+	timing, target, inspector := outcome.timing, outcome.target, outcome.inspector
+	recordTestLatency(configStr, timing.Headers)
+	family := testFamily(tempInstance, outcome.family)
+	result := map[string]any{
+		"latencyMs": timing.Headers.Milliseconds(),
+		"url":       target,
+	}
+	if verbose {
+		result["ttfbMs"] = timing.TTFB.Milliseconds()
+		result["totalMs"] = timing.Total.Milliseconds()
+		if details := inspector.lastHandshake(); details != nil {
+			result["tls"] = details
+		}
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		return C.CString(fmt.Sprintf("unexpected status code: %d", resp.StatusCode))
+	if family != "" {
+		result["family"] = family
+	}
+	if targetFamily != "" {
+		result["targetFamily"] = targetFamily
+	}
+	if recorder != nil {
+		if route := recorder.lastRoute(); route != nil {
+			result["route"] = route
+		}
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return "{}"
 	}
+	return string(jsonBytes)
+}
 
-	latency := time.Since(start).Milliseconds()
-	return C.CString(fmt.Sprintf("%d", latency))
+// warmUpClient sends the discarded warm-up request of a test and keeps its
+// connection open for the measured one. A failing warm-up is not reported,
+// the measured request fails just as well if the node is down. The caller
+// closes the client's idle connections when done.
+func warmUpClient(ctx context.Context, client *http.Client, targets []string) {
+	if transport, isTransport := client.Transport.(*http.Transport); isTransport {
+		transport.DisableKeepAlives = false
+	}
+	probeTargets(ctx, client, targets, true)
 }
 
+// LibboxFetch returns the body of a GET to targetURL through the outbound.
+// Like LibboxTestOutbound, targetURL may list fallback URLs; a target that
+// fails or answers with an error status is skipped while others remain.
+//
+// "downloadLimitKbps" and "uploadLimitKbps" fields in the outbound JSON
+// throttle the traffic through the node to that many kilobits a second, to
+// reproduce slow links; they apply to every LibboxFetch function and are
+// shared by all connections of a call.
+//
 //export LibboxFetch
 func LibboxFetch(outboundJSON *C.char, targetURL *C.char, timeoutMS C.longlong) *C.char {
-	configStr := C.GoString(outboundJSON)
-	target := C.GoString(targetURL)
-	timeout := time.Duration(timeoutMS) * time.Millisecond
+	body, err := fetch(C.GoString(outboundJSON), C.GoString(targetURL), time.Duration(timeoutMS)*time.Millisecond)
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	return C.CString(string(body))
+}
+
+func fetch(configStr string, targetStr string, timeout time.Duration) ([]byte, error) {
+	targets := parseTargetURLs(targetStr)
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -302,22 +421,112 @@ func LibboxFetch(outboundJSON *C.char, targetURL *C.char, timeoutMS C.longlong)
 	// Ensure registries are initialized
 	ctx = include.Context(ctx)
 
+	ctx, configStr, err := takeUserAgent(ctx, configStr)
+	if err != nil {
+		return nil, err
+	}
+	configStr, limits, err := takeRateLimits(configStr)
+	if err != nil {
+		return nil, err
+	}
+	configStr, keepAlive, err := takeKeepAlive(configStr)
+	if err != nil {
+		return nil, err
+	}
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-fetch", fetchLogLevel(ctx, configStr))
+	if err != nil {
+		return nil, err
+	}
+	defer tempInstance.Close()
+
+	client := outboundHTTPClient(limits.dialer(out), timeout)
+	keepAlive.apply(client)
+	defer client.CloseIdleConnections()
+
+	if len(targets) == 0 {
+		return nil, errors.New("create request error: no target url")
+	}
+	var body []byte
+	for i, target := range targets {
+		last := i == len(targets)-1
+		body, err = fetchURL(ctx, client, target, !last)
+		if err == nil || last || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// fetchLogLevel returns the _log_level a fetch config asks for, falling back
+// to the current level.
+func fetchLogLevel(ctx context.Context, configStr string) string {
 	// Try to unmarshal as generic map to check for _log_level
 	var rawConfig map[string]interface{}
 	sjson.UnmarshalContext(ctx, []byte(configStr), &rawConfig)
 
-	// Determine Log Level
-	logLevel := currentLogLevel
 	if l, ok := rawConfig["_log_level"].(string); ok && l != "" {
-		logLevel = l
+		return l
 	}
+	return currentLogLevel
+}
 
-	var options option.Outbound
-	if err := sjson.UnmarshalContext(ctx, []byte(configStr), &options); err != nil {
-		return C.CString(fmt.Sprintf("decode config error: %v", err))
+// fetchURL reads the body of a GET to target. With checkStatus set, error
+// status codes are reported as failures so the caller can try another target.
+func fetchURL(ctx context.Context, client *http.Client, target string, checkStatus bool) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request error: %v", err)
+	}
+	setUserAgent(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request error: %v", err)
 	}
+	defer resp.Body.Close()
+
+	if checkStatus && resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body error: %v", err)
+	}
+	return body, nil
+}
+
+// startTestOutbound creates and starts a throwaway box holding the outbound
+// described by configStr. configStr may also be an array of outbounds forming
+// a detour chain, in which case the last element is the entry that gets
+// returned and the others are reached through its detour references. The
+// caller must Close the returned box.
+func startTestOutbound(ctx context.Context, configStr string, defaultTag string, logLevel string) (*trackedBox, adapter.Outbound, error) {
+	configStr, bindInterface, err := takeTestOption(configStr, "bindInterface")
+	if err != nil {
+		return nil, nil, err
+	}
+	configStr, domainStrategy, err := takeTestOption(configStr, "domainStrategy")
+	if err != nil {
+		return nil, nil, err
+	}
+	outbounds, err := decodeTestOutbounds(ctx, configStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bindInterface != "" {
+		if err := bindTestInterface(outbounds, bindInterface); err != nil {
+			return nil, nil, err
+		}
+	}
+	options := &outbounds[len(outbounds)-1]
 	if options.Tag == "" {
-		options.Tag = "test-fetch"
+		options.Tag = defaultTag
+	}
+	if err := validateDetours(outbounds); err != nil {
+		return nil, nil, err
 	}
 
 	// Prepare minimal box options
@@ -327,63 +536,323 @@ func LibboxFetch(outboundJSON *C.char, targetURL *C.char, timeoutMS C.longlong)
 			Log: &option.LogOptions{
 				Level: logLevel,
 			},
-			Outbounds: []option.Outbound{options},
+			Outbounds: outbounds,
 		},
 	}
+	if domainStrategy != "" {
+		if err := applyTestDomainStrategy(&boxOptions.Options, domainStrategy); err != nil {
+			return nil, nil, err
+		}
+	}
+	// the entry comes last in a chain, so point connections the router
+	// handles at it rather than at the first outbound
+	if boxOptions.Route == nil {
+		boxOptions.Route = &option.RouteOptions{}
+	}
+	boxOptions.Route.Final = options.Tag
 
-	// box.New initializes everything but does not start anything until Start() is called.
-	tempInstance, err := box.New(boxOptions)
+	// newBox initializes everything but does not start anything until Start() is called.
+	tempInstance, err := newBox(boxOptions)
 	if err != nil {
-		return C.CString(fmt.Sprintf("create service error: %v", err))
+		return nil, nil, fmt.Errorf("create service error: %v", err)
 	}
-	defer tempInstance.Close()
 
 	if err := tempInstance.Start(); err != nil {
-		return C.CString(fmt.Sprintf("start test service error: %v", err))
+		tempInstance.Close()
+		return nil, nil, fmt.Errorf("start test service error: %v", err)
 	}
 
 	out, ok := tempInstance.Outbound().Outbound(options.Tag)
 	if !ok {
-		return C.CString("outbound not found after creation")
+		tempInstance.Close()
+		return nil, nil, errors.New("outbound not found after creation")
 	}
+	return tempInstance, out, nil
+}
+
+// takeTestOption removes a test-only string field, which sing-box would
+// reject as unknown, from the outbound in configStr (the entry of a chain)
+// and returns it separately.
+func takeTestOption(configStr string, key string) (string, string, error) {
+	configStr, value, err := takeTestValue(configStr, key)
+	text, _ := value.(string)
+	return configStr, text, err
+}
+
+// takeTestFlag is takeTestOption for a boolean field.
+func takeTestFlag(configStr string, key string) (string, bool, error) {
+	configStr, value, err := takeTestValue(configStr, key)
+	flag, _ := value.(bool)
+	return configStr, flag, err
+}
+
+// takeTestNumber is takeTestOption for a numeric field.
+func takeTestNumber(configStr string, key string) (string, float64, error) {
+	configStr, value, err := takeTestValue(configStr, key)
+	number, _ := value.(float64)
+	return configStr, number, err
+}
 
+// takeTestValue is takeTestOption for a field of any JSON type; the value is
+// nil when the field is absent.
+func takeTestValue(configStr string, key string) (string, any, error) {
+	if !strings.Contains(configStr, `"`+key+`"`) {
+		return configStr, nil, nil
+	}
+	var (
+		chain  []map[string]any
+		single map[string]any
+		entry  map[string]any
+	)
+	isChain := strings.HasPrefix(strings.TrimSpace(configStr), "[")
+	if isChain {
+		if err := sjson.Unmarshal([]byte(configStr), &chain); err != nil || len(chain) == 0 {
+			return configStr, nil, nil
+		}
+		entry = chain[len(chain)-1]
+	} else {
+		if err := sjson.Unmarshal([]byte(configStr), &single); err != nil {
+			return configStr, nil, nil
+		}
+		entry = single
+	}
+	value := entry[key]
+	delete(entry, key)
+	var (
+		content []byte
+		err     error
+	)
+	if isChain {
+		content, err = sjson.Marshal(chain)
+	} else {
+		content, err = sjson.Marshal(single)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("encode config error: %v", err)
+	}
+	return string(content), value, nil
+}
+
+// bindTestInterface makes the outbounds of the chain that dial directly,
+// those without a detour, leave through the named interface.
+func bindTestInterface(outbounds []option.Outbound, name string) error {
+	if _, err := net.InterfaceByName(name); err != nil {
+		return fmt.Errorf("bind interface %s: %v", name, err)
+	}
+	for _, outbound := range outbounds {
+		wrapper, isDialer := outbound.Options.(option.DialerOptionsWrapper)
+		if !isDialer {
+			continue
+		}
+		dialerOptions := wrapper.TakeDialerOptions()
+		if dialerOptions.Detour != "" {
+			continue
+		}
+		dialerOptions.BindInterface = name
+		wrapper.ReplaceDialerOptions(dialerOptions)
+	}
+	return nil
+}
+
+func decodeTestOutbounds(ctx context.Context, configStr string) ([]option.Outbound, error) {
+	if !strings.HasPrefix(strings.TrimSpace(configStr), "[") {
+		var options option.Outbound
+		if err := sjson.UnmarshalContext(ctx, []byte(configStr), &options); err != nil {
+			return nil, fmt.Errorf("decode config error: %v", err)
+		}
+		return []option.Outbound{options}, nil
+	}
+	var outbounds []option.Outbound
+	if err := sjson.UnmarshalContext(ctx, []byte(configStr), &outbounds); err != nil {
+		return nil, fmt.Errorf("decode config error: %v", err)
+	}
+	if len(outbounds) == 0 {
+		return nil, errors.New("decode config error: empty outbound chain")
+	}
+	return outbounds, nil
+}
+
+// validateDetours makes sure every detour in the chain names an outbound that
+// is part of it, so a typo surfaces as a clear error instead of a failed start.
+func validateDetours(outbounds []option.Outbound) error {
+	tags := make(map[string]bool)
+	for _, outbound := range outbounds {
+		if outbound.Tag != "" {
+			tags[outbound.Tag] = true
+		}
+	}
+	for _, outbound := range outbounds {
+		wrapper, ok := outbound.Options.(option.DialerOptionsWrapper)
+		if !ok {
+			continue
+		}
+		detour := wrapper.TakeDialerOptions().Detour
+		if detour != "" && !tags[detour] {
+			return fmt.Errorf("missing detour: %s", detour)
+		}
+	}
+	return nil
+}
+
+// outboundHTTPClient returns an HTTP client whose connections are dialed
+// through out. Keep-alives are disabled so every request pays for a fresh
+// connection, which is what a latency test should measure.
+func outboundHTTPClient(out N.Dialer, timeout time.Duration) *http.Client {
+	return outboundHTTPClientWithDialTimeout(out, 0, timeout)
+}
+
+// outboundHTTPClientWithDialTimeout is outboundHTTPClient that also gives up
+// on dialing after dialTimeout, when positive.
+func outboundHTTPClientWithDialTimeout(out N.Dialer, dialTimeout time.Duration, timeout time.Duration) *http.Client {
 	transport := &http.Transport{
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if dialTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, dialTimeout)
+				defer cancel()
+			}
 			mAddr := metadata.ParseSocksaddr(addr)
-			return out.DialContext(ctx, "tcp", mAddr)
+			conn, err := out.DialContext(ctx, "tcp", mAddr)
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("connect timeout: %v", err)
+			}
+			return conn, err
 		},
 		DisableKeepAlives: true,
 	}
 
-	client := &http.Client{
+	return &http.Client{
 		Transport: transport,
 		Timeout:   timeout,
 	}
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+// testOutcome is what a test's probe learned about the target that answered.
+type testOutcome struct {
+	timing    probeTiming
+	target    string
+	inspector *tlsInspector
+	// family is the address family the connection was dialed over.
+	family string
+}
+
+// probeTiming splits the duration of a probe request, each measured from the
+// moment the request was sent.
+type probeTiming struct {
+	// TTFB is the time until the first byte of the response arrived.
+	TTFB time.Duration
+	// Headers is the time until the response headers were parsed.
+	Headers time.Duration
+	// Total is the time until the whole body was read, zero unless the probe
+	// read it.
+	Total time.Duration
+}
+
+// probeURL issues a GET to target and returns the time until the response
+// headers arrived. Status codes outside 2xx/3xx count as failures. With
+// readBody set the body is read too, so the connection can be reused.
+func probeURL(ctx context.Context, client *http.Client, target string, readBody bool) (time.Duration, error) {
+	timing, err := probeURLTiming(ctx, client, target, readBody)
 	if err != nil {
-		return C.CString(fmt.Sprintf("create request error: %v", err))
+		return 0, err
 	}
+	return timing.Headers, nil
+}
 
-	resp, err := client.Do(req)
+// timedRequest is a GET that records when it was sent and when the first
+// byte of its response arrived.
+type timedRequest struct {
+	*http.Request
+	start     time.Time
+	firstByte time.Time
+}
+
+func newTimedRequest(ctx context.Context, target string) (*timedRequest, error) {
+	request := &timedRequest{}
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			request.firstByte = time.Now()
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", target, nil)
 	if err != nil {
-		return C.CString(fmt.Sprintf("request error: %v", err))
+		return nil, fmt.Errorf("create request error: %v", err)
 	}
-	defer resp.Body.Close()
+	setUserAgent(req)
+	request.Request = req
+	return request, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (r *timedRequest) do(client *http.Client) (*http.Response, error) {
+	r.start = time.Now()
+	return client.Do(r.Request)
+}
+
+// ttfb returns the time until the first response byte, or 0 when it wasn't
+// traced.
+func (r *timedRequest) ttfb() time.Duration {
+	if r.firstByte.IsZero() {
+		return 0
+	}
+	return r.firstByte.Sub(r.start)
+}
+
+// probeURLTiming is probeURL with the TTFB reported too, and the time to the
+// end of the body when readBody is set. The body is left unread otherwise.
+func probeURLTiming(ctx context.Context, client *http.Client, target string, readBody bool) (probeTiming, error) {
+	var timing probeTiming
+	req, err := newTimedRequest(ctx, target)
+	if err != nil {
+		return timing, err
+	}
+	resp, err := req.do(client)
 	if err != nil {
-		return C.CString(fmt.Sprintf("read body error: %v", err))
+		return timing, fmt.Errorf("request error: %v", err)
 	}
+	defer resp.Body.Close()
+	timing.Headers = time.Since(req.start)
 
-	return C.CString(string(body))
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return timing, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if readBody {
+		io.Copy(io.Discard, resp.Body)
+		timing.Total = time.Since(req.start)
+	}
+	timing.TTFB = timing.Headers
+	if ttfb := req.ttfb(); ttfb > 0 {
+		timing.TTFB = ttfb
+	}
+	return timing, nil
 }
 
+// LibboxTestBatch URL-tests every outbound and returns a tag→latency map,
+// omitting outbounds that failed. Fallback URLs in targetURL are retried, in
+// order, for the outbounds the first one failed on. The wrapper object may
+// carry "sort":"latency" and "maxLatencyMs" to get a sorted, filtered list
+// with the best tag instead, and "baseline":true to add the latency over the
+// direct outbound (see batchResultOptions). Untagged outbounds are tested as
+// test-<index>, and tags that collide get a numeric suffix. Either result
+// lists the tag every outbound was tested as under "tags", by position in
+// the input, and entries of the list also carry that position as "index".
+//
 //export LibboxTestBatch
 func LibboxTestBatch(outboundsJSON *C.char, targetURL *C.char, timeoutMS C.longlong) *C.char {
-	configStr := C.GoString(outboundsJSON)
-	target := C.GoString(targetURL)
 	timeout := time.Duration(timeoutMS) * time.Millisecond
+	return C.CString(testBatch(C.GoString(outboundsJSON), C.GoString(targetURL), timeout))
+}
+
+func testBatch(configStr string, targetStr string, timeout time.Duration) string {
+	return testBatchFailures(configStr, targetStr, timeout, nil)
+}
+
+// testBatchFailures is testBatch that also stores why each failed outbound
+// failed in failures, by the tag it was tested as, unless failures is nil.
+func testBatchFailures(configStr string, targetStr string, timeout time.Duration, failures map[string]error) string {
+	targets := parseTargetURLs(targetStr)
+	if len(targets) == 0 {
+		return jsonErrorString("no target url")
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeout+2*time.Second)
 	defer cancel()
@@ -394,6 +863,8 @@ func LibboxTestBatch(outboundsJSON *C.char, targetURL *C.char, timeoutMS C.longl
 	var wrapper struct {
 		Outbounds []map[string]interface{} `json:"outbounds"`
 		LogLevel  string                   `json:"log_level"`
+		UserAgent string                   `json:"userAgent"`
+		batchResultOptions
 	}
 
 	var rawOutbounds []map[string]interface{}
@@ -405,38 +876,86 @@ func LibboxTestBatch(outboundsJSON *C.char, targetURL *C.char, timeoutMS C.longl
 		if wrapper.LogLevel != "" {
 			logLevel = wrapper.LogLevel
 		}
+		ctx = withUserAgent(ctx, wrapper.UserAgent)
 	} else {
 		// Fallback: try unmarshal as array (backward compatibility)
 		if err := sjson.UnmarshalContext(ctx, []byte(configStr), &rawOutbounds); err != nil {
-			return C.CString(fmt.Sprintf("{\"error\": \"decode config error: %v\"}", err))
+			return fmt.Sprintf("{\"error\": \"decode config error: %v\"}", err)
 		}
 	}
 
-	// 2. Extract tags for urltest group
-	var outboundTags []string
-	for i := range rawOutbounds {
-		if tag, ok := rawOutbounds[i]["tag"].(string); ok && tag != "" {
-			outboundTags = append(outboundTags, tag)
-		} else {
-			tag := fmt.Sprintf("test-%d", i)
-			rawOutbounds[i]["tag"] = tag
-			outboundTags = append(outboundTags, tag)
+	// 2. Give every outbound a unique tag
+	outboundTags := assignBatchTags(rawOutbounds)
+
+	// Reuse the persistent harness instead of a throwaway box when one is open
+	if h := acquireTestHarness(); h != nil {
+		defer h.release()
+		results, err := h.testBatch(ctx, rawOutbounds, targets, failures)
+		if err != nil {
+			return jsonErrorString("%v", err)
+		}
+		var direct *uint16
+		if wrapper.Baseline {
+			direct = measureBaseline(ctx, h.box.Outbound(), targets)
 		}
+		recordLatencies(rawOutbounds, results)
+		return formatBatchResults(results, outboundTags, direct, wrapper.batchResultOptions)
+	}
+
+	// 3. Inject direct & DNS (Standard Fast Path)
+	fullConfig := testBoxConfig(logLevel, rawOutbounds)
+
+	configBytes, err := sjson.Marshal(fullConfig)
+	if err != nil {
+		return fmt.Sprintf("{\"error\": \"marshal config error: %v\"}", err)
+	}
+
+	var options option.Options
+	if err := sjson.UnmarshalContext(ctx, configBytes, &options); err != nil {
+		return fmt.Sprintf("{\"error\": \"unmarshal options error: %v\"}", err)
+	}
+
+	// 4. Start Box
+	boxOptions := box.Options{
+		Context: ctx,
+		Options: options,
 	}
 
-	// 3. Create URLTest Group Outbound
-	urlTestGroup := map[string]interface{}{
-		"type":      "urltest",
-		"tag":       "global-test-group",
-		"outbounds": outboundTags,
-		"url":       target, // e.g. http://cp.cloudflare.com/generate_204
-		"interval":  "10m",  // Prevent auto-retest during this short lifespan
+	tempInstance, err := newBox(boxOptions)
+	if err != nil {
+		return fmt.Sprintf("{\"error\": \"create service error: %v\"}", err)
 	}
+	defer tempInstance.Close()
 
-	// Add group to outbounds
-	rawOutbounds = append(rawOutbounds, urlTestGroup)
+	if err := tempInstance.Start(); err != nil {
+		return fmt.Sprintf("{\"error\": \"start test service error: %v\"}", err)
+	}
 
-	// 4. Inject direct & DNS (Standard Fast Path)
+	// 5. URL-test every outbound, falling back to the next target on failure
+	outboundManager := tempInstance.Outbound()
+	outbounds := make([]adapter.Outbound, 0, len(outboundTags))
+	for _, tag := range outboundTags {
+		if out, ok := outboundManager.Outbound(tag); ok {
+			outbounds = append(outbounds, out)
+		}
+	}
+	results := make(map[string]uint16)
+	urlTestOutbounds(ctx, outbounds, targets, results, failures)
+
+	var direct *uint16
+	if wrapper.Baseline {
+		direct = measureBaseline(ctx, outboundManager, targets)
+	}
+
+	// 6. Marshal Results
+	recordLatencies(rawOutbounds, results)
+	return formatBatchResults(results, outboundTags, direct, wrapper.batchResultOptions)
+}
+
+// testBoxConfig wraps outbounds into the minimal config used by temporary
+// test instances: a direct outbound (unless one is given), local DNS
+// through it, and interface auto-detection.
+func testBoxConfig(logLevel string, rawOutbounds []map[string]interface{}) map[string]interface{} {
 	hasDirect := false
 	for _, out := range rawOutbounds {
 		if t, ok := out["type"].(string); ok && t == "direct" {
@@ -451,7 +970,7 @@ func LibboxTestBatch(outboundsJSON *C.char, targetURL *C.char, timeoutMS C.longl
 		})
 	}
 
-	fullConfig := map[string]interface{}{
+	return map[string]interface{}{
 		"log": map[string]interface{}{
 			"level": logLevel,
 		},
@@ -476,61 +995,18 @@ func LibboxTestBatch(outboundsJSON *C.char, targetURL *C.char, timeoutMS C.longl
 			"auto_detect_interface": true,
 		},
 	}
+}
 
-	configBytes, err := sjson.Marshal(fullConfig)
-	if err != nil {
-		return C.CString(fmt.Sprintf("{\"error\": \"marshal config error: %v\"}", err))
-	}
-
-	var options option.Options
-	if err := sjson.UnmarshalContext(ctx, configBytes, &options); err != nil {
-		return C.CString(fmt.Sprintf("{\"error\": \"unmarshal options error: %v\"}", err))
-	}
-
-	// 5. Start Box
-	boxOptions := box.Options{
-		Context: ctx,
-		Options: options,
-	}
-
-	tempInstance, err := box.New(boxOptions)
-	if err != nil {
-		return C.CString(fmt.Sprintf("{\"error\": \"create service error: %v\"}", err))
-	}
-	defer tempInstance.Close()
-
-	if err := tempInstance.Start(); err != nil {
-		return C.CString(fmt.Sprintf("{\"error\": \"start test service error: %v\"}", err))
-	}
-
-	// 6. Access the Group and Trigger Test
-	// We need to access the internal adapter.
-	// The variable 'tempInstance' exposes Outbound() which is a manager.
-	outboundManager := tempInstance.Outbound()
-	testGroup, ok := outboundManager.Outbound("global-test-group")
-	if !ok {
-		return C.CString("{\"error\": \"test group not found\"}")
-	}
-
-	// We need to cast it to the *group.URLTest type to call URLTest method.
-	// However, we can't easily import 'protocol/group' due to visibility or circular deps if not careful.
-	// But we vendored it, so let's import "github.com/sagernet/sing-box/protocol/group"
-
-	urlTestInstance, ok := testGroup.(*group.URLTest)
-	if !ok {
-		return C.CString(fmt.Sprintf("{\"error\": \"invalid group type: %T\"}", testGroup))
-	}
-
-	// 7. Run Test via Native API
-	results, err := urlTestInstance.URLTest(ctx)
-	if err != nil {
-		return C.CString(fmt.Sprintf("{\"error\": \"url test failed: %v\"}", err))
-	}
+// jsonError returns {"error": "..."} for functions whose result is JSON,
+// escaping the message properly.
+func jsonError(format string, args ...any) *C.char {
+	return C.CString(jsonErrorString(format, args...))
+}
 
-	// 8. Marshal Results
-	jsonBytes, err := sjson.Marshal(results)
+func jsonErrorString(format string, args ...any) string {
+	content, err := sjson.Marshal(map[string]string{"error": fmt.Sprintf(format, args...)})
 	if err != nil {
-		return C.CString("{}")
+		return "{\"error\": \"internal error\"}"
 	}
-	return C.CString(string(jsonBytes))
+	return string(content)
 }
@@ -0,0 +1,595 @@
+package main
+
+import "C"
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+type migrationResult struct {
+	Config     sjson.RawMessage `json:"config"`
+	Migrations []string         `json:"migrations"`
+	// Notes describe what a migration had to drop or leave for the user.
+	Notes []string `json:"notes"`
+}
+
+// configMigration rewrites one removed or deprecated piece of the config
+// schema, reporting whether it changed anything. Anything it cannot carry
+// over faithfully is explained in a note.
+type configMigration struct {
+	name  string
+	apply func(config map[string]any, notes *[]string) bool
+}
+
+// configMigrations run in order; later ones see the output of earlier ones.
+var configMigrations = []configMigration{
+	{"tun-address-fields", migrateTunAddressFields},
+	{"legacy-inbound-fields", migrateLegacyInboundFields},
+	{"dns-outbound", migrateDNSOutbound},
+	{"direct-override-fields", migrateDirectOverrideFields},
+	{"wireguard-outbound", migrateWireGuardOutbound},
+	{"legacy-dns-servers", migrateLegacyDNSServers},
+	{"dns-rule-outbound-any", migrateDNSRuleOutboundAny},
+	{"geoip-geosite", migrateGeoDatabases},
+}
+
+// LibboxMigrateConfig upgrades a config written for an older sing-box to the
+// schema of the bundled one: legacy TUN address and inbound sniff fields, the
+// dns outbound, direct override fields and the WireGuard outbound become rule
+// actions, route options and endpoints, legacy DNS servers get their types,
+// and geoip/geosite rules use the official rule-sets. Returns {"config",
+// "migrations","notes"} with the names of the migrations applied; when none
+// applies, config is the input as written, less any comments and trailing
+// commas, and a note says so.
+//
+//export LibboxMigrateConfig
+func LibboxMigrateConfig(configJSON *C.char) *C.char {
+	result, err := migrateConfig([]byte(C.GoString(configJSON)))
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func migrateConfig(content []byte) (*migrationResult, error) {
+	content = stripJSONC(content)
+	var config map[string]any
+	if err := sjson.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("decode config error: %v", err)
+	}
+	result := &migrationResult{Migrations: []string{}, Notes: []string{}}
+	for _, migration := range configMigrations {
+		if migration.apply(config, &result.Notes) {
+			result.Migrations = append(result.Migrations, migration.name)
+		}
+	}
+	if len(result.Migrations) == 0 {
+		// comments and trailing commas are gone, the rest is as written
+		result.Config = content
+		result.Notes = append(result.Notes, "no migration needed")
+	} else {
+		migrated, err := sjson.Marshal(config)
+		if err != nil {
+			return nil, fmt.Errorf("encode config error: %v", err)
+		}
+		result.Config = migrated
+	}
+	return result, nil
+}
+
+// objectList returns the objects of the list under key, skipping anything
+// else.
+func objectList(parent map[string]any, key string) []map[string]any {
+	list, _ := parent[key].([]any)
+	objects := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		if object, isObject := item.(map[string]any); isObject {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+// childObject returns the object under key, creating it when missing.
+func childObject(parent map[string]any, key string) map[string]any {
+	if object, isObject := parent[key].(map[string]any); isObject {
+		return object
+	}
+	object := make(map[string]any)
+	parent[key] = object
+	return object
+}
+
+// stringList reads a listable field, a string or an array of strings.
+func stringList(value any) []string {
+	switch value := value.(type) {
+	case string:
+		if value != "" {
+			return []string{value}
+		}
+	case []any:
+		var items []string
+		for _, item := range value {
+			if item, isString := item.(string); isString && item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return nil
+}
+
+func anyList(items []string) []any {
+	list := make([]any, len(items))
+	for i, item := range items {
+		list[i] = item
+	}
+	return list
+}
+
+// walkRules calls visit for every rule of the list under key, descending
+// into logical rules.
+func walkRules(parent map[string]any, key string, visit func(rule map[string]any)) {
+	for _, rule := range objectList(parent, key) {
+		visit(rule)
+		if rule["type"] == "logical" {
+			walkRules(rule, "rules", visit)
+		}
+	}
+}
+
+// routeRulesTo returns the top-level route rules routing to outbound.
+func routeRulesTo(config map[string]any, outbound string) []map[string]any {
+	route, _ := config["route"].(map[string]any)
+	var rules []map[string]any
+	for _, rule := range objectList(route, "rules") {
+		action, _ := rule["action"].(string)
+		if rule["outbound"] == outbound && (action == "" || action == "route") {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func migrateTunAddressFields(config map[string]any, notes *[]string) bool {
+	renames := [][3]string{
+		{"inet4_address", "inet6_address", "address"},
+		{"inet4_route_address", "inet6_route_address", "route_address"},
+		{"inet4_route_exclude_address", "inet6_route_exclude_address", "route_exclude_address"},
+	}
+	changed := false
+	for _, inbound := range objectList(config, "inbounds") {
+		if inbound["type"] != "tun" {
+			continue
+		}
+		for _, rename := range renames {
+			legacy := append(stringList(inbound[rename[0]]), stringList(inbound[rename[1]])...)
+			_, has4 := inbound[rename[0]]
+			_, has6 := inbound[rename[1]]
+			if !has4 && !has6 {
+				continue
+			}
+			delete(inbound, rename[0])
+			delete(inbound, rename[1])
+			merged := append(stringList(inbound[rename[2]]), legacy...)
+			if len(merged) > 0 {
+				inbound[rename[2]] = anyList(merged)
+			}
+			changed = true
+		}
+		if _, loaded := inbound["gso"]; loaded {
+			delete(inbound, "gso")
+			*notes = append(*notes, "removed the gso option of the tun inbound, which sing-box no longer supports")
+			changed = true
+		}
+	}
+	return changed
+}
+
+func migrateLegacyInboundFields(config map[string]any, notes *[]string) bool {
+	var rules []any
+	changed := false
+	inbounds := objectList(config, "inbounds")
+	tags := make(map[string]bool)
+	for _, inbound := range inbounds {
+		if tag, _ := inbound["tag"].(string); tag != "" {
+			tags[tag] = true
+		}
+	}
+	for _, inbound := range inbounds {
+		sniff, _ := inbound["sniff"].(bool)
+		sniffTimeout, _ := inbound["sniff_timeout"].(string)
+		domainStrategy, _ := inbound["domain_strategy"].(string)
+		unmapping, _ := inbound["udp_disable_domain_unmapping"].(bool)
+		override, _ := inbound["sniff_override_destination"].(bool)
+		legacy := false
+		for _, key := range []string{"sniff", "sniff_timeout", "domain_strategy", "udp_disable_domain_unmapping", "sniff_override_destination"} {
+			if _, loaded := inbound[key]; loaded {
+				delete(inbound, key)
+				legacy = true
+			}
+		}
+		if !legacy {
+			continue
+		}
+		changed = true
+		tag, _ := inbound["tag"].(string)
+		if tag == "" && (sniff || domainStrategy != "" || unmapping) {
+			inboundType, _ := inbound["type"].(string)
+			tag = inboundType + "-in"
+			for suffix := 2; tags[tag]; suffix++ {
+				tag = fmt.Sprintf("%s-in-%d", inboundType, suffix)
+			}
+			tags[tag] = true
+			inbound["tag"] = tag
+			*notes = append(*notes, fmt.Sprintf("tagged an untagged %s inbound %s so rules can refer to it", inboundType, tag))
+		}
+		if sniff {
+			rule := map[string]any{"inbound": []any{tag}, "action": "sniff"}
+			if sniffTimeout != "" {
+				rule["timeout"] = sniffTimeout
+			}
+			rules = append(rules, rule)
+		}
+		if domainStrategy != "" {
+			rules = append(rules, map[string]any{"inbound": []any{tag}, "action": "resolve", "strategy": domainStrategy})
+		}
+		if unmapping {
+			rules = append(rules, map[string]any{"inbound": []any{tag}, "action": "route-options", "udp_disable_domain_unmapping": true})
+		}
+		if override {
+			*notes = append(*notes, fmt.Sprintf("dropped sniff_override_destination of inbound %s, which has no rule action equivalent", tag))
+		}
+	}
+	if len(rules) > 0 {
+		route := childObject(config, "route")
+		existing, _ := route["rules"].([]any)
+		route["rules"] = append(rules, existing...)
+	}
+	return changed
+}
+
+func migrateDNSOutbound(config map[string]any, notes *[]string) bool {
+	outbounds, _ := config["outbounds"].([]any)
+	var tags []string
+	kept := outbounds[:0:0]
+	for _, item := range outbounds {
+		if outbound, isObject := item.(map[string]any); isObject && outbound["type"] == "dns" {
+			tag, _ := outbound["tag"].(string)
+			tags = append(tags, tag)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	if len(tags) == 0 {
+		return false
+	}
+	config["outbounds"] = kept
+	route, _ := config["route"].(map[string]any)
+	for _, tag := range tags {
+		for _, rule := range routeRulesTo(config, tag) {
+			delete(rule, "outbound")
+			rule["action"] = "hijack-dns"
+		}
+		if route != nil && tag != "" && route["final"] == tag {
+			delete(route, "final")
+			*notes = append(*notes, fmt.Sprintf("removed route.final %s, a dns outbound, which has no equivalent", tag))
+		}
+	}
+	return true
+}
+
+func migrateDirectOverrideFields(config map[string]any, notes *[]string) bool {
+	changed := false
+	route, _ := config["route"].(map[string]any)
+	for _, outbound := range objectList(config, "outbounds") {
+		if outbound["type"] != "direct" {
+			continue
+		}
+		address, hasAddress := outbound["override_address"]
+		port, hasPort := outbound["override_port"]
+		if !hasAddress && !hasPort {
+			continue
+		}
+		delete(outbound, "override_address")
+		delete(outbound, "override_port")
+		changed = true
+		tag, _ := outbound["tag"].(string)
+		for _, rule := range routeRulesTo(config, tag) {
+			if hasAddress {
+				rule["override_address"] = address
+			}
+			if hasPort {
+				rule["override_port"] = port
+			}
+		}
+		if route != nil && route["final"] == tag {
+			*notes = append(*notes, fmt.Sprintf("traffic reaching direct outbound %s through route.final no longer gets its destination overridden", tag))
+		}
+	}
+	return changed
+}
+
+func migrateWireGuardOutbound(config map[string]any, notes *[]string) bool {
+	outbounds, _ := config["outbounds"].([]any)
+	var endpoints []any
+	kept := outbounds[:0:0]
+	for _, item := range outbounds {
+		outbound, isObject := item.(map[string]any)
+		if !isObject || outbound["type"] != "wireguard" {
+			kept = append(kept, item)
+			continue
+		}
+		endpoint := make(map[string]any, len(outbound))
+		for key, value := range outbound {
+			endpoint[key] = value
+		}
+		for legacy, current := range map[string]string{
+			"system_interface": "system",
+			"interface_name":   "name",
+			"local_address":    "address",
+		} {
+			if value, loaded := endpoint[legacy]; loaded {
+				delete(endpoint, legacy)
+				endpoint[current] = value
+			}
+		}
+		delete(endpoint, "gso")
+		peerFields := map[string]string{
+			"server":         "address",
+			"server_port":    "port",
+			"public_key":     "public_key",
+			"pre_shared_key": "pre_shared_key",
+			"allowed_ips":    "allowed_ips",
+			"reserved":       "reserved",
+		}
+		var peers []any
+		for _, legacyPeer := range objectList(endpoint, "peers") {
+			peer := make(map[string]any)
+			for legacy, current := range peerFields {
+				if value, loaded := legacyPeer[legacy]; loaded {
+					peer[current] = value
+				}
+			}
+			peers = append(peers, peer)
+		}
+		if len(peers) == 0 {
+			peer := map[string]any{"allowed_ips": []any{"0.0.0.0/0", "::/0"}}
+			for legacy, current := range map[string]string{
+				"server":          "address",
+				"server_port":     "port",
+				"peer_public_key": "public_key",
+				"pre_shared_key":  "pre_shared_key",
+				"reserved":        "reserved",
+			} {
+				if value, loaded := endpoint[legacy]; loaded {
+					peer[current] = value
+				}
+			}
+			peers = append(peers, peer)
+		}
+		for _, key := range []string{"server", "server_port", "peer_public_key", "pre_shared_key", "reserved"} {
+			delete(endpoint, key)
+		}
+		endpoint["peers"] = peers
+		endpoints = append(endpoints, endpoint)
+	}
+	if len(endpoints) == 0 {
+		return false
+	}
+	config["outbounds"] = kept
+	existing, _ := config["endpoints"].([]any)
+	config["endpoints"] = append(existing, endpoints...)
+	return true
+}
+
+// legacyDNSPorts are the default ports of the DNS server types, left out of
+// migrated servers.
+var legacyDNSPorts = map[string]string{
+	"udp":   "53",
+	"tcp":   "53",
+	"tls":   "853",
+	"quic":  "853",
+	"https": "443",
+	"h3":    "443",
+}
+
+func migrateLegacyDNSServers(config map[string]any, notes *[]string) bool {
+	dns, _ := config["dns"].(map[string]any)
+	changed := false
+	for _, server := range objectList(dns, "servers") {
+		address, _ := server["address"].(string)
+		if _, typed := server["type"]; typed || address == "" {
+			continue
+		}
+		tag, _ := server["tag"].(string)
+		serverURL, _ := url.Parse(address)
+		scheme := ""
+		if serverURL != nil && strings.Contains(address, "://") {
+			scheme = serverURL.Scheme
+		}
+		switch {
+		case address == "local":
+			server["type"] = "local"
+		case address == "fakeip":
+			server["type"] = "fakeip"
+			if fakeIP, _ := dns["fakeip"].(map[string]any); fakeIP != nil {
+				for _, key := range []string{"inet4_range", "inet6_range"} {
+					if value, loaded := fakeIP[key]; loaded {
+						server[key] = value
+					}
+				}
+			}
+		case scheme == "dhcp":
+			server["type"] = "dhcp"
+			if serverURL.Host != "" && serverURL.Host != "auto" {
+				server["interface"] = serverURL.Host
+			}
+		case scheme == "rcode":
+			*notes = append(*notes, fmt.Sprintf("left rcode DNS server %s as is; replace it with a predefined rule action", tag))
+			continue
+		case scheme == "" || legacyDNSPorts[scheme] != "":
+			if scheme == "" {
+				scheme = "udp"
+				serverURL = &url.URL{Host: address}
+			}
+			server["type"] = scheme
+			host, port, err := net.SplitHostPort(serverURL.Host)
+			if err != nil {
+				host, port = strings.Trim(serverURL.Host, "[]"), ""
+			}
+			server["server"] = host
+			if port != "" && port != legacyDNSPorts[scheme] {
+				var portNumber int
+				fmt.Sscan(port, &portNumber)
+				server["server_port"] = portNumber
+			}
+			if (scheme == "https" || scheme == "h3") && serverURL.Path != "" && serverURL.Path != "/dns-query" {
+				server["path"] = serverURL.Path
+			}
+		default:
+			*notes = append(*notes, fmt.Sprintf("left DNS server %s with unknown address %s as is", tag, address))
+			continue
+		}
+		delete(server, "address")
+		if resolver, _ := server["address_resolver"].(string); resolver != "" {
+			domainResolver := map[string]any{"server": resolver}
+			if strategy, _ := server["address_strategy"].(string); strategy != "" {
+				domainResolver["strategy"] = strategy
+			}
+			server["domain_resolver"] = domainResolver
+		}
+		if delay, loaded := server["address_fallback_delay"]; loaded {
+			server["fallback_delay"] = delay
+		}
+		delete(server, "address_resolver")
+		delete(server, "address_strategy")
+		delete(server, "address_fallback_delay")
+		if _, loaded := server["strategy"]; loaded {
+			delete(server, "strategy")
+			*notes = append(*notes, fmt.Sprintf("dropped the strategy of DNS server %s; set it on the DNS rules using the server instead", tag))
+		}
+		changed = true
+	}
+	if changed {
+		delete(dns, "fakeip")
+	}
+	return changed
+}
+
+func migrateDNSRuleOutboundAny(config map[string]any, notes *[]string) bool {
+	dns, _ := config["dns"].(map[string]any)
+	rules, _ := dns["rules"].([]any)
+	kept := rules[:0:0]
+	changed := false
+	for _, item := range rules {
+		rule, isObject := item.(map[string]any)
+		server, _ := rule["server"].(string)
+		onlyAny := isObject && server != "" && slices.Equal(stringList(rule["outbound"]), []string{"any"})
+		for key := range rule {
+			if key != "outbound" && key != "server" && key != "action" {
+				onlyAny = false
+			}
+		}
+		if !onlyAny {
+			kept = append(kept, item)
+			continue
+		}
+		changed = true
+		route := childObject(config, "route")
+		if _, loaded := route["default_domain_resolver"]; !loaded {
+			route["default_domain_resolver"] = server
+		} else {
+			*notes = append(*notes, fmt.Sprintf("dropped the outbound any DNS rule for %s, route.default_domain_resolver is already set", server))
+		}
+	}
+	if changed {
+		dns["rules"] = kept
+	}
+	return changed
+}
+
+const (
+	geositeRuleSetURL = "https://raw.githubusercontent.com/SagerNet/sing-geosite/rule-set/geosite-%s.srs"
+	geoipRuleSetURL   = "https://raw.githubusercontent.com/SagerNet/sing-geoip/rule-set/geoip-%s.srs"
+)
+
+func migrateGeoDatabases(config map[string]any, notes *[]string) bool {
+	route, _ := config["route"].(map[string]any)
+	dns, _ := config["dns"].(map[string]any)
+	ruleSets := make(map[string]string)
+	var order []string
+	convert := func(rule map[string]any) {
+		var tags []string
+		for _, key := range []string{"geosite", "geoip", "source_geoip"} {
+			names := stringList(rule[key])
+			if _, loaded := rule[key]; !loaded {
+				continue
+			}
+			delete(rule, key)
+			for _, name := range names {
+				prefix, format := "geoip", geoipRuleSetURL
+				if key == "geosite" {
+					prefix, format = "geosite", geositeRuleSetURL
+				}
+				tag := prefix + "-" + name
+				if _, loaded := ruleSets[tag]; !loaded {
+					ruleSets[tag] = fmt.Sprintf(format, name)
+					order = append(order, tag)
+				}
+				tags = append(tags, tag)
+			}
+			if key == "source_geoip" && len(names) > 0 {
+				rule["rule_set_ip_cidr_match_source"] = true
+			}
+		}
+		if len(tags) > 0 {
+			rule["rule_set"] = anyList(append(stringList(rule["rule_set"]), tags...))
+		}
+	}
+	walkRules(route, "rules", convert)
+	walkRules(dns, "rules", convert)
+	changed := len(order) > 0
+	if route != nil {
+		for _, key := range []string{"geoip", "geosite"} {
+			if _, loaded := route[key]; loaded {
+				delete(route, key)
+				changed = true
+			}
+		}
+	}
+	if len(order) == 0 {
+		return changed
+	}
+	route = childObject(config, "route")
+	existing, _ := route["rule_set"].([]any)
+	defined := make(map[string]bool)
+	for _, ruleSet := range objectList(route, "rule_set") {
+		if tag, _ := ruleSet["tag"].(string); tag != "" {
+			defined[tag] = true
+		}
+	}
+	for _, tag := range order {
+		if defined[tag] {
+			continue
+		}
+		existing = append(existing, map[string]any{
+			"tag":    tag,
+			"type":   "remote",
+			"format": "binary",
+			"url":    ruleSets[tag],
+		})
+	}
+	route["rule_set"] = existing
+	*notes = append(*notes, "geoip and geosite rules now use remote rule-sets, downloaded on first start")
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+func TestMigrateConfigKeepsCommentedConfig(t *testing.T) {
+	content := `{
+	// written by hand
+	"log": {"level": "info",},
+	/* no legacy fields */
+	"outbounds": [{"type": "direct", "tag": "direct"},],
+}`
+	result, err := migrateConfig([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Migrations) != 0 {
+		t.Fatalf("got migrations %q, want none", result.Migrations)
+	}
+	if !slices.Contains(result.Notes, "no migration needed") {
+		t.Fatalf("got notes %q, want the no-migration note", result.Notes)
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		t.Fatalf("encode result: %v", err)
+	}
+	var decoded struct {
+		Config struct {
+			Log struct {
+				Level string `json:"level"`
+			} `json:"log"`
+		} `json:"config"`
+	}
+	if err := sjson.Unmarshal(jsonBytes, &decoded); err != nil {
+		t.Fatalf("decode result: %v", err)
+	}
+	if decoded.Config.Log.Level != "info" {
+		t.Fatalf("config lost its content: %s", jsonBytes)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"syscall"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/common/process"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	tun "github.com/sagernet/sing-tun"
+	"github.com/sagernet/sing/common/control"
+	"github.com/sagernet/sing/common/logger"
+	"github.com/sagernet/sing/common/x/list"
+)
+
+// mobilePlatform hands the TUN descriptor opened by the host to the TUN
+// inbound. sing-box has no file_descriptor inbound option; a platform
+// interface that fills tun.Options.FileDescriptor is the only way in.
+// On desktop Linux it also finds connection owners, to name them for
+// package_name rules, and on macOS it reads the Wi-Fi state for wifi_ssid
+// and wifi_bssid rules. Everything else is left to sing-box's own
+// implementations.
+type mobilePlatform struct {
+	ctx            context.Context
+	access         sync.Mutex
+	fd             int
+	used           bool
+	networkManager adapter.NetworkManager
+
+	searcherAccess sync.Mutex
+	searcher       process.Searcher
+}
+
+var _ adapter.PlatformInterface = (*mobilePlatform)(nil)
+
+// newMobilePlatform serves the instance started with ctx; what the platform
+// opens is released when ctx is done.
+func newMobilePlatform(ctx context.Context, fd int) *mobilePlatform {
+	return &mobilePlatform{ctx: ctx, fd: fd}
+}
+
+func (p *mobilePlatform) Initialize(networkManager adapter.NetworkManager) error {
+	p.access.Lock()
+	p.networkManager = networkManager
+	p.access.Unlock()
+	return nil
+}
+
+func (p *mobilePlatform) UsePlatformAutoDetectInterfaceControl() bool {
+	return false
+}
+
+func (p *mobilePlatform) AutoDetectInterfaceControl(fd int) error {
+	return nil
+}
+
+func (p *mobilePlatform) UsePlatformInterface() bool {
+	return true
+}
+
+func (p *mobilePlatform) OpenInterface(options *tun.Options, platformOptions option.TunPlatformOptions) (tun.Tun, error) {
+	p.access.Lock()
+	defer p.access.Unlock()
+	if p.used {
+		return nil, errors.New("tun fd is already bound to another tun inbound")
+	}
+	options.FileDescriptor = p.fd
+	tunInterface, err := tun.New(*options)
+	if err != nil {
+		return nil, err
+	}
+	p.used = true
+	return tunInterface, nil
+}
+
+// The default interface monitor is still sing-box's own, built the way the
+// network manager does when no platform interface is present.
+func (p *mobilePlatform) UsePlatformDefaultInterfaceMonitor() bool {
+	return true
+}
+
+func (p *mobilePlatform) CreateDefaultInterfaceMonitor(logger logger.Logger) tun.DefaultInterfaceMonitor {
+	monitor := newStandaloneInterfaceMonitor(logger)
+	if platformWIFIState {
+		monitor.RegisterCallback(p.updateWIFIState)
+	}
+	return monitor
+}
+
+// updateWIFIState re-reads the Wi-Fi state when the default interface
+// changes. sing-box would do it itself, but skips it for platforms that
+// don't list their network interfaces.
+func (p *mobilePlatform) updateWIFIState(defaultInterface *control.Interface, flags int) {
+	p.access.Lock()
+	networkManager := p.networkManager
+	p.access.Unlock()
+	if networkManager != nil && networkManager.NeedWIFIState() {
+		networkManager.UpdateWIFIState()
+	}
+}
+
+func (p *mobilePlatform) UsePlatformNetworkInterfaces() bool {
+	return false
+}
+
+func (p *mobilePlatform) NetworkInterfaces() ([]adapter.NetworkInterface, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (p *mobilePlatform) UnderNetworkExtension() bool {
+	return false
+}
+
+func (p *mobilePlatform) NetworkExtensionIncludeAllNetworks() bool {
+	return false
+}
+
+func (p *mobilePlatform) ClearDNSCache() {
+}
+
+func (p *mobilePlatform) RequestPermissionForWIFIState() error {
+	return nil
+}
+
+func (p *mobilePlatform) ReadWIFIState() adapter.WIFIState {
+	return readWIFIState()
+}
+
+func (p *mobilePlatform) SystemCertificates() []string {
+	return nil
+}
+
+func (p *mobilePlatform) UsePlatformConnectionOwnerFinder() bool {
+	return platformPackageNames
+}
+
+// FindConnectionOwner looks the owner up with sing-box's own searcher and
+// adds the names package_name rules match against.
+func (p *mobilePlatform) FindConnectionOwner(request *adapter.FindConnectionOwnerRequest) (*adapter.ConnectionOwner, error) {
+	searcher, err := p.processSearcher()
+	if err != nil {
+		return nil, err
+	}
+	var network string
+	switch request.IpProtocol {
+	case syscall.IPPROTO_TCP:
+		network = "tcp"
+	case syscall.IPPROTO_UDP:
+		network = "udp"
+	default:
+		return nil, process.ErrNotFound
+	}
+	source, err := netip.ParseAddr(request.SourceAddress)
+	if err != nil {
+		return nil, process.ErrNotFound
+	}
+	destination, err := netip.ParseAddr(request.DestinationAddress)
+	if err != nil {
+		return nil, process.ErrNotFound
+	}
+	owner, err := searcher.FindProcessInfo(p.ctx, network,
+		netip.AddrPortFrom(source, uint16(request.SourcePort)),
+		netip.AddrPortFrom(destination, uint16(request.DestinationPort)))
+	if err != nil {
+		return nil, err
+	}
+	owner.AndroidPackageNames = ownerPackageNames(owner)
+	return owner, nil
+}
+
+// processSearcher creates the searcher on first use and closes it with the
+// instance.
+func (p *mobilePlatform) processSearcher() (process.Searcher, error) {
+	p.searcherAccess.Lock()
+	defer p.searcherAccess.Unlock()
+	if p.searcher != nil {
+		return p.searcher, nil
+	}
+	if p.ctx.Err() != nil {
+		return nil, p.ctx.Err()
+	}
+	searcher, err := process.NewSearcher(process.Config{Logger: log.StdLogger()})
+	if err != nil {
+		return nil, err
+	}
+	p.searcher = searcher
+	context.AfterFunc(p.ctx, func() {
+		searcher.Close()
+	})
+	return searcher, nil
+}
+
+func (p *mobilePlatform) UsePlatformWIFIMonitor() bool {
+	return platformWIFIState
+}
+
+func (p *mobilePlatform) UsePlatformNotification() bool {
+	return true
+}
+
+// SendNotification hands the notification to the host callback registered
+// with LibboxSetNotificationCallback.
+func (p *mobilePlatform) SendNotification(notification *adapter.Notification) error {
+	return postNotification(notification)
+}
+
+// standaloneInterfaceMonitor owns the network update monitor behind the
+// default interface monitor, which the network manager would otherwise start
+// and close itself. When either could not be created, Start reports why.
+type standaloneInterfaceMonitor struct {
+	err              error
+	networkMonitor   tun.NetworkUpdateMonitor
+	interfaceMonitor tun.DefaultInterfaceMonitor
+}
+
+// newStandaloneInterfaceMonitor watches the system's default route the way
+// sing-box does without a platform interface: netlink on Linux, a routing
+// socket on macOS.
+func newStandaloneInterfaceMonitor(logger logger.Logger) *standaloneInterfaceMonitor {
+	networkMonitor, err := tun.NewNetworkUpdateMonitor(logger)
+	if err != nil {
+		return &standaloneInterfaceMonitor{err: err}
+	}
+	interfaceMonitor, err := tun.NewDefaultInterfaceMonitor(networkMonitor, logger, tun.DefaultInterfaceMonitorOptions{
+		InterfaceFinder: control.NewDefaultInterfaceFinder(),
+	})
+	if err != nil {
+		return &standaloneInterfaceMonitor{err: err}
+	}
+	return &standaloneInterfaceMonitor{networkMonitor: networkMonitor, interfaceMonitor: interfaceMonitor}
+}
+
+func (m *standaloneInterfaceMonitor) Start() error {
+	if m.err != nil {
+		return m.err
+	}
+	if err := m.networkMonitor.Start(); err != nil {
+		return err
+	}
+	return m.interfaceMonitor.Start()
+}
+
+func (m *standaloneInterfaceMonitor) Close() error {
+	if m.err != nil {
+		return nil
+	}
+	return errors.Join(m.interfaceMonitor.Close(), m.networkMonitor.Close())
+}
+
+func (m *standaloneInterfaceMonitor) DefaultInterface() *control.Interface {
+	if m.err != nil {
+		return nil
+	}
+	return m.interfaceMonitor.DefaultInterface()
+}
+
+func (m *standaloneInterfaceMonitor) OverrideAndroidVPN() bool {
+	return false
+}
+
+func (m *standaloneInterfaceMonitor) AndroidVPNEnabled() bool {
+	if m.err != nil {
+		return false
+	}
+	return m.interfaceMonitor.AndroidVPNEnabled()
+}
+
+func (m *standaloneInterfaceMonitor) RegisterCallback(callback tun.DefaultInterfaceUpdateCallback) *list.Element[tun.DefaultInterfaceUpdateCallback] {
+	if m.err != nil {
+		return nil
+	}
+	return m.interfaceMonitor.RegisterCallback(callback)
+}
+
+func (m *standaloneInterfaceMonitor) UnregisterCallback(element *list.Element[tun.DefaultInterfaceUpdateCallback]) {
+	if m.err != nil {
+		return
+	}
+	m.interfaceMonitor.UnregisterCallback(element)
+}
+
+func (m *standaloneInterfaceMonitor) RegisterMyInterface(interfaceName string) {
+	if m.err != nil {
+		return
+	}
+	m.interfaceMonitor.RegisterMyInterface(interfaceName)
+}
+
+func (m *standaloneInterfaceMonitor) MyInterface() string {
+	if m.err != nil {
+		return ""
+	}
+	return m.interfaceMonitor.MyInterface()
+}
@@ -0,0 +1,96 @@
+package main
+
+import "C"
+import (
+	"reflect"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
+	tun "github.com/sagernet/sing-tun"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+type modeInfo struct {
+	Mode  string          `json:"mode"`
+	TUN   []tunInbound    `json:"tun"`
+	Proxy []inboundStatus `json:"proxy"`
+}
+
+type tunInbound struct {
+	Tag       string `json:"tag"`
+	Interface string `json:"interface,omitempty"`
+	FD        int    `json:"fd,omitempty"`
+}
+
+// LibboxGetMode tells how the running instance takes in traffic, from its
+// started inbounds rather than the config: {"mode","tun","proxy"} where mode
+// is "tun" for an open TUN interface, "proxy" for a listening mixed, SOCKS
+// or HTTP inbound the system proxy can point at, "both", or "none" (also when
+// the service is not running). tun lists the TUN inbounds with the interface
+// name and, where the platform has one, descriptor; proxy lists the proxy
+// inbounds as LibboxGetInboundStatus does.
+//
+//export LibboxGetMode
+func LibboxGetMode() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	info := modeInfo{Mode: "none", TUN: []tunInbound{}, Proxy: []inboundStatus{}}
+	if instance != nil {
+		var connections map[string]int
+		if instanceConnections != nil {
+			connections = instanceConnections.inboundCounts()
+		}
+		var proxyListening bool
+		for _, inbound := range instance.Inbound().Inbounds() {
+			switch inbound.Type() {
+			case constant.TypeTun:
+				if tunInterface, loaded := inboundTUNInterface(inbound); loaded {
+					info.TUN = append(info.TUN, tunInterface)
+				}
+			case constant.TypeMixed, constant.TypeSOCKS, constant.TypeHTTP:
+				status := inboundStatus{
+					Tag:         inbound.Tag(),
+					Type:        inbound.Type(),
+					Listening:   inboundListening(inbound),
+					Connections: connections[inbound.Tag()],
+				}
+				proxyListening = proxyListening || status.Listening
+				info.Proxy = append(info.Proxy, status)
+			}
+		}
+		switch {
+		case len(info.TUN) > 0 && proxyListening:
+			info.Mode = "both"
+		case len(info.TUN) > 0:
+			info.Mode = "tun"
+		case proxyListening:
+			info.Mode = "proxy"
+		}
+	}
+	jsonBytes, err := sjson.Marshal(info)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// inboundTUNInterface reads the interface a TUN inbound opened from its
+// unexported "tunIf" field; loaded is false while none is open. The
+// descriptor is that of sing-tun's native interface, in its "tunFd" field.
+func inboundTUNInterface(inbound adapter.Inbound) (tunInbound, bool) {
+	info := tunInbound{Tag: inbound.Tag()}
+	field := unexportedField(reflect.ValueOf(inbound), "tunIf")
+	if !field.IsValid() || field.IsNil() {
+		return info, false
+	}
+	tunInterface, isTun := field.Interface().(tun.Tun)
+	if !isTun {
+		return info, false
+	}
+	info.Interface, _ = tunInterface.Name()
+	if fd := unexportedField(reflect.ValueOf(tunInterface), "tunFd"); fd.IsValid() && fd.Kind() == reflect.Int {
+		info.FD = int(fd.Int())
+	}
+	return info, true
+}
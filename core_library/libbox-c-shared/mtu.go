@@ -0,0 +1,143 @@
+package main
+
+import "C"
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing/common"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const (
+	// mtuProbeMin and mtuProbeMax bound the UDP payload sizes
+	// LibboxProbeMTU searches, up to jumbo frames.
+	mtuProbeMin = 64
+	mtuProbeMax = 9000
+	// mtuProbeWait is how long a probe waits for its echo, and
+	// mtuProbeAttempts how often a size is sent before it counts as too
+	// big, so a single lost packet doesn't shrink the result.
+	mtuProbeWait     = time.Second
+	mtuProbeAttempts = 2
+)
+
+type mtuResult struct {
+	MaxPayload int `json:"maxPayload"`
+	Probes     int `json:"probes"`
+	// Complete is false when timeoutMS ran out before the search
+	// narrowed down to one size; MaxPayload is then a lower bound.
+	Complete bool `json:"complete"`
+}
+
+// LibboxProbeMTU finds the largest UDP payload, in bytes without IP and UDP
+// headers, that makes it through the outbound to a UDP echo server at host
+// (host:port) and back, binary-searching sizes from 64 to 9000 within
+// timeoutMS. It helps tune TUN MTU for hysteria- or WireGuard-style nodes.
+// Returns {"maxPayload","probes","complete"}, {"error":"unsupported"} for
+// outbounds without UDP, or an error when not even the smallest probe
+// returned.
+//
+//export LibboxProbeMTU
+func LibboxProbeMTU(outboundJSON *C.char, host *C.char, timeoutMS C.longlong) *C.char {
+	configStr := C.GoString(outboundJSON)
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	destination := metadata.ParseSocksaddr(C.GoString(host))
+	if !destination.IsValid() || destination.Port == 0 {
+		return jsonError("invalid destination, expected host:port")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-outbound", currentLogLevel)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	defer tempInstance.Close()
+
+	if !common.Contains(out.Network(), N.NetworkUDP) {
+		return jsonError("unsupported")
+	}
+	conn, err := out.ListenPacket(ctx, destination)
+	if err != nil {
+		return jsonError("listen packet error: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := probeMTU(ctx, conn, destination)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func probeMTU(ctx context.Context, conn net.PacketConn, destination metadata.Socksaddr) (*mtuResult, error) {
+	result := &mtuResult{}
+	buffer := make([]byte, mtuProbeMax+1)
+	passes := func(size int) (bool, error) {
+		for attempt := 0; attempt < mtuProbeAttempts && ctx.Err() == nil; attempt++ {
+			result.Probes++
+			payload := make([]byte, size)
+			binary.BigEndian.PutUint64(payload, uint64(result.Probes))
+			binary.BigEndian.PutUint64(payload[8:], uint64(time.Now().UnixNano()))
+			if _, err := conn.WriteTo(payload, destination); err != nil {
+				// too big for the outbound to send at all
+				return false, nil
+			}
+			deadline := time.Now().Add(mtuProbeWait)
+			if ctxDeadline, loaded := ctx.Deadline(); loaded && ctxDeadline.Before(deadline) {
+				deadline = ctxDeadline
+			}
+			for {
+				conn.SetReadDeadline(deadline)
+				n, _, err := conn.ReadFrom(buffer)
+				if err != nil {
+					break
+				}
+				if bytes.Equal(buffer[:n], payload) {
+					return true, nil
+				}
+				// a late echo of an earlier probe; keep waiting for ours
+			}
+		}
+		return false, ctx.Err()
+	}
+
+	passed, err := passes(mtuProbeMin)
+	if !passed {
+		if err != nil {
+			return nil, fmt.Errorf("no probe returned before the timeout: %v", err)
+		}
+		return nil, fmt.Errorf("no probe of %d bytes returned", mtuProbeMin)
+	}
+	result.MaxPayload = mtuProbeMin
+	low, high := mtuProbeMin+1, mtuProbeMax
+	for low <= high {
+		size := low + (high-low)/2
+		passed, err := passes(size)
+		if err != nil {
+			return result, nil
+		}
+		if passed {
+			result.MaxPayload = size
+			low = size + 1
+		} else {
+			high = size - 1
+		}
+	}
+	result.Complete = true
+	return result, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing/common"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// multiplexTypes are the outbound types with a "multiplex" option.
+var multiplexTypes = []string{
+	constant.TypeShadowsocks,
+	constant.TypeTrojan,
+	constant.TypeVMess,
+	constant.TypeVLESS,
+}
+
+type muxRun struct {
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+type muxComparison struct {
+	On  muxRun `json:"on"`
+	Off muxRun `json:"off"`
+	// Faster is "on", "off", or empty when neither run succeeded or they
+	// tied.
+	Faster string `json:"faster"`
+}
+
+// LibboxTestMuxComparison runs the latency test of LibboxTestOutbound twice,
+// with the outbound's multiplex forced on and then off, keeping its other
+// multiplex settings. Each run gets timeoutMS and its own instance, so the
+// second doesn't reuse the first's connections. Returns
+// {"on":{"latencyMs","error"},"off":{...},"faster":"on"|"off"|""}.
+//
+//export LibboxTestMuxComparison
+func LibboxTestMuxComparison(outboundJSON *C.char, targetURL *C.char, timeoutMS C.longlong) *C.char {
+	configStr := C.GoString(outboundJSON)
+	targets := parseTargetURLs(C.GoString(targetURL))
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+
+	muxOn, err := setMultiplex(configStr, true)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	muxOff, err := setMultiplex(configStr, false)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+
+	var result muxComparison
+	result.On = runMuxTest(muxOn, targets, timeout)
+	result.Off = runMuxTest(muxOff, targets, timeout)
+	switch {
+	case result.On.Error != "" && result.Off.Error != "":
+	case result.Off.Error != "" || result.On.Error == "" && result.On.LatencyMs < result.Off.LatencyMs:
+		result.Faster = "on"
+	case result.On.Error != "" || result.Off.LatencyMs < result.On.LatencyMs:
+		result.Faster = "off"
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// setMultiplex returns configStr with multiplex.enabled of the outbound (the
+// entry of a chain) set to enabled.
+func setMultiplex(configStr string, enabled bool) (string, error) {
+	var (
+		chain  []map[string]any
+		single map[string]any
+		entry  map[string]any
+	)
+	isChain := strings.HasPrefix(strings.TrimSpace(configStr), "[")
+	if isChain {
+		if err := sjson.Unmarshal([]byte(configStr), &chain); err != nil {
+			return "", fmt.Errorf("decode config error: %v", err)
+		}
+		if len(chain) == 0 {
+			return "", errors.New("decode config error: empty outbound chain")
+		}
+		entry = chain[len(chain)-1]
+	} else {
+		if err := sjson.Unmarshal([]byte(configStr), &single); err != nil {
+			return "", fmt.Errorf("decode config error: %v", err)
+		}
+		entry = single
+	}
+	outboundType, _ := entry["type"].(string)
+	if !common.Contains(multiplexTypes, outboundType) {
+		return "", fmt.Errorf("outbound type %s does not support multiplex", outboundType)
+	}
+	multiplex := make(map[string]any)
+	if current, loaded := entry["multiplex"].(map[string]any); loaded {
+		for key, value := range current {
+			multiplex[key] = value
+		}
+	}
+	multiplex["enabled"] = enabled
+	entry["multiplex"] = multiplex
+
+	var (
+		content []byte
+		err     error
+	)
+	if isChain {
+		content, err = sjson.Marshal(chain)
+	} else {
+		content, err = sjson.Marshal(single)
+	}
+	if err != nil {
+		return "", fmt.Errorf("encode config error: %v", err)
+	}
+	return string(content), nil
+}
+
+func runMuxTest(configStr string, targets []string, timeout time.Duration) muxRun {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-outbound", currentLogLevel)
+	if err != nil {
+		return muxRun{Error: err.Error()}
+	}
+	defer tempInstance.Close()
+
+	client := outboundHTTPClient(out, timeout)
+	timing, _, err := probeTargets(ctx, client, targets, false)
+	if err != nil {
+		return muxRun{Error: err.Error()}
+	}
+	return muxRun{LatencyMs: timing.Headers.Milliseconds()}
+}
@@ -0,0 +1,166 @@
+package main
+
+import "C"
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/control"
+	sjson "github.com/sagernet/sing/common/json"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/service"
+)
+
+// LibboxNotifyNetworkChange tells the running instance that the network
+// changed, for hosts whose platform reports it before sing-box's own monitor
+// does. Interfaces are re-read and open connections are closed, since
+// sing-box doesn't record which interface a connection went out on; clients
+// reconnect over the current default interface.
+//
+//export LibboxNotifyNetworkChange
+func LibboxNotifyNetworkChange() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		return C.CString("service not running")
+	}
+	networkManager := service.FromContext[adapter.NetworkManager](instanceCtx)
+	if networkManager == nil {
+		return C.CString("network manager not available")
+	}
+	if err := networkManager.UpdateInterfaces(); err != nil {
+		return C.CString(err.Error())
+	}
+	networkManager.ResetNetwork()
+	return nil
+}
+
+// watchDefaultInterface closes the connections tracked for the instance that
+// were routed over the default interface of networkManager's monitor once
+// another one takes its place, so clients reconnect over the new one instead
+// of stalling on the old, until ctx is done. On desktop the monitor is
+// sing-box's own, which the network manager runs without a platform
+// interface; LibboxStartMobile's platform provides an equivalent one. sing-box
+// resets the network on the same event, but skips it when the platform hasn't
+// listed the new interface yet.
+func watchDefaultInterface(ctx context.Context, networkManager adapter.NetworkManager, tracker *connectionTracker) {
+	monitor := networkManager.InterfaceMonitor()
+	if monitor == nil {
+		return
+	}
+	var (
+		access   sync.Mutex
+		previous = monitor.DefaultInterface()
+	)
+	if previous != nil {
+		tracker.defaultInterface.Store(&previous.Name)
+	}
+	element := monitor.RegisterCallback(func(defaultInterface *control.Interface, flags int) {
+		access.Lock()
+		replaced := previous
+		changed := !sameInterface(previous, defaultInterface)
+		previous = defaultInterface
+		if defaultInterface != nil {
+			tracker.defaultInterface.Store(&defaultInterface.Name)
+		} else {
+			tracker.defaultInterface.Store(nil)
+		}
+		access.Unlock()
+		if changed && replaced != nil {
+			tracker.closeInterface(replaced.Name)
+		}
+	})
+	context.AfterFunc(ctx, func() {
+		monitor.UnregisterCallback(element)
+	})
+}
+
+func sameInterface(a *control.Interface, b *control.Interface) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Index == b.Index && a.Name == b.Name && slices.Equal(a.Addresses, b.Addresses)
+}
+
+type defaultInterfaceInfo struct {
+	Name      string   `json:"name"`
+	Index     int      `json:"index"`
+	Addresses []string `json:"addresses"`
+	// Source is "monitor" when sing-box is tracking the default interface,
+	// or "lookup" when it was derived from the system's route to the
+	// internet.
+	Source string `json:"source"`
+}
+
+// routeProbeAddresses are dialed over UDP, which sends nothing, to learn the
+// local address the system routes internet traffic from.
+var routeProbeAddresses = []string{"1.1.1.1:53", "[2606:4700:4700::1111]:53"}
+
+// LibboxGetDefaultInterface returns the interface the running instance sends
+// traffic out of as {"name","index","addresses","source"}, addresses being
+// in CIDR notation. The answer comes from sing-box's interface monitor when
+// it runs one, otherwise from a fresh lookup of the system's default route.
+//
+//export LibboxGetDefaultInterface
+func LibboxGetDefaultInterface() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		return jsonError("service not running")
+	}
+	var (
+		defaultInterface *control.Interface
+		source           = "monitor"
+	)
+	networkManager := service.FromContext[adapter.NetworkManager](instanceCtx)
+	if networkManager != nil && networkManager.InterfaceMonitor() != nil {
+		defaultInterface = networkManager.InterfaceMonitor().DefaultInterface()
+	}
+	if defaultInterface == nil {
+		var err error
+		defaultInterface, err = lookupDefaultInterface()
+		if err != nil {
+			return jsonError("%v", err)
+		}
+		source = "lookup"
+	}
+	result := defaultInterfaceInfo{
+		Name:      defaultInterface.Name,
+		Index:     defaultInterface.Index,
+		Addresses: common.Map(defaultInterface.Addresses, netip.Prefix.String),
+		Source:    source,
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func lookupDefaultInterface() (*control.Interface, error) {
+	finder := control.NewDefaultInterfaceFinder()
+	if err := finder.Update(); err != nil {
+		return nil, err
+	}
+	for _, address := range routeProbeAddresses {
+		conn, err := net.Dial(N.NetworkUDP, address)
+		if err != nil {
+			continue
+		}
+		localAddr := M.SocksaddrFromNet(conn.LocalAddr()).Addr
+		conn.Close()
+		if defaultInterface, err := finder.ByAddr(localAddr); err == nil {
+			return defaultInterface, nil
+		}
+	}
+	return nil, errors.New("no default interface")
+}
@@ -0,0 +1,51 @@
+package main
+
+// #include "callback.h"
+import "C"
+import (
+	"github.com/sagernet/sing-box/adapter"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+var notificationSink = newCallbackSink(16)
+
+type notificationPayload struct {
+	Identifier string `json:"identifier"`
+	TypeName   string `json:"typeName"`
+	TypeID     int32  `json:"typeId"`
+	Title      string `json:"title"`
+	Subtitle   string `json:"subtitle"`
+	Body       string `json:"body"`
+	OpenURL    string `json:"openUrl"`
+}
+
+// LibboxSetNotificationCallback registers the host function that receives
+// sing-box notifications as JSON. sing-box only sends them through a platform
+// interface, which only LibboxStartMobile installs, so instances started with
+// LibboxStart and its other desktop variants never deliver any. Passing NULL
+// unregisters it.
+//
+//export LibboxSetNotificationCallback
+func LibboxSetNotificationCallback(callback C.libbox_callback_t) {
+	notificationSink.set(callback)
+}
+
+func postNotification(notification *adapter.Notification) error {
+	if notification == nil || !notificationSink.registered() {
+		return nil
+	}
+	payload, err := sjson.Marshal(notificationPayload{
+		Identifier: notification.Identifier,
+		TypeName:   notification.TypeName,
+		TypeID:     notification.TypeID,
+		Title:      notification.Title,
+		Subtitle:   notification.Subtitle,
+		Body:       notification.Body,
+		OpenURL:    notification.OpenURL,
+	})
+	if err != nil {
+		return err
+	}
+	notificationSink.post(string(payload))
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import "C"
+import (
+	"context"
+	"reflect"
+
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+type outboundInfo struct {
+	Type       string   `json:"type"`
+	Tag        string   `json:"tag,omitempty"`
+	Server     string   `json:"server,omitempty"`
+	ServerPort uint16   `json:"serverPort,omitempty"`
+	TLS        bool     `json:"tls"`
+	ServerName string   `json:"serverName,omitempty"`
+	ALPN       []string `json:"alpn,omitempty"`
+	Insecure   bool     `json:"insecure,omitempty"`
+	UTLS       string   `json:"utls,omitempty"`
+	Reality    bool     `json:"reality"`
+	Transport  string   `json:"transport,omitempty"`
+}
+
+// LibboxOutboundInfo describes outboundJSON for a node card without exposing
+// its raw options: {"type","tag","server","serverPort","tls","serverName",
+// "alpn","insecure","utls","reality","transport"}. serverName is the SNI
+// sent, which is the server when the TLS options don't set one; transport is
+// the V2Ray transport type (ws, grpc, http, ...). Fields that don't apply to
+// the outbound type are left out. Returns {"error"} for an outbound sing-box
+// rejects.
+//
+//export LibboxOutboundInfo
+func LibboxOutboundInfo(outboundJSON *C.char) *C.char {
+	ctx := include.Context(context.Background())
+	var options option.Outbound
+	if err := sjson.UnmarshalContext(ctx, []byte(C.GoString(outboundJSON)), &options); err != nil {
+		return jsonError("decode outbound error: %v", err)
+	}
+
+	info := outboundInfo{
+		Type: options.Type,
+		Tag:  options.Tag,
+	}
+	if server, isServer := options.Options.(option.ServerOptionsWrapper); isServer {
+		serverOptions := server.TakeServerOptions()
+		info.Server = serverOptions.Server
+		info.ServerPort = serverOptions.ServerPort
+	}
+	if tlsWrapper, isTLS := options.Options.(option.OutboundTLSOptionsWrapper); isTLS {
+		if tlsOptions := tlsWrapper.TakeOutboundTLSOptions(); tlsOptions != nil && tlsOptions.Enabled {
+			info.TLS = true
+			if !tlsOptions.DisableSNI {
+				info.ServerName = tlsOptions.ServerName
+				if info.ServerName == "" {
+					info.ServerName = info.Server
+				}
+			}
+			info.ALPN = tlsOptions.ALPN
+			info.Insecure = tlsOptions.Insecure
+			if tlsOptions.UTLS != nil && tlsOptions.UTLS.Enabled {
+				info.UTLS = tlsOptions.UTLS.Fingerprint
+				if info.UTLS == "" {
+					info.UTLS = "chrome"
+				}
+			}
+			info.Reality = tlsOptions.Reality != nil && tlsOptions.Reality.Enabled
+		}
+	}
+	info.Transport = outboundTransport(options.Options)
+
+	jsonBytes, err := sjson.Marshal(info)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// outboundTransport returns the V2Ray transport type of outbound options that
+// have one. The options don't share an interface for it, so the Transport
+// field is looked up by name.
+func outboundTransport(options any) string {
+	value := reflect.ValueOf(options)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	field := value.Elem().FieldByName("Transport")
+	if !field.IsValid() || !field.CanInterface() {
+		return ""
+	}
+	transport, isTransport := field.Interface().(*option.V2RayTransportOptions)
+	if !isTransport || transport == nil {
+		return ""
+	}
+	return transport.Type
+}
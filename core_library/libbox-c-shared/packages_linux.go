@@ -0,0 +1,37 @@
+//go:build linux && !android
+
+package main
+
+import (
+	"os/user"
+	"strconv"
+
+	"github.com/sagernet/sing-box/adapter"
+)
+
+// platformPackageNames tells mobilePlatform to find connection owners
+// itself so that it can name them for package_name rules. That only serves
+// LibboxStartMobile: desktop starts install no platform interface, since one
+// would move auto_detect_interface onto the platform's interface list and
+// make the tun inbound ignore route_address_set. There sing-box's own
+// searcher answers process_name and process_path rules, which cover the
+// same executables, and the connection callback names the process it found.
+const platformPackageNames = true
+
+// ownerPackageNames names the owner of a connection for package_name rules.
+// Linux has no package names, so the owner's executable path and account
+// name stand in for one; rules can list either.
+func ownerPackageNames(owner *adapter.ConnectionOwner) []string {
+	var names []string
+	if owner.ProcessPath != "" {
+		names = append(names, owner.ProcessPath)
+	}
+	if owner.UserName != "" {
+		names = append(names, owner.UserName)
+	} else if owner.UserId >= 0 {
+		if account, err := user.LookupId(strconv.Itoa(int(owner.UserId))); err == nil {
+			names = append(names, account.Username)
+		}
+	}
+	return names
+}
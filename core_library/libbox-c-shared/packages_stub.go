@@ -0,0 +1,13 @@
+//go:build !linux || android
+
+package main
+
+import "github.com/sagernet/sing-box/adapter"
+
+// Android hosts answer package_name rules through sing-box's own package
+// manager; elsewhere there is nothing to stand in for a package name.
+const platformPackageNames = false
+
+func ownerPackageNames(owner *adapter.ConnectionOwner) []string {
+	return nil
+}
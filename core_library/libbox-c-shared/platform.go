@@ -21,8 +21,11 @@ func (p *CommandPlatformInterface) AutoDetectInterfaceControl(fd int32) error {
 	return nil
 }
 
+// OpenTun is never called on desktop: LibboxStart lets the tun inbound create
+// the device itself, and a failure for lack of privileges is reported as
+// TUN_PERMISSION.
 func (p *CommandPlatformInterface) OpenTun(options libbox.TunOptions) (int32, error) {
-	return -1, fmt.Errorf("not implemented")
+	return -1, fmt.Errorf("desktop tun devices are created by the tun inbound of LibboxStart, not through a platform interface")
 }
 
 func (p *CommandPlatformInterface) WriteLog(message string) {
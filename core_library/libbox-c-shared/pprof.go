@@ -0,0 +1,78 @@
+package main
+
+import "C"
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"sync"
+
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+const defaultPProfListen = "127.0.0.1:6060"
+
+var (
+	pprofAccess sync.Mutex
+	pprofServer *http.Server
+)
+
+// LibboxStartPProf serves net/http/pprof under /debug/pprof/ on listenAddr
+// (127.0.0.1:6060 when empty, port 0 picks a free one) to capture heap and
+// goroutine profiles of the library in the field. The profiles expose
+// process internals without authentication, so only loopback addresses are
+// accepted. Returns {"listen"} with the bound address.
+//
+//export LibboxStartPProf
+func LibboxStartPProf(listenAddr *C.char) *C.char {
+	pprofAccess.Lock()
+	defer pprofAccess.Unlock()
+
+	if pprofServer != nil {
+		return jsonError("pprof already running")
+	}
+	listen := strings.TrimSpace(C.GoString(listenAddr))
+	if listen == "" {
+		listen = defaultPProfListen
+	}
+	if !isLoopbackListen(listen) {
+		return jsonError("pprof only listens on loopback addresses: %s", listen)
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return jsonError("pprof listen error: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	pprofServer = &http.Server{Handler: mux}
+	go pprofServer.Serve(listener)
+
+	jsonBytes, err := sjson.Marshal(map[string]string{"listen": listener.Addr().String()})
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// LibboxStopPProf stops serving pprof.
+//
+//export LibboxStopPProf
+func LibboxStopPProf() *C.char {
+	pprofAccess.Lock()
+	defer pprofAccess.Unlock()
+
+	if pprofServer == nil {
+		return C.CString("pprof not running")
+	}
+	err := pprofServer.Close()
+	pprofServer = nil
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
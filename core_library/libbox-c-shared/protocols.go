@@ -0,0 +1,107 @@
+package main
+
+import "C"
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/include"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/service"
+)
+
+type supportedProtocols struct {
+	Inbounds  []string `json:"inbounds"`
+	Outbounds []string `json:"outbounds"`
+	Endpoints []string `json:"endpoints"`
+}
+
+// LibboxSupportedProtocols lists the inbound, outbound and endpoint types
+// this build can actually run, so hosts can hide what isn't compiled in.
+// Returns {"inbounds":[...],"outbounds":[...],"endpoints":[...]}, each
+// sorted.
+//
+//export LibboxSupportedProtocols
+func LibboxSupportedProtocols() *C.char {
+	jsonBytes, err := sjson.Marshal(availableProtocols())
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// LibboxHasProtocol returns 1 when protocolType is an inbound, outbound or
+// endpoint type this build can run, as listed by LibboxSupportedProtocols,
+// and 0 otherwise.
+//
+//export LibboxHasProtocol
+func LibboxHasProtocol(protocolType *C.char) C.int {
+	name := strings.ToLower(strings.TrimSpace(C.GoString(protocolType)))
+	protocols := availableProtocols()
+	if slices.Contains(protocols.Inbounds, name) || slices.Contains(protocols.Outbounds, name) || slices.Contains(protocols.Endpoints, name) {
+		return 1
+	}
+	return 0
+}
+
+// availableProtocols reads the types registered in the registries of
+// include.Context. Types left out by build tags are registered too, as stubs
+// that fail when created, and so are types sing-box removed; those are
+// filtered out by the build's tags.
+func availableProtocols() supportedProtocols {
+	ctx := include.Context(context.Background())
+	available := func(kind string, registry any) []string {
+		types := registeredTypes(registry)
+		types = slices.DeleteFunc(types, func(protocolType string) bool {
+			return stubProtocol(kind, protocolType)
+		})
+		slices.Sort(types)
+		return types
+	}
+	return supportedProtocols{
+		Inbounds:  available("inbound", service.FromContext[adapter.InboundRegistry](ctx)),
+		Outbounds: available("outbound", service.FromContext[adapter.OutboundRegistry](ctx)),
+		Endpoints: available("endpoint", service.FromContext[adapter.EndpointRegistry](ctx)),
+	}
+}
+
+// registeredTypes returns the keys of a sing-box registry's unexported
+// optionsType map.
+func registeredTypes(registry any) []string {
+	value := reflect.ValueOf(registry)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return []string{}
+	}
+	optionsType := value.Elem().FieldByName("optionsType")
+	if optionsType.Kind() != reflect.Map {
+		return []string{}
+	}
+	types := make([]string, 0, optionsType.Len())
+	for _, key := range optionsType.MapKeys() {
+		types = append(types, key.String())
+	}
+	return types
+}
+
+// stubProtocol reports whether include registered protocolType only to
+// explain that it is missing from this build or removed.
+func stubProtocol(kind string, protocolType string) bool {
+	switch protocolType {
+	case constant.TypeShadowsocksR:
+		return true
+	case constant.TypeHysteria, constant.TypeTUIC, constant.TypeHysteria2:
+		return !constant.WithQUIC
+	case constant.TypeWireGuard:
+		// the outbound was replaced by the endpoint
+		return kind != "endpoint" || !withWireGuard
+	case constant.TypeNaive:
+		return kind == "outbound" && !withNaiveOutbound
+	case constant.TypeTailscale:
+		return !withTailscale
+	}
+	return false
+}
@@ -0,0 +1,5 @@
+//go:build !with_naive_outbound
+
+package main
+
+const withNaiveOutbound = false
@@ -0,0 +1,5 @@
+//go:build with_tailscale
+
+package main
+
+const withTailscale = true
@@ -0,0 +1,5 @@
+//go:build with_wireguard
+
+package main
+
+const withWireGuard = true
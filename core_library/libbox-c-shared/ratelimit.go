@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// rateLimitBurstWindow is the traffic a token bucket lets through at once,
+// in time at its rate; short, so limited transfers stay smooth.
+const rateLimitBurstWindow = 100 * time.Millisecond
+
+// minRateLimitBurst keeps very low limits from crawling a few bytes a read.
+const minRateLimitBurst = 512
+
+// rateLimits are the "downloadLimitKbps" and "uploadLimitKbps" fields the
+// LibboxFetch functions take from their outbound JSON to throttle the
+// connections through the node, so QA can reproduce slow links. Zero or
+// less leaves a direction unlimited.
+type rateLimits struct {
+	DownloadKbps float64
+	UploadKbps   float64
+}
+
+// takeRateLimits removes the rate limit test fields from configStr.
+func takeRateLimits(configStr string) (string, rateLimits, error) {
+	var limits rateLimits
+	configStr, download, err := takeTestNumber(configStr, "downloadLimitKbps")
+	if err != nil {
+		return configStr, limits, err
+	}
+	configStr, upload, err := takeTestNumber(configStr, "uploadLimitKbps")
+	if err != nil {
+		return configStr, limits, err
+	}
+	limits.DownloadKbps = max(download, 0)
+	limits.UploadKbps = max(upload, 0)
+	return configStr, limits, nil
+}
+
+// dialer wraps out so that every connection it dials shares one bucket per
+// limited direction, making the limit apply to the fetch as a whole.
+func (l rateLimits) dialer(out N.Dialer) N.Dialer {
+	if l.DownloadKbps == 0 && l.UploadKbps == 0 {
+		return out
+	}
+	return &rateLimitedDialer{
+		Dialer:   out,
+		download: newTokenBucket(l.DownloadKbps),
+		upload:   newTokenBucket(l.UploadKbps),
+	}
+}
+
+type rateLimitedDialer struct {
+	N.Dialer
+	download *tokenBucket
+	upload   *tokenBucket
+}
+
+func (d *rateLimitedDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, destination)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimitedConn{Conn: conn, download: d.download, upload: d.upload}, nil
+}
+
+// rateLimitedConn reads and writes through token buckets; a nil bucket
+// leaves its direction alone.
+type rateLimitedConn struct {
+	net.Conn
+	download *tokenBucket
+	upload   *tokenBucket
+}
+
+func (c *rateLimitedConn) Read(p []byte) (int, error) {
+	if c.download == nil {
+		return c.Conn.Read(p)
+	}
+	n, err := c.Conn.Read(p[:min(len(p), c.download.burst)])
+	c.download.wait(n)
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(p []byte) (int, error) {
+	if c.upload == nil {
+		return c.Conn.Write(p)
+	}
+	var written int
+	for written < len(p) {
+		chunk := p[written:min(len(p), written+c.upload.burst)]
+		c.upload.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// tokenBucket holds up to burst bytes worth of tokens, refilled at rate
+// bytes a second.
+type tokenBucket struct {
+	access sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(kbps float64) *tokenBucket {
+	if kbps <= 0 {
+		return nil
+	}
+	rate := kbps * 1000 / 8
+	burst := max(int(rate*rateLimitBurstWindow.Seconds()), minRateLimitBurst)
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n bytes worth of tokens, sleeping for as long as the bucket
+// runs short. Callers keep n within burst, so a wait is bounded.
+func (b *tokenBucket) wait(n int) {
+	if n <= 0 {
+		return
+	}
+	b.access.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, float64(b.burst))
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.access.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
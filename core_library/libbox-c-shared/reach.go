@@ -0,0 +1,80 @@
+package main
+
+import "C"
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing/common"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+type reachResult struct {
+	Network   string `json:"network"`
+	Address   string `json:"address"`
+	Reachable bool   `json:"reachable"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// LibboxTestReach dials host:port through the outbound and reports how long
+// the connection took to establish. network selects "tcp" (the default when
+// empty) or "udp"; UDP only proves that the outbound accepted the session,
+// since there is no handshake with the target.
+//
+//export LibboxTestReach
+func LibboxTestReach(outboundJSON *C.char, host *C.char, port C.int, timeoutMS C.longlong, network *C.char) *C.char {
+	configStr := C.GoString(outboundJSON)
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+
+	networkName := strings.ToLower(strings.TrimSpace(C.GoString(network)))
+	if networkName == "" {
+		networkName = N.NetworkTCP
+	}
+	if networkName != N.NetworkTCP && networkName != N.NetworkUDP {
+		return jsonError("unsupported network: %s", networkName)
+	}
+	destination := metadata.ParseSocksaddrHostPort(C.GoString(host), uint16(port))
+	if !destination.IsValid() {
+		return jsonError("invalid destination")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-outbound", currentLogLevel)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	defer tempInstance.Close()
+
+	result := reachResult{
+		Network: networkName,
+		Address: destination.String(),
+	}
+	if !common.Contains(out.Network(), networkName) {
+		result.Error = "outbound does not support " + networkName
+	} else {
+		start := time.Now()
+		conn, err := out.DialContext(ctx, networkName, destination)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.LatencyMs = time.Since(start).Milliseconds()
+			result.Reachable = true
+			conn.Close()
+		}
+	}
+
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
@@ -0,0 +1,380 @@
+package main
+
+import "C"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing-box/protocol/group"
+	"github.com/sagernet/sing/common"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/service"
+)
+
+type reloadResult struct {
+	Added   []string `json:"added"`
+	Updated []string `json:"updated"`
+	Removed []string `json:"removed"`
+}
+
+// LibboxReloadOutbounds replaces the outbounds of the running instance with
+// outboundsJSON, an array of outbounds or an object with an "outbounds"
+// array, leaving inbounds, DNS and routing alone. Outbounds whose options are
+// unchanged are kept, along with their connections; changed ones and the
+// groups and chains built on them are recreated, selector groups keeping
+// their selection. The reload is rejected when a rule, the final outbound, a
+// detour or a group would reference a tag that is no longer there, and is
+// undone when an outbound fails to start, so the instance keeps either the
+// old or the new outbounds. Returns {"added","updated","removed"} with the
+// tags.
+//
+//export LibboxReloadOutbounds
+func LibboxReloadOutbounds(outboundsJSON *C.char) *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		return jsonError("service not running")
+	}
+	outbounds, err := decodeReloadOutbounds(instanceCtx, C.GoString(outboundsJSON))
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	result, err := reloadOutbounds(outbounds)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func decodeReloadOutbounds(ctx context.Context, content string) ([]option.Outbound, error) {
+	var outbounds []option.Outbound
+	if strings.HasPrefix(strings.TrimSpace(content), "[") {
+		if err := sjson.UnmarshalContext(ctx, []byte(content), &outbounds); err != nil {
+			return nil, fmt.Errorf("decode config error: %v", err)
+		}
+	} else {
+		var wrapper struct {
+			Outbounds []option.Outbound `json:"outbounds"`
+		}
+		if err := sjson.UnmarshalContext(ctx, []byte(content), &wrapper); err != nil {
+			return nil, fmt.Errorf("decode config error: %v", err)
+		}
+		outbounds = wrapper.Outbounds
+	}
+	tags := make(map[string]bool)
+	for _, outbound := range outbounds {
+		if outbound.Tag == "" {
+			return nil, errors.New("every outbound needs a tag")
+		}
+		if tags[outbound.Tag] {
+			return nil, newCodedError(errorCodeDuplicateTag, "duplicate outbound tag: %s", outbound.Tag)
+		}
+		tags[outbound.Tag] = true
+	}
+	return outbounds, nil
+}
+
+// reloadOutbounds must be called with mu held and the instance running.
+func reloadOutbounds(outbounds []option.Outbound) (*reloadResult, error) {
+	ctx := instanceCtx
+	manager := instance.Outbound()
+
+	available := make(map[string]bool)
+	for _, outbound := range outbounds {
+		available[outbound.Tag] = true
+	}
+	if endpointManager := service.FromContext[adapter.EndpointManager](ctx); endpointManager != nil {
+		for _, endpoint := range endpointManager.Endpoints() {
+			available[endpoint.Tag()] = true
+		}
+	}
+	for _, outbound := range outbounds {
+		for _, dependency := range outboundOptionDependencies(outbound) {
+			if !available[dependency] {
+				return nil, fmt.Errorf("outbound %s references missing outbound %s", outbound.Tag, dependency)
+			}
+		}
+	}
+	references, err := configReferences(ctx, instanceOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range references {
+		if !available[tag] {
+			return nil, fmt.Errorf("config still references outbound %s, which the reload removes", tag)
+		}
+	}
+
+	previous := make(map[string]option.Outbound)
+	for _, outbound := range instanceOptions.Outbounds {
+		previous[outbound.Tag] = outbound
+	}
+	result := &reloadResult{Added: []string{}, Updated: []string{}, Removed: []string{}}
+	affected := make(map[string]bool)
+	for tag := range previous {
+		if !available[tag] {
+			affected[tag] = true
+			result.Removed = append(result.Removed, tag)
+		}
+	}
+	for _, outbound := range outbounds {
+		old, loaded := previous[outbound.Tag]
+		if !loaded {
+			result.Added = append(result.Added, outbound.Tag)
+		} else if !sameOutboundOptions(ctx, old, outbound) {
+			affected[outbound.Tag] = true
+		}
+	}
+
+	// groups and chains hold on to the outbounds they were started with, so
+	// whatever depends on an affected outbound is recreated as well
+	dependents := make(map[string][]string)
+	for _, out := range manager.Outbounds() {
+		if _, loaded := previous[out.Tag()]; !loaded {
+			continue
+		}
+		for _, dependency := range out.Dependencies() {
+			dependents[dependency] = append(dependents[dependency], out.Tag())
+		}
+	}
+	pending := make([]string, 0, len(affected))
+	for tag := range affected {
+		pending = append(pending, tag)
+	}
+	for len(pending) > 0 {
+		tag := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dependent := range dependents[tag] {
+			if !affected[dependent] {
+				affected[dependent] = true
+				pending = append(pending, dependent)
+			}
+		}
+	}
+	create := make(map[string]option.Outbound)
+	for _, outbound := range outbounds {
+		_, existed := previous[outbound.Tag]
+		if affected[outbound.Tag] {
+			result.Updated = append(result.Updated, outbound.Tag)
+		}
+		if !existed || affected[outbound.Tag] {
+			create[outbound.Tag] = outbound
+		}
+	}
+
+	// build what is to be created up front, so options sing-box rejects fail
+	// the reload before anything was touched
+	if registry := service.FromContext[adapter.OutboundRegistry](ctx); registry != nil {
+		for _, outbound := range outbounds {
+			if _, loaded := create[outbound.Tag]; !loaded {
+				continue
+			}
+			built, err := registry.CreateOutbound(ctx, instance.Router(), reloadLogger(outbound), outbound.Tag, outbound.Type, outbound.Options)
+			if err != nil {
+				return nil, fmt.Errorf("create outbound %s error: %v", outbound.Tag, err)
+			}
+			common.Close(built)
+		}
+	}
+	selections := make(map[string]string)
+	for tag := range affected {
+		if out, loaded := manager.Outbound(tag); loaded {
+			if selector, isSelector := out.(*group.Selector); isSelector {
+				selections[tag] = selector.Now()
+			}
+		}
+	}
+
+	// from here on a failure puts the outbounds back the way they were
+	var removed, created []string
+	rollback := func(cause error) (*reloadResult, error) {
+		errs := []error{cause}
+		for _, tag := range slices.Backward(created) {
+			if err := manager.Remove(tag); err != nil {
+				errs = append(errs, fmt.Errorf("roll back outbound %s error: %v", tag, err))
+			}
+		}
+		for _, tag := range slices.Backward(removed) {
+			outbound := previous[tag]
+			if err := manager.Create(ctx, instance.Router(), reloadLogger(outbound), outbound.Tag, outbound.Type, outbound.Options); err != nil {
+				errs = append(errs, fmt.Errorf("restore outbound %s error: %v", tag, err))
+			}
+		}
+		restoreSelections(manager, selections)
+		return nil, errors.Join(errs...)
+	}
+
+	// remove dependents before what they depend on, so the manager never
+	// sees a dangling dependency
+	for len(affected) > 0 {
+		var removable []string
+		for tag := range affected {
+			if !slices.ContainsFunc(dependents[tag], func(dependent string) bool { return affected[dependent] }) {
+				removable = append(removable, tag)
+			}
+		}
+		if len(removable) == 0 {
+			return rollback(errors.New("outbounds depend on each other in a cycle"))
+		}
+		for _, tag := range removable {
+			delete(affected, tag)
+			if _, loaded := manager.Outbound(tag); !loaded {
+				continue
+			}
+			if err := manager.Remove(tag); err != nil {
+				return rollback(fmt.Errorf("remove outbound %s error: %v", tag, err))
+			}
+			removed = append(removed, tag)
+		}
+	}
+
+	// create dependencies before the groups and chains using them
+	for len(create) > 0 {
+		progressed := false
+		for _, outbound := range outbounds {
+			if _, loaded := create[outbound.Tag]; !loaded {
+				continue
+			}
+			if slices.ContainsFunc(outboundOptionDependencies(outbound), func(dependency string) bool {
+				_, waiting := create[dependency]
+				return waiting
+			}) {
+				continue
+			}
+			if err := manager.Create(ctx, instance.Router(), reloadLogger(outbound), outbound.Tag, outbound.Type, outbound.Options); err != nil {
+				return rollback(fmt.Errorf("create outbound %s error: %v", outbound.Tag, err))
+			}
+			created = append(created, outbound.Tag)
+			delete(create, outbound.Tag)
+			progressed = true
+		}
+		if !progressed {
+			return rollback(errors.New("outbounds depend on each other in a cycle"))
+		}
+	}
+	restoreSelections(manager, selections)
+
+	instanceOptions.Outbounds = outbounds
+	slices.Sort(result.Added)
+	slices.Sort(result.Updated)
+	slices.Sort(result.Removed)
+	return result, nil
+}
+
+func reloadLogger(outbound option.Outbound) log.ContextLogger {
+	return instance.LogFactory().NewLogger(fmt.Sprintf("outbound/%s[%s]", outbound.Type, outbound.Tag))
+}
+
+// restoreSelections selects again what the selector groups recreated by a
+// reload had selected, where it is still one of their members.
+func restoreSelections(manager adapter.OutboundManager, selections map[string]string) {
+	for tag, selected := range selections {
+		if out, loaded := manager.Outbound(tag); loaded {
+			if selector, isSelector := out.(*group.Selector); isSelector {
+				selector.SelectOutbound(selected)
+			}
+		}
+	}
+}
+
+// outboundOptionDependencies returns the tags an outbound's options refer to:
+// its detour and, for groups, its members.
+func outboundOptionDependencies(outbound option.Outbound) []string {
+	var dependencies []string
+	if wrapper, isDialer := outbound.Options.(option.DialerOptionsWrapper); isDialer {
+		if detour := wrapper.TakeDialerOptions().Detour; detour != "" {
+			dependencies = append(dependencies, detour)
+		}
+	}
+	switch options := outbound.Options.(type) {
+	case *option.SelectorOutboundOptions:
+		dependencies = append(dependencies, options.Outbounds...)
+	case *option.URLTestOutboundOptions:
+		dependencies = append(dependencies, options.Outbounds...)
+	}
+	return dependencies
+}
+
+func sameOutboundOptions(ctx context.Context, a option.Outbound, b option.Outbound) bool {
+	aContent, err := sjson.MarshalContext(ctx, &a)
+	if err != nil {
+		return false
+	}
+	bContent, err := sjson.MarshalContext(ctx, &b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aContent, bContent)
+}
+
+// outboundReferenceKeys are the fields outside the outbounds section that
+// name an outbound: detours of endpoints, DNS servers and rule-sets, and the
+// outbound of route rules and DNS rules.
+var outboundReferenceKeys = map[string]bool{
+	"detour":          true,
+	"download_detour": true,
+	"outbound":        true,
+}
+
+// configReferences lists the outbound tags options refers to outside its
+// outbounds.
+func configReferences(ctx context.Context, options option.Options) ([]string, error) {
+	options.Outbounds = nil
+	content, err := sjson.MarshalContext(ctx, &options)
+	if err != nil {
+		return nil, fmt.Errorf("encode config error: %v", err)
+	}
+	var config map[string]any
+	if err := sjson.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("decode config error: %v", err)
+	}
+	var references []string
+	if route, _ := config["route"].(map[string]any); route != nil {
+		if final, _ := route["final"].(string); final != "" {
+			references = append(references, final)
+		}
+	}
+	var walk func(value any)
+	walk = func(value any) {
+		switch node := value.(type) {
+		case map[string]any:
+			for key, child := range node {
+				if outboundReferenceKeys[key] {
+					switch tag := child.(type) {
+					case string:
+						if tag != "" {
+							references = append(references, tag)
+						}
+						continue
+					case []any:
+						for _, item := range tag {
+							if item, isString := item.(string); isString && item != "" {
+								references = append(references, item)
+							}
+						}
+						continue
+					}
+				}
+				walk(child)
+			}
+		case []any:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+	walk(config)
+	return references, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import "C"
+import (
+	"strings"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
+	R "github.com/sagernet/sing-box/route/rule"
+	sjson "github.com/sagernet/sing/common/json"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+type routeResult struct {
+	Destination string `json:"destination"`
+	Network     string `json:"network"`
+	RuleIndex   int    `json:"ruleIndex"`
+	Rule        string `json:"rule,omitempty"`
+	Action      string `json:"action"`
+	Outbound    string `json:"outbound,omitempty"`
+	Exit        string `json:"exit,omitempty"`
+	// Skipped are the indexes of matching sniff and resolve rules, which
+	// would inspect traffic or query DNS and are not run.
+	Skipped []int `json:"skipped,omitempty"`
+}
+
+// LibboxRouteDestination reports which rule and outbound the running
+// instance would pick for a connection to host:port over network ("tcp" when
+// empty, or "udp"), without dialing anything. ruleIndex is -1 when no rule
+// matches and the default outbound is used; exit follows groups to the
+// outbound they currently use.
+//
+//export LibboxRouteDestination
+func LibboxRouteDestination(host *C.char, port C.int, network *C.char) *C.char {
+	networkName := strings.ToLower(strings.TrimSpace(C.GoString(network)))
+	if networkName == "" {
+		networkName = N.NetworkTCP
+	}
+	if networkName != N.NetworkTCP && networkName != N.NetworkUDP {
+		return jsonError("unsupported network: %s", networkName)
+	}
+	destination := M.ParseSocksaddrHostPort(C.GoString(host), uint16(port))
+	if !destination.IsValid() {
+		return jsonError("invalid destination")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		return jsonError("service not running")
+	}
+	result := dryRoute(networkName, destination, nil)
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// dryRoute walks the route rules of the running instance the way the router
+// does for a new connection, calling evaluated (when not nil) for every rule
+// it tries. Rewrites by route options are applied; sniffing, resolving and
+// process lookup are not. It must be called with mu held.
+func dryRoute(network string, destination M.Socksaddr, evaluated func(index int, rule adapter.Rule, metadata *adapter.InboundContext, matched bool)) routeResult {
+	metadata := adapter.InboundContext{
+		Network:     network,
+		Destination: destination,
+	}
+	if destination.IsFqdn() {
+		metadata.Domain = destination.Fqdn
+	} else if destination.IsIPv4() {
+		metadata.IPVersion = 4
+	} else if destination.IsIPv6() {
+		metadata.IPVersion = 6
+	}
+
+	result := routeResult{
+		Destination: destination.String(),
+		Network:     network,
+		RuleIndex:   -1,
+		Action:      constant.RuleActionTypeRoute,
+	}
+	var outboundTag string
+	for index, rule := range instance.Router().Rules() {
+		metadata.ResetRuleCache()
+		matched := rule.Match(&metadata)
+		if evaluated != nil {
+			evaluated(index, rule, &metadata, matched)
+		}
+		if !matched {
+			continue
+		}
+		switch action := rule.Action().(type) {
+		case *R.RuleActionRoute:
+			applyRouteOptions(&metadata, &action.RuleActionRouteOptions)
+			outboundTag = action.Outbound
+		case *R.RuleActionRouteOptions:
+			applyRouteOptions(&metadata, action)
+		case *R.RuleActionBypass:
+			if action.Outbound == "" {
+				// bypass without an outbound only applies to TUN pre-match
+				continue
+			}
+			outboundTag = action.Outbound
+		case *R.RuleActionSniff, *R.RuleActionResolve:
+			result.Skipped = append(result.Skipped, index)
+		}
+		if !adapter.IsFinalAction(rule.Action()) || rule.Action().Type() == constant.RuleActionTypeRouteOptions {
+			continue
+		}
+		result.RuleIndex = index
+		result.Rule = rule.String()
+		result.Action = rule.Action().Type()
+		break
+	}
+
+	if result.RuleIndex == -1 {
+		if defaultOutbound := instance.Outbound().Default(); defaultOutbound != nil {
+			outboundTag = defaultOutbound.Tag()
+		}
+	}
+	if outboundTag != "" {
+		result.Outbound = outboundTag
+		if out, loaded := instance.Outbound().Outbound(outboundTag); loaded {
+			result.Exit = outboundExit(instance.Outbound(), out)
+		}
+	}
+	return result
+}
+
+// applyRouteOptions applies the destination rewrites of a route action, which
+// later rules match against.
+func applyRouteOptions(metadata *adapter.InboundContext, options *R.RuleActionRouteOptions) {
+	if options.OverrideAddress.IsValid() {
+		metadata.Destination = M.Socksaddr{
+			Addr: options.OverrideAddress.Addr,
+			Port: metadata.Destination.Port,
+			Fqdn: options.OverrideAddress.Fqdn,
+		}
+	}
+	if options.OverridePort > 0 {
+		metadata.Destination.Port = options.OverridePort
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import "C"
+import (
+	"reflect"
+
+	"github.com/sagernet/sing-box/adapter"
+	R "github.com/sagernet/sing-box/route/rule"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+type rulesResult struct {
+	Rules []routeRule `json:"rules"`
+	Final string      `json:"final,omitempty"`
+}
+
+type routeRule struct {
+	Index      int              `json:"index"`
+	Type       string           `json:"type"`
+	Rule       string           `json:"rule"`
+	Invert     bool             `json:"invert,omitempty"`
+	Conditions []matchCondition `json:"conditions,omitempty"`
+	Action     string           `json:"action"`
+	Outbound   string           `json:"outbound,omitempty"`
+	// Description is the action as sing-box prints it, with its options,
+	// e.g. "route(proxy,override-port=443)".
+	Description string `json:"description"`
+}
+
+// LibboxGetRules lists the route rules of the running instance in the order
+// they are evaluated, as sing-box built them from the config after defaults
+// and migrations. Conditions of default rules are listed one by one; logical
+// rules are only described by "rule". final is the outbound used when no
+// rule matches. {"rules":[]} is returned when the service is not running.
+//
+//export LibboxGetRules
+func LibboxGetRules() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	result := rulesResult{Rules: []routeRule{}}
+	if instance != nil {
+		for index, rule := range instance.Router().Rules() {
+			result.Rules = append(result.Rules, newRouteRule(index, rule))
+		}
+		if defaultOutbound := instance.Outbound().Default(); defaultOutbound != nil {
+			result.Final = defaultOutbound.Tag()
+		}
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func newRouteRule(index int, rule adapter.Rule) routeRule {
+	described := routeRule{
+		Index:       index,
+		Type:        rule.Type(),
+		Rule:        rule.String(),
+		Action:      rule.Action().Type(),
+		Description: rule.Action().String(),
+	}
+	switch action := rule.Action().(type) {
+	case *R.RuleActionRoute:
+		described.Outbound = action.Outbound
+	case *R.RuleActionBypass:
+		described.Outbound = action.Outbound
+	}
+	value := reflect.ValueOf(rule)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return described
+	}
+	value = value.Elem()
+	if invert := value.FieldByName("invert"); invert.IsValid() && invert.Kind() == reflect.Bool {
+		described.Invert = invert.Bool()
+	}
+	if _, isDefault := rule.(*R.DefaultRule); isDefault {
+		for _, item := range readRuleItems(value.FieldByName("allItems")) {
+			described.Conditions = append(described.Conditions, newMatchCondition(item))
+		}
+	}
+	return described
+}
@@ -0,0 +1,105 @@
+package main
+
+import "C"
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	_ "unsafe" // for go:linkname
+
+	"github.com/sagernet/sing-box/common/srs"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing-box/route/rule"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// sing-box only reloads rule-sets from their own file watcher or HTTP
+// updater, so the swap is reached through the unexported loaders. The build
+// already runs with -checklinkname=0.
+
+//go:linkname localRuleSetReloadRules github.com/sagernet/sing-box/route/rule.(*LocalRuleSet).reloadRules
+func localRuleSetReloadRules(s *rule.LocalRuleSet, headlessRules []option.HeadlessRule) error
+
+//go:linkname remoteRuleSetLoadBytes github.com/sagernet/sing-box/route/rule.(*RemoteRuleSet).loadBytes
+func remoteRuleSetLoadBytes(s *rule.RemoteRuleSet, content []byte) error
+
+// LibboxUpdateRuleSet replaces the rules of the named rule-set in the
+// running instance. content is either a source (JSON) rule-set or a binary
+// .srs file encoded as base64. Only connections routed after the update see
+// the new rules; established connections keep their route.
+//
+//export LibboxUpdateRuleSet
+func LibboxUpdateRuleSet(tag *C.char, content *C.char) *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		return C.CString("service not running")
+	}
+
+	ruleSetTag := C.GoString(tag)
+	ruleSet, ok := instance.Router().RuleSet(ruleSetTag)
+	if !ok {
+		return C.CString(fmt.Sprintf("rule-set not found: %s", ruleSetTag))
+	}
+
+	rawContent, headlessRules, err := decodeRuleSetContent(C.GoString(content))
+	if err != nil {
+		return C.CString(fmt.Sprintf("decode rule-set error: %v", err))
+	}
+
+	switch ruleSet := ruleSet.(type) {
+	case *rule.LocalRuleSet:
+		err = localRuleSetReloadRules(ruleSet, headlessRules)
+	case *rule.RemoteRuleSet:
+		err = remoteRuleSetLoadBytes(ruleSet, rawContent)
+	default:
+		return C.CString(fmt.Sprintf("rule-set %s does not support updates: %T", ruleSetTag, ruleSet))
+	}
+	if err != nil {
+		return C.CString(fmt.Sprintf("update rule-set error: %v", err))
+	}
+	return nil
+}
+
+// decodeRuleSetContent accepts source JSON or base64 encoded binary and
+// returns the raw bytes along with the parsed rules.
+func decodeRuleSetContent(content string) ([]byte, []option.HeadlessRule, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, nil, fmt.Errorf("empty content")
+	}
+
+	if strings.HasPrefix(content, "{") {
+		rules, err := parseRuleSet([]byte(content), false)
+		return []byte(content), rules, err
+	}
+	rawContent, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("content is neither JSON nor base64 binary: %w", err)
+	}
+	rules, err := parseRuleSet(rawContent, true)
+	return rawContent, rules, err
+}
+
+// parseRuleSet decodes a source (JSON) or binary (.srs) rule-set.
+func parseRuleSet(content []byte, binary bool) ([]option.HeadlessRule, error) {
+	var (
+		compat option.PlainRuleSetCompat
+		err    error
+	)
+	if binary {
+		compat, err = srs.Read(bytes.NewReader(content), false)
+	} else {
+		compat, err = sjson.UnmarshalExtended[option.PlainRuleSetCompat](content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	plainRuleSet, err := compat.Upgrade()
+	if err != nil {
+		return nil, err
+	}
+	return plainRuleSet.Rules, nil
+}
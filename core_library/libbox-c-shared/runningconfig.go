@@ -0,0 +1,107 @@
+package main
+
+import "C"
+import (
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// redactedValue replaces secrets in a redacted config.
+const redactedValue = "***"
+
+// secretFields are the JSON names of the option struct fields that hold
+// credentials or key material, wherever they appear in a config.
+var secretFields = map[string]bool{
+	"password":               true,
+	"uuid":                   true,
+	"private_key":            true,
+	"private_key_passphrase": true,
+	"pre_shared_key":         true,
+	"mesh_psk":               true,
+	"auth":                   true,
+	"auth_str":               true,
+	"auth_key":               true,
+	"key":                    true,
+	"client_key":             true,
+	"mac_key":                true,
+	"secret":                 true,
+	"token":                  true,
+	"api_token":              true,
+	"zone_token":             true,
+	"security_token":         true,
+	"access_key_secret":      true,
+}
+
+// stringSecretFields are redacted only when they hold a string: hysteria's
+// obfs is a shared password, while hysteria2's is an object whose password
+// secretFields already covers.
+var stringSecretFields = map[string]bool{
+	"obfs": true,
+}
+
+// LibboxGetRunningConfig returns the options the running instance was
+// started with, re-encoded from what sing-box decoded. When redact
+// is non-zero, passwords, UUIDs, private and pre-shared keys, tokens and other
+// secrets are replaced by "***", so the config can be attached to a bug
+// report.
+//
+//export LibboxGetRunningConfig
+func LibboxGetRunningConfig(redact C.int) *C.char {
+	mu.Lock()
+	ctx := instanceCtx
+	options := instanceOptions
+	mu.Unlock()
+	if ctx == nil {
+		return jsonError("service not running")
+	}
+
+	content, err := sjson.MarshalContext(ctx, &options)
+	if err != nil {
+		return jsonError("encode config error: %v", err)
+	}
+	if redact == 0 {
+		return C.CString(string(content))
+	}
+	var config map[string]any
+	if err := sjson.Unmarshal(content, &config); err != nil {
+		return jsonError("decode config error: %v", err)
+	}
+	redactSecrets(config)
+	content, err = sjson.Marshal(config)
+	if err != nil {
+		return jsonError("encode config error: %v", err)
+	}
+	return C.CString(string(content))
+}
+
+// redactSecrets replaces the values of secretFields, and the string values
+// of stringSecretFields, throughout value, a decoded JSON document. Empty
+// values are left alone so it stays visible that a secret was not set.
+func redactSecrets(value any) {
+	switch node := value.(type) {
+	case map[string]any:
+		for key, child := range node {
+			_, isString := child.(string)
+			if (secretFields[key] || stringSecretFields[key] && isString) && !emptyJSON(child) {
+				node[key] = redactedValue
+				continue
+			}
+			redactSecrets(child)
+		}
+	case []any:
+		for _, child := range node {
+			redactSecrets(child)
+		}
+	}
+}
+
+func emptyJSON(value any) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []any:
+		return len(value) == 0
+	}
+	return false
+}
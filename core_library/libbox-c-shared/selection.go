@@ -0,0 +1,179 @@
+package main
+
+// #include "callback.h"
+import "C"
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/protocol/group"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// selectionPollInterval is how often urltest groups are checked for a new
+// selection; sing-box offers no hook for it.
+const selectionPollInterval = time.Second
+
+const (
+	// reselectWaitTimeout bounds how long LibboxURLTestReselect waits for a
+	// check it didn't start.
+	reselectWaitTimeout  = 2 * constant.TCPTimeout
+	reselectPollInterval = 50 * time.Millisecond
+)
+
+var selectionSink = newCallbackSink(16)
+
+// LibboxSetSelectionCallback registers the host function that is told when a
+// urltest group of the running instance switches to another outbound, as
+// {"group":..,"outbound":..,"previous":..}. Passing NULL unregisters it.
+//
+//export LibboxSetSelectionCallback
+func LibboxSetSelectionCallback(callback C.libbox_callback_t) {
+	selectionSink.set(callback)
+}
+
+type selectionEvent struct {
+	Group    string `json:"group"`
+	Outbound string `json:"outbound"`
+	Previous string `json:"previous"`
+}
+
+// watchSelections diffs the selection of every urltest group until ctx is
+// done. Selections are tracked even while no callback is registered so that
+// registering one does not report the current state as a change.
+func watchSelections(ctx context.Context, manager adapter.OutboundManager) {
+	ticker := time.NewTicker(selectionPollInterval)
+	defer ticker.Stop()
+	selected := make(map[string]string)
+	for {
+		for _, out := range manager.Outbounds() {
+			urlTest, isURLTest := out.(*group.URLTest)
+			if !isURLTest {
+				continue
+			}
+			now := urlTest.Now()
+			previous, loaded := selected[urlTest.Tag()]
+			selected[urlTest.Tag()] = now
+			if !loaded || previous == now || now == "" {
+				continue
+			}
+			postSelectionEvent(selectionEvent{
+				Group:    urlTest.Tag(),
+				Outbound: now,
+				Previous: previous,
+			})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func postSelectionEvent(event selectionEvent) {
+	if !selectionSink.registered() {
+		return
+	}
+	content, err := sjson.Marshal(event)
+	if err != nil {
+		return
+	}
+	selectionSink.post(string(content))
+}
+
+// LibboxURLTestReselect re-tests every member of the urltest group groupTag
+// in the running instance right away, ignoring the test interval, lets the
+// group apply the result and returns {"group","selected"} with the tag it
+// selected. sing-box skips a check while another one is running; the call
+// then waits for that check instead, up to reselectWaitTimeout, and adds
+// "inFlight":true since members may have been spared the forced re-test.
+//
+//export LibboxURLTestReselect
+func LibboxURLTestReselect(groupTag *C.char) *C.char {
+	tag := C.GoString(groupTag)
+	mu.Lock()
+	if instance == nil {
+		mu.Unlock()
+		return jsonError("service not running")
+	}
+	out, loaded := instance.Outbound().Outbound(tag)
+	mu.Unlock()
+	if !loaded {
+		return jsonError("outbound not found: %s", tag)
+	}
+	urlTest, isURLTest := out.(*group.URLTest)
+	if !isURLTest {
+		return jsonError("outbound %s is not a urltest group", tag)
+	}
+
+	checking := urlTestChecking(urlTest)
+	inFlight := checking != nil && checking.Load()
+	urlTest.CheckOutbounds()
+	if checking != nil && checking.Load() {
+		// ours was skipped, or another one started right after it
+		inFlight = true
+		deadline := time.Now().Add(reselectWaitTimeout)
+		for checking.Load() {
+			if time.Now().After(deadline) {
+				return jsonError("a check of %s is still running", tag)
+			}
+			time.Sleep(reselectPollInterval)
+		}
+	}
+	result := map[string]any{
+		"group":    tag,
+		"selected": urlTest.Now(),
+	}
+	if inFlight {
+		result["inFlight"] = true
+	}
+	content, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(content))
+}
+
+// urlTestChecking returns the flag a urltest group holds while a check runs,
+// or nil when sing-box's layout is not the expected one.
+func urlTestChecking(urlTest *group.URLTest) *atomic.Bool {
+	checking := unexportedField(unexportedField(reflect.ValueOf(urlTest), "group"), "checking")
+	if !checking.IsValid() {
+		return nil
+	}
+	flag, isFlag := checking.Addr().Interface().(*atomic.Bool)
+	if !isFlag {
+		return nil
+	}
+	return flag
+}
+
+// LibboxGetSelections returns the outbound every group of the running
+// instance, selector or urltest, currently uses as {"<group>":"<outbound>"},
+// enough to draw a whole proxy-group panel in one call. An empty object is
+// returned when the service is not running.
+//
+//export LibboxGetSelections
+func LibboxGetSelections() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	selections := make(map[string]string)
+	if instance != nil {
+		for _, out := range instance.Outbound().Outbounds() {
+			if outboundGroup, isGroup := out.(adapter.OutboundGroup); isGroup {
+				selections[out.Tag()] = outboundGroup.Now()
+			}
+		}
+	}
+	content, err := sjson.Marshal(selections)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(content))
+}
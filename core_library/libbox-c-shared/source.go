@@ -0,0 +1,189 @@
+package main
+
+import "C"
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// LibboxStartFromFile starts like LibboxStart with the config read from
+// path, which may be JSONC. It returns NULL on success and otherwise the
+// envelope of LibboxStartWithTimeout, with FILE_READ when the file can't be
+// read.
+//
+//export LibboxStartFromFile
+func LibboxStartFromFile(path *C.char, logFD C.longlong) *C.char {
+	content, err := os.ReadFile(C.GoString(path))
+	if err != nil {
+		return newCodedError(errorCodeFileRead, "read config error: %s", err).envelope()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	redirectLog(logFD)
+	if err := startDesktop(string(content), 0); err != nil {
+		return err.envelope()
+	}
+	return nil
+}
+
+// LibboxStartFromFD starts like LibboxStartFromFile with the config read
+// from fd until end of file, e.g. a pipe or memfd holding it in memory. The
+// library reads through a duplicate and never closes fd; the host keeps
+// owning it and closes it when it likes. A memfd or file is read from its
+// current offset, which ends up at the end.
+//
+//export LibboxStartFromFD
+func LibboxStartFromFD(fd C.int, logFD C.longlong) *C.char {
+	content, err := readDescriptor(int(fd))
+	if err != nil {
+		return newCodedError(errorCodeFileRead, "read config error: %s", err).envelope()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	redirectLog(logFD)
+	if err := startDesktop(string(content), 0); err != nil {
+		return err.envelope()
+	}
+	return nil
+}
+
+// LibboxStartGzip starts like LibboxStartFromFile with the config given as
+// length bytes of gzip data at gzippedConfig, which keeps big rule-heavy
+// configs small on their way over FFI. It returns DECOMPRESS when the data
+// is not valid gzip or inflates beyond remoteConfigLimit.
+//
+//export LibboxStartGzip
+func LibboxStartGzip(gzippedConfig *C.char, length C.int, logFD C.longlong) *C.char {
+	if gzippedConfig == nil || length <= 0 {
+		return newCodedError(errorCodeDecompress, "decompress config error: empty input").envelope()
+	}
+	content, err := gunzipConfig(C.GoBytes(unsafe.Pointer(gzippedConfig), length))
+	if err != nil {
+		return newCodedError(errorCodeDecompress, "decompress config error: %s", err).envelope()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	redirectLog(logFD)
+	if err := startDesktop(string(content), 0); err != nil {
+		return err.envelope()
+	}
+	return nil
+}
+
+// gunzipConfig inflates a gzip-compressed config, refusing to produce more
+// than remoteConfigLimit bytes.
+func gunzipConfig(compressed []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(io.LimitReader(reader, remoteConfigLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > remoteConfigLimit {
+		return nil, fmt.Errorf("config exceeds %d bytes", remoteConfigLimit)
+	}
+	return content, nil
+}
+
+// remoteConfigLimit bounds what LibboxStartFromURL downloads and
+// LibboxStartGzip inflates.
+const remoteConfigLimit = 16 << 20
+
+// LibboxStartFromURL downloads the config at url and starts it, for hosts
+// provisioned with nothing but a URL. The download uses direct system
+// networking, ignoring proxy environment variables, and sends
+// "Authorization: Bearer <bearerToken>" unless bearerToken is NULL or empty.
+// timeoutMS bounds download and start together (no bound when 0 or less). It
+// returns NULL on success and otherwise the envelope of
+// LibboxStartWithTimeout: FETCH_FAILED when the download fails or answers
+// with a non-2xx status, INVALID_CONFIG or DUPLICATE_TAG when the config
+// doesn't validate, and the start codes after that.
+//
+//export LibboxStartFromURL
+func LibboxStartFromURL(url *C.char, logFD C.longlong, timeoutMS C.longlong, bearerToken *C.char) *C.char {
+	ctx := context.Background()
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	var token string
+	if bearerToken != nil {
+		token = C.GoString(bearerToken)
+	}
+	content, _, err := downloadDirect(ctx, C.GoString(url), token)
+	if err != nil {
+		return newCodedError(errorCodeFetch, "download config error: %s", err).envelope()
+	}
+	configStr := string(content)
+	if err := validateConfig(configStr); err != nil {
+		return err.envelope()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	redirectLog(logFD)
+	if !deadline.IsZero() {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return newCodedError(errorCodeTimeout, "start service timed out after %v", time.Duration(timeoutMS)*time.Millisecond).envelope()
+		}
+	}
+	if err := startDesktop(configStr, timeout); err != nil {
+		return err.envelope()
+	}
+	return nil
+}
+
+// downloadDirect GETs target without any proxy and returns the body, capped
+// at remoteConfigLimit, together with the response headers.
+func downloadDirect(ctx context.Context, target string, bearerToken string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSpace(target), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create request error: %v", err)
+	}
+	if bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, remoteConfigLimit+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read body error: %v", err)
+	}
+	if len(content) > remoteConfigLimit {
+		return nil, nil, fmt.Errorf("response exceeds %d bytes", remoteConfigLimit)
+	}
+	return content, resp.Header, nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func readDescriptor(fd int) ([]byte, error) {
+	return nil, fmt.Errorf("reading the config from a descriptor is not supported on %s", runtime.GOOS)
+}
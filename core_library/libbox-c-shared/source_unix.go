@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readDescriptor reads fd to the end through a duplicate, so closing what
+// was read from leaves fd open.
+func readDescriptor(fd int) ([]byte, error) {
+	duplicate, err := unix.Dup(fd)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(duplicate), "config")
+	defer file.Close()
+	return io.ReadAll(file)
+}
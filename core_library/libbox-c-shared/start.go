@@ -0,0 +1,234 @@
+package main
+
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/service"
+)
+
+// LibboxStartWithTimeout is LibboxStart bounded by timeoutMS (no bound when
+// 0 or less). It returns NULL on success and otherwise a JSON envelope
+// {"code","message"}; a Start that doesn't finish in time yields
+// START_TIMEOUT and the half-started instance is closed once Start returns.
+//
+//export LibboxStartWithTimeout
+func LibboxStartWithTimeout(configJSON *C.char, logFD C.longlong, timeoutMS C.longlong) *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	redirectLog(logFD)
+	if err := startDesktop(C.GoString(configJSON), time.Duration(timeoutMS)*time.Millisecond); err != nil {
+		return err.envelope()
+	}
+	return nil
+}
+
+// startDesktop decodes the config and launches it. It must be called with mu
+// held.
+func startDesktop(configStr string, timeout time.Duration) *codedError {
+	if err := checkNotRunning(); err != nil {
+		return err
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancel = cancelFunc
+	ctx = include.Context(ctx)
+
+	options, err := decodeConfig(ctx, configStr)
+	if err != nil {
+		return abortStart(invalidConfigError(err))
+	}
+	return launchInstance(ctx, options, timeout)
+}
+
+// checkNotRunning is the guard every start shares: ALREADY_RUNNING with the
+// uptime of the running instance, or nil. Hosts racing two starts get it on
+// the second. It must be called with mu held.
+func checkNotRunning() *codedError {
+	if instance == nil {
+		return nil
+	}
+	err := newCodedError(errorCodeAlreadyRunning, "service already running")
+	if !instanceStartedAt.IsZero() {
+		err.UptimeSeconds = int64(time.Since(instanceStartedAt) / time.Second)
+	}
+	return err
+}
+
+// abortStart undoes a start that failed before launchInstance: it cancels
+// the context set up for it and records err as the last error. It must be
+// called with mu held.
+func abortStart(err *codedError) *codedError {
+	if cancel != nil {
+		cancel()
+		cancel = nil
+	}
+	lastInstanceError = err.Message
+	return err
+}
+
+// launchInstance creates and starts the instance for the decoded options and
+// publishes it on success. cancel must already be set for ctx; it is cleared
+// again on failure. It must be called with mu held.
+func launchInstance(ctx context.Context, options option.Options, timeout time.Duration) (launchErr *codedError) {
+	defer func() {
+		if launchErr != nil {
+			lastInstanceError = launchErr.Message
+		} else {
+			lastInstanceError = ""
+		}
+	}()
+	enableStatsOptions(&options)
+	// Sync current log level
+	if options.Log != nil {
+		currentLogLevel = options.Log.Level
+	}
+	clashConfigSecret = ""
+	if options.Experimental != nil && options.Experimental.ClashAPI != nil {
+		clashConfigSecret = options.Experimental.ClashAPI.Secret
+	}
+
+	newInstance, err := newBox(box.Options{
+		Context: ctx,
+		Options: options,
+	})
+	if err != nil {
+		cancel()
+		cancel = nil
+		return newCodedError(errorCodeCreate, "create service error: %s", err)
+	}
+	tracker := installConnectionTracker(newInstance.Router(), newInstance.Outbound())
+	installAppUserIDs(newInstance.Router())
+	dnsCounters := installDNSCounters(ctx)
+
+	startDone := make(chan error, 1)
+	go func() {
+		startDone <- newInstance.Start()
+	}()
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	select {
+	case err = <-startDone:
+	case <-timeoutC:
+		// Cancelling unblocks whatever in Start honours the context; Close
+		// has to wait for Start to return so it doesn't race the services
+		// Start is still bringing up.
+		cancel()
+		cancel = nil
+		go func() {
+			<-startDone
+			newInstance.Close()
+		}()
+		return newCodedError(errorCodeTimeout, "start service timed out after %v", timeout)
+	}
+	if err != nil {
+		newInstance.Close()
+		cancel()
+		cancel = nil
+		if portErr := portInUseError(err); portErr != nil {
+			return portErr
+		}
+		if tunErr := tunPermissionError(err, options); tunErr != nil {
+			return tunErr
+		}
+		return newCodedError(errorCodeStart, "start service error: %s", err)
+	}
+
+	instance = newInstance
+	instanceCtx = ctx
+	instanceOptions = options
+	instanceConnections = tracker
+	instanceDNSCounters = dnsCounters
+	instanceStartedAt = time.Now()
+	go watchSelections(ctx, instance.Outbound())
+	if networkManager := service.FromContext[adapter.NetworkManager](ctx); networkManager != nil {
+		watchDefaultInterface(ctx, networkManager, tracker)
+	}
+	return nil
+}
+
+// addrInUseMessages are the texts of "address in use" errors that don't
+// unwrap to syscall.EADDRINUSE, such as WSAEADDRINUSE on Windows.
+var addrInUseMessages = []string{
+	"address already in use",
+	"only one usage of each socket address",
+}
+
+// listenAddrPattern finds the address in "listen tcp 127.0.0.1:7890: ..." when
+// the error doesn't carry a *net.OpError.
+var listenAddrPattern = regexp.MustCompile(`listen (tcp|udp)[46]? (\S+):`)
+
+// tunPermissionError turns a start error caused by the process lacking the
+// privileges to create the TUN device of a tun inbound into a TUN_PERMISSION
+// error naming what is needed. It returns nil for any other error. On desktop
+// the tun inbound creates the device itself (utun on macOS, /dev/net/tun on
+// Linux, wintun on Windows) with the MTU, addresses and routes of the config,
+// so this is where a missing privilege surfaces.
+func tunPermissionError(err error, options option.Options) *codedError {
+	if !errors.Is(err, os.ErrPermission) || !common.Any(options.Inbounds, func(it option.Inbound) bool {
+		return it.Type == constant.TypeTun
+	}) {
+		return nil
+	}
+	var privilege string
+	switch runtime.GOOS {
+	case "linux":
+		privilege = "root or CAP_NET_ADMIN"
+	case "windows":
+		privilege = "administrator rights"
+	default:
+		privilege = "root"
+	}
+	return newCodedError(errorCodeTunPermission, "creating the TUN device needs %s: %s", privilege, err)
+}
+
+// portInUseError turns a start error caused by an occupied listen port, TCP
+// or UDP, into a PORT_IN_USE error. It returns nil for any other error.
+func portInUseError(err error) *codedError {
+	message := strings.ToLower(err.Error())
+	if !errors.Is(err, syscall.EADDRINUSE) && !common.Any(addrInUseMessages, func(it string) bool {
+		return strings.Contains(message, it)
+	}) {
+		return nil
+	}
+	result := &codedError{Code: errorCodePortInUse}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Addr != nil {
+		result.Network = opErr.Net
+		result.Address = opErr.Addr.String()
+	} else if match := listenAddrPattern.FindStringSubmatch(err.Error()); match != nil {
+		result.Network = match[1]
+		result.Address = match[2]
+	}
+	if result.Address == "" {
+		result.Message = fmt.Sprintf("listen port is already in use: %s", err)
+		return result
+	}
+	if _, port, splitErr := net.SplitHostPort(result.Address); splitErr == nil {
+		result.Port, _ = strconv.Atoi(port)
+	}
+	result.Network = strings.TrimRight(result.Network, "46")
+	result.Message = fmt.Sprintf("%s port %d is already in use (%s), change the port or close the application using it", result.Network, result.Port, result.Address)
+	return result
+}
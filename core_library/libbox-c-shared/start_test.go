@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+const testServiceConfig = `{"log":{"disabled":true},"outbounds":[{"type":"direct","tag":"direct"}]}`
+
+func TestConcurrentStartStop(t *testing.T) {
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				if (worker+i)%2 == 0 {
+					mu.Lock()
+					err := startDesktop(testServiceConfig, 0)
+					mu.Unlock()
+					if err != nil && err.Code != errorCodeAlreadyRunning {
+						t.Errorf("start: %s: %s", err.Code, err.Message)
+					}
+				} else {
+					takeCString(LibboxStop())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	running := instance != nil
+	if running != (instanceCtx != nil) || running != (cancel != nil) || running != (instanceConnections != nil) || running == instanceStartedAt.IsZero() {
+		t.Errorf("inconsistent state: instance %v, context %v, cancel %v, connections %v, started at %v",
+			instance != nil, instanceCtx != nil, cancel != nil, instanceConnections != nil, instanceStartedAt)
+	}
+	mu.Unlock()
+	if running {
+		if message := takeCString(LibboxStop()); message != "" {
+			t.Fatalf("stop: %s", message)
+		}
+	}
+	if count := LibboxDebugInstanceCount(); count != 0 {
+		t.Fatalf("%d boxes left open after stopping", count)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import "C"
+import (
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/experimental/clashapi/trafficontrol"
+	"github.com/sagernet/sing-box/option"
+	sjson "github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/service"
+)
+
+// clashTrafficManager is implemented by the clash API server, whose totals
+// back its /traffic and /connections endpoints.
+type clashTrafficManager interface {
+	TrafficManager() *trafficontrol.Manager
+}
+
+// statsAutoEnable is set by LibboxEnableStats to give every instance started
+// afterwards the clash API's traffic manager, and cleared by
+// LibboxDisableStats. It is guarded by mu.
+var statsAutoEnable bool
+
+type statsState struct {
+	Enabled    bool `json:"enabled"`
+	AutoEnable bool `json:"autoEnable"`
+}
+
+type outboundStat struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// LibboxGetOutboundStats returns the bytes each outbound of the running
+// instance has carried since Start, as {"<tag>":{"upload":..,"download":..}}.
+// Traffic routed to a group counts for the member the group used when the
+// connection was routed, so groups themselves stay at zero. Outbounds that
+// have not been used yet are reported with zeros; an empty object is
+// returned when the service is not running.
+//
+//export LibboxGetOutboundStats
+func LibboxGetOutboundStats() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	stats := make(map[string]outboundStat)
+	if instance != nil && instanceConnections != nil {
+		for _, outbound := range instance.Outbound().Outbounds() {
+			stats[outbound.Tag()] = outboundStat{}
+		}
+		for tag, stat := range instanceConnections.outboundStats() {
+			if tag == "" {
+				continue
+			}
+			stats[tag] = stat
+		}
+	}
+	jsonBytes, err := sjson.Marshal(stats)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// LibboxResetTrafficStats zeroes the cumulative upload and download totals of
+// the running instance: the per-outbound counters of LibboxGetOutboundStats
+// and, when the clash API is enabled, its connection totals. Active
+// connections are left alone and keep counting from zero. Returns {"ok":true}
+// or {"error":...}.
+//
+//export LibboxResetTrafficStats
+func LibboxResetTrafficStats() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil || instanceConnections == nil {
+		return jsonError("service not running")
+	}
+	instanceConnections.resetStats()
+	if server, loaded := service.FromContext[adapter.ClashServer](instanceCtx).(clashTrafficManager); loaded {
+		server.TrafficManager().ResetStatistic()
+	}
+	return C.CString(`{"ok":true}`)
+}
+
+// LibboxEnableStats turns on the clash API's traffic manager, the stats
+// tracker behind the clash /traffic and /connections totals that
+// LibboxResetTrafficStats and LibboxSetClashAPIEnabled work with, for configs
+// that don't set experimental.clash_api themselves. Per-outbound counts of
+// LibboxGetOutboundStats are kept by the library and need no stats service.
+//
+// sing-box only creates its trackers in box.New, so a running instance can't
+// gain one: from this call until LibboxDisableStats, every start adds an
+// empty clash_api section, which serves nothing until
+// LibboxSetClashAPIEnabled, to configs without one. That section also makes
+// clash_mode rules match against the API's mode, "rule" by default, where
+// without it they never match. Returns {"enabled","autoEnable"}, where enabled tells whether the
+// running instance has the tracker, or {"error"} when it is running without
+// it and needs a restart. The library must be built with with_clash_api.
+//
+//export LibboxEnableStats
+func LibboxEnableStats() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	statsAutoEnable = true
+	state := statsState{AutoEnable: true}
+	if instance != nil {
+		if _, err := runningClashServer(); err != nil {
+			return jsonError("stats can't be enabled on the running instance, restart it to apply")
+		}
+		state.Enabled = true
+	}
+	jsonBytes, err := sjson.Marshal(state)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// LibboxDisableStats undoes LibboxEnableStats for later starts, which run
+// configs as written again. The running instance keeps its tracker until it
+// is restarted. Returns {"enabled","autoEnable"} like LibboxEnableStats.
+//
+//export LibboxDisableStats
+func LibboxDisableStats() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	statsAutoEnable = false
+	var state statsState
+	if instance != nil {
+		if _, err := runningClashServer(); err == nil {
+			state.Enabled = true
+		}
+	}
+	jsonBytes, err := sjson.Marshal(state)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+// enableStatsOptions adds an empty clash_api section to options when
+// LibboxEnableStats asked for it. It must be called with mu held.
+func enableStatsOptions(options *option.Options) {
+	if !statsAutoEnable {
+		return
+	}
+	if options.Experimental == nil {
+		options.Experimental = &option.ExperimentalOptions{}
+	}
+	if options.Experimental.ClashAPI == nil {
+		options.Experimental.ClashAPI = &option.ClashAPIOptions{}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// #include "callback.h"
+import "C"
+import (
+	"time"
+
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+var statusSink = newCallbackSink(32)
+
+// LibboxSetStatusCallback registers the host function that receives state
+// changes of the running instance as JSON objects carrying an "event" field.
+// Passing NULL unregisters it.
+//
+//export LibboxSetStatusCallback
+func LibboxSetStatusCallback(callback C.libbox_callback_t) {
+	statusSink.set(callback)
+}
+
+func postStatusEvent(event string, fields map[string]any) {
+	if !statusSink.registered() {
+		return
+	}
+	payload := map[string]any{"event": event}
+	for key, value := range fields {
+		payload[key] = value
+	}
+	content, err := sjson.Marshal(payload)
+	if err != nil {
+		return
+	}
+	statusSink.post(string(content))
+}
+
+// LibboxUptime returns the seconds since the running instance was started,
+// or 0 when the service is not running.
+//
+//export LibboxUptime
+func LibboxUptime() C.longlong {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil || instanceStartedAt.IsZero() {
+		return 0
+	}
+	return C.longlong(time.Since(instanceStartedAt) / time.Second)
+}
+
+// LibboxStartedAt returns the Unix time in seconds at which the running
+// instance was started, or 0 when the service is not running.
+//
+//export LibboxStartedAt
+func LibboxStartedAt() C.longlong {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil || instanceStartedAt.IsZero() {
+		return 0
+	}
+	return C.longlong(instanceStartedAt.Unix())
+}
@@ -0,0 +1,300 @@
+package main
+
+// #include "callback.h"
+import "C"
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing-box/include"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// subscriptionFetchTimeout bounds each fetch of the subscription updater;
+// shorter intervals bound it instead.
+const subscriptionFetchTimeout = 30 * time.Second
+
+var (
+	subscriptionSink   = newCallbackSink(4)
+	subscriptionAccess sync.Mutex
+	subscription       *subscriptionRun
+)
+
+type subscriptionRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// subscriptionUpdate is what the subscription updater passes to its
+// callback. A failed fetch carries only Error.
+type subscriptionUpdate struct {
+	Outbounds []map[string]any  `json:"outbounds,omitempty"`
+	Errors    []linkError       `json:"errors,omitempty"`
+	Userinfo  *subscriptionInfo `json:"userinfo,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// subscriptionInfo is the Subscription-Userinfo header: traffic in bytes
+// and the expiry as a Unix timestamp, 0 when the header leaves it out.
+type subscriptionInfo struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+	Total    int64 `json:"total"`
+	Expire   int64 `json:"expire"`
+}
+
+// LibboxStartSubscriptionUpdater fetches the subscription at url right away
+// and then every intervalMS over direct networking, passing callback
+// {"outbounds":[...],"errors":[...],"userinfo":{upload,download,total,
+// expire}}. The body may be a sing-box config with outbounds, share links, or
+// base64 of share links, which are parsed like LibboxParseLinks; userinfo is
+// present when the server sends Subscription-Userinfo. A failed fetch is
+// reported as {"error"} and retried at the next interval. Only one updater
+// runs at a time; stop it with LibboxStopSubscriptionUpdater.
+//
+//export LibboxStartSubscriptionUpdater
+func LibboxStartSubscriptionUpdater(url *C.char, intervalMS C.longlong, callback C.libbox_callback_t) *C.char {
+	interval := time.Duration(intervalMS) * time.Millisecond
+	if interval <= 0 {
+		return C.CString("interval must be positive")
+	}
+	if callback == nil {
+		return C.CString("callback is required")
+	}
+	target := C.GoString(url)
+
+	subscriptionAccess.Lock()
+	defer subscriptionAccess.Unlock()
+
+	if subscription != nil {
+		return C.CString("subscription updater already running")
+	}
+	run := &subscriptionRun{done: make(chan struct{})}
+	var ctx context.Context
+	ctx, run.cancel = context.WithCancel(context.Background())
+	subscriptionSink.set(callback)
+	subscription = run
+	go run.loop(ctx, target, interval)
+	return nil
+}
+
+// LibboxStopSubscriptionUpdater stops the subscription updater, cancelling a
+// fetch in progress. No updates are delivered after it returns.
+//
+//export LibboxStopSubscriptionUpdater
+func LibboxStopSubscriptionUpdater() *C.char {
+	subscriptionAccess.Lock()
+	defer subscriptionAccess.Unlock()
+
+	if subscription == nil {
+		return C.CString("subscription updater not running")
+	}
+	subscription.cancel()
+	<-subscription.done
+	subscriptionSink.set(nil)
+	subscription = nil
+	return nil
+}
+
+func (r *subscriptionRun) loop(ctx context.Context, target string, interval time.Duration) {
+	defer close(r.done)
+	timeout := min(interval, subscriptionFetchTimeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		update := fetchSubscription(ctx, target, timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if content, err := sjson.Marshal(update); err == nil {
+			subscriptionSink.post(string(content))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func fetchSubscription(ctx context.Context, target string, timeout time.Duration) subscriptionUpdate {
+	fetchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	content, header, err := downloadDirect(fetchCtx, target, "")
+	if err != nil {
+		return subscriptionUpdate{Error: err.Error()}
+	}
+	parsed, err := parseSubscription(content)
+	if err != nil {
+		return subscriptionUpdate{Error: err.Error()}
+	}
+	update := subscriptionUpdate{
+		Outbounds: parsed.Outbounds,
+		Errors:    parsed.Errors,
+		Userinfo:  parseSubscriptionInfo(header.Get("Subscription-Userinfo")),
+	}
+	if len(update.Outbounds) == 0 {
+		update.Error = "no outbounds in subscription"
+	}
+	return update
+}
+
+// parseSubscription reads the outbounds of a subscription body: a sing-box
+// config with outbounds, share links, or base64 of share links, which are
+// parsed like LibboxParseLinks.
+func parseSubscription(content []byte) (parsedLinks, error) {
+	var config struct {
+		Outbounds []map[string]any `json:"outbounds"`
+	}
+	if err := sjson.Unmarshal(stripJSONC(content), &config); err == nil && len(config.Outbounds) > 0 {
+		return parsedLinks{Outbounds: config.Outbounds, Errors: []linkError{}}, nil
+	}
+	links := strings.TrimSpace(string(content))
+	if !strings.Contains(links, "://") {
+		if decoded, err := decodeLinkBase64(strings.Join(strings.Fields(links), "")); err == nil {
+			links = string(decoded)
+		}
+	}
+	return parseLinks(links)
+}
+
+// parseSubscriptionInfo reads "upload=1; download=2; total=3; expire=4",
+// returning nil when the header is absent or has none of those fields.
+func parseSubscriptionInfo(header string) *subscriptionInfo {
+	var (
+		info  subscriptionInfo
+		found bool
+	)
+	for _, field := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			// some servers send the expiry as a float
+			float, floatErr := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if floatErr != nil {
+				continue
+			}
+			number = int64(float)
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "upload":
+			info.Upload = number
+		case "download":
+			info.Download = number
+		case "total":
+			info.Total = number
+		case "expire":
+			info.Expire = number
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return &info
+}
+
+type subscriptionNode struct {
+	Tag       string         `json:"tag"`
+	Outbound  map[string]any `json:"outbound"`
+	Reachable bool           `json:"reachable"`
+	LatencyMs uint16         `json:"latencyMs,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type subscriptionTestResult struct {
+	Nodes       []subscriptionNode `json:"nodes"`
+	ParseErrors []linkError        `json:"parseErrors"`
+}
+
+// LibboxTestSubscription imports and measures a subscription in one call:
+// content is parsed like a subscription body (a config with outbounds, share
+// links or base64 of them) and the outbounds are URL-tested against
+// targetURL as by LibboxTestBatch. Returns {"nodes":[{"tag","outbound",
+// "reachable","latencyMs","error"}],"parseErrors":[{"line","message"}]}
+// with nodes in subscription order. A node's error tells why it could not be
+// decoded or tested; links that could not be parsed at all are in
+// parseErrors.
+//
+//export LibboxTestSubscription
+func LibboxTestSubscription(content *C.char, targetURL *C.char, timeoutMS C.longlong) *C.char {
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	result, err := testSubscription(C.GoString(content), C.GoString(targetURL), timeout)
+	if err != nil {
+		return jsonError("%v", err)
+	}
+	jsonBytes, err := sjson.Marshal(result)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonBytes))
+}
+
+func testSubscription(content string, targetStr string, timeout time.Duration) (*subscriptionTestResult, error) {
+	parsed, err := parseSubscription([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+
+	result := subscriptionTestResult{
+		Nodes:       make([]subscriptionNode, len(parsed.Outbounds)),
+		ParseErrors: parsed.Errors,
+	}
+	tags := assignBatchTags(parsed.Outbounds)
+	ctx := include.Context(context.Background())
+	var testable []map[string]any
+	for i, outbound := range parsed.Outbounds {
+		node := &result.Nodes[i]
+		node.Tag = tags[i]
+		node.Outbound = outbound
+		outboundJSON, err := sjson.Marshal(outbound)
+		if err == nil {
+			_, err = decodeTestOutbounds(ctx, string(outboundJSON))
+		}
+		if err != nil {
+			node.Error = err.Error()
+			continue
+		}
+		testable = append(testable, outbound)
+	}
+
+	if len(testable) > 0 {
+		batchJSON, err := sjson.Marshal(map[string]any{"outbounds": testable})
+		if err != nil {
+			return nil, fmt.Errorf("encode outbounds error: %v", err)
+		}
+		var latencies map[string]any
+		failures := make(map[string]error)
+		batchErr := "no response from target"
+		if err := sjson.Unmarshal([]byte(testBatchFailures(string(batchJSON), targetStr, timeout, failures)), &latencies); err != nil {
+			batchErr = err.Error()
+		} else if message, isError := latencies["error"].(string); isError {
+			batchErr = message
+			latencies = nil
+		}
+		for i := range result.Nodes {
+			node := &result.Nodes[i]
+			if node.Error != "" {
+				continue
+			}
+			if latency, tested := latencies[node.Tag].(float64); tested {
+				node.Reachable = true
+				node.LatencyMs = uint16(latency)
+			} else if failure, failed := failures[node.Tag]; failed {
+				node.Error = failure.Error()
+			} else {
+				node.Error = batchErr
+			}
+		}
+	}
+	return &result, nil
+}
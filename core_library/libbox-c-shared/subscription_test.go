@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionNodeErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	content := `{"outbounds":[
+		{"type":"direct","tag":"direct"},
+		{"type":"socks","tag":"refused-1","server":"127.0.0.1","server_port":1},
+		{"type":"http","tag":"refused-2","server":"127.0.0.1","server_port":2}
+	]}`
+	result, err := testSubscription(content, server.URL, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Nodes) != 3 {
+		t.Fatalf("got %d nodes, want 3", len(result.Nodes))
+	}
+	if node := result.Nodes[0]; !node.Reachable || node.Error != "" {
+		t.Fatalf("direct node: reachable %v, error %q", node.Reachable, node.Error)
+	}
+	first, second := result.Nodes[1], result.Nodes[2]
+	for _, node := range []subscriptionNode{first, second} {
+		if node.Reachable || node.Error == "" {
+			t.Fatalf("node %s: reachable %v, error %q", node.Tag, node.Reachable, node.Error)
+		}
+	}
+	if first.Error == second.Error {
+		t.Fatalf("both failed nodes report %q instead of their own failure", first.Error)
+	}
+}
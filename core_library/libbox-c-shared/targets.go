@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/constant"
+	sjson "github.com/sagernet/sing/common/json"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/common/ntp"
+)
+
+// urlTestConcurrency matches the parallelism of sing-box's urltest group.
+const urlTestConcurrency = 10
+
+// parseTargetURLs accepts a single URL, a comma-separated list, or a JSON
+// array of URLs, in the order they should be tried. Commas are legal in a
+// URL, so content is only split when every piece is an absolute URL on its
+// own; use the JSON array to list URLs that contain commas.
+func parseTargetURLs(content string) []string {
+	content = strings.TrimSpace(content)
+	var targets []string
+	if strings.HasPrefix(content, "[") {
+		if err := sjson.Unmarshal([]byte(content), &targets); err == nil {
+			return compactTargets(targets)
+		}
+	}
+	targets = compactTargets(strings.Split(content, ","))
+	for _, target := range targets {
+		if !isAbsoluteURL(target) {
+			return compactTargets([]string{content})
+		}
+	}
+	return targets
+}
+
+func isAbsoluteURL(target string) bool {
+	targetURL, err := url.Parse(target)
+	return err == nil && targetURL.IsAbs() && targetURL.Host != ""
+}
+
+func compactTargets(targets []string) []string {
+	result := make([]string, 0, len(targets))
+	for _, target := range targets {
+		target = strings.TrimSpace(target)
+		if target != "" {
+			result = append(result, target)
+		}
+	}
+	return result
+}
+
+// probeTargets runs probeURLTiming against each target in turn and returns
+// the timing of the first that answers, together with that target. The error
+// of the last attempt is returned when none do. readBody is passed on to
+// probeURLTiming.
+func probeTargets(ctx context.Context, client *http.Client, targets []string, readBody bool) (probeTiming, string, error) {
+	err := errors.New("no target url")
+	for _, target := range targets {
+		var timing probeTiming
+		timing, err = probeURLTiming(ctx, client, target, readBody)
+		if err == nil {
+			return timing, target, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return probeTiming{}, "", err
+}
+
+// urlTestTargets is urlTest with fallback to the next target when one fails.
+func urlTestTargets(ctx context.Context, targets []string, out adapter.Outbound) (uint16, error) {
+	err := errors.New("no target url")
+	for _, target := range targets {
+		var delay uint16
+		delay, err = urlTest(ctx, target, out)
+		if err == nil {
+			return delay, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return 0, err
+}
+
+// urlTest is urltest.URLTest sending the test User-Agent: the HEAD request
+// is timed from the moment the connection through the outbound is up, or
+// from the handshake for protocols that only complete it on first write.
+func urlTest(ctx context.Context, link string, detour N.Dialer) (uint16, error) {
+	if link == "" {
+		link = "https://www.gstatic.com/generate_204"
+	}
+	linkURL, err := url.Parse(link)
+	if err != nil {
+		return 0, err
+	}
+	port := linkURL.Port()
+	if port == "" {
+		switch linkURL.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+
+	start := time.Now()
+	conn, err := detour.DialContext(ctx, "tcp", M.ParseSocksaddrHostPortStr(linkURL.Hostname(), port))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if N.NeedHandshakeForWrite(conn) {
+		start = time.Now()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, link, nil)
+	if err != nil {
+		return 0, err
+	}
+	setUserAgent(req)
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return conn, nil
+			},
+			TLSClientConfig: &tls.Config{
+				Time:    ntp.TimeFuncFromContext(ctx),
+				RootCAs: adapter.RootPoolFromContext(ctx),
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: constant.TCPTimeout,
+	}
+	defer client.CloseIdleConnections()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return uint16(time.Since(start) / time.Millisecond), nil
+}
+
+// urlTestOutbounds URL-tests the outbounds concurrently and stores the delay
+// of every one that succeeds in results under its tag, and the error of every
+// one that fails in failures unless it is nil.
+func urlTestOutbounds(ctx context.Context, outbounds []adapter.Outbound, targets []string, results map[string]uint16, failures map[string]error) {
+	var (
+		resultAccess sync.Mutex
+		wg           sync.WaitGroup
+		slots        = make(chan struct{}, urlTestConcurrency)
+	)
+	for _, out := range outbounds {
+		wg.Add(1)
+		go func(out adapter.Outbound) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			delay, err := urlTestTargets(ctx, targets, out)
+			resultAccess.Lock()
+			defer resultAccess.Unlock()
+			if err != nil {
+				if failures != nil {
+					failures[out.Tag()] = err
+				}
+				return
+			}
+			results[out.Tag()] = delay
+		}(out)
+	}
+	wg.Wait()
+}
+
+// maxTestRetries caps the retries a single test may ask for.
+const maxTestRetries = 10
+
+// testRetry is how often and how patiently a test retries failed probes.
+type testRetry struct {
+	Retries int
+	// Backoff is the wait before the first retry; it doubles for each
+	// following one.
+	Backoff time.Duration
+}
+
+// probeTargets is probeTargets retried on failure until an attempt succeeds,
+// the retries run out or ctx is done. A retry is not started when ctx would
+// expire during the backoff.
+func (r testRetry) probeTargets(ctx context.Context, client *http.Client, targets []string, readBody bool) (probeTiming, string, error) {
+	backoff := r.Backoff
+	for attempt := 0; ; attempt++ {
+		timing, target, err := probeTargets(ctx, client, targets, readBody)
+		if err == nil || attempt >= r.Retries || ctx.Err() != nil {
+			return timing, target, err
+		}
+		if deadline, loaded := ctx.Deadline(); loaded && time.Until(deadline) <= backoff {
+			return timing, target, err
+		}
+		if backoff > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return timing, target, err
+			case <-timer.C:
+			}
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseTargetURLs(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		want    []string
+	}{
+		{"https://a.example/", []string{"https://a.example/"}},
+		{"https://a.example/, http://b.example/x", []string{"https://a.example/", "http://b.example/x"}},
+		{`["https://a.example/?q=1,2", "https://b.example/"]`, []string{"https://a.example/?q=1,2", "https://b.example/"}},
+		{"https://a.example/?q=1,2", []string{"https://a.example/?q=1,2"}},
+		{"https://a.example/path,with,commas", []string{"https://a.example/path,with,commas"}},
+		{" ", []string{}},
+	} {
+		if got := parseTargetURLs(test.content); !slices.Equal(got, test.want) {
+			t.Errorf("parseTargetURLs(%q) = %q, want %q", test.content, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/sagernet/sing-box/adapter"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// testRouteInbound names the made-up inbound the request of a test with
+// "captureRoute" enters the test box's router from.
+const testRouteInbound = "test-route"
+
+// testRoute is how the router of a test box handled the connection of the
+// measured request: the rule that matched ("final" for the default
+// outbound), the outbound it picked and the outbounds the connection went
+// through from there, following groups and detours.
+type testRoute struct {
+	Rule     string   `json:"rule"`
+	Outbound string   `json:"outbound"`
+	Chain    []string `json:"chain"`
+}
+
+// routeRecorder is a connection tracker on the router of a test box that
+// keeps the decision for the last connection routed.
+type routeRecorder struct {
+	manager adapter.OutboundManager
+	access  sync.Mutex
+	route   *testRoute
+}
+
+func recordTestRoute(tempInstance *trackedBox) *routeRecorder {
+	recorder := &routeRecorder{manager: tempInstance.Outbound()}
+	tempInstance.Router().AppendTracker(recorder)
+	return recorder
+}
+
+func (r *routeRecorder) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) net.Conn {
+	route := &testRoute{
+		Rule:     "final",
+		Outbound: matchOutbound.Tag(),
+		Chain:    outboundChain(r.manager, matchOutbound),
+	}
+	if matchedRule != nil {
+		route.Rule = matchedRule.String()
+	}
+	r.access.Lock()
+	r.route = route
+	r.access.Unlock()
+	return conn
+}
+
+func (r *routeRecorder) RoutedPacketConnection(ctx context.Context, conn N.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) N.PacketConn {
+	return conn
+}
+
+func (r *routeRecorder) lastRoute() *testRoute {
+	r.access.Lock()
+	defer r.access.Unlock()
+	return r.route
+}
+
+// outboundChain lists out and the outbounds behind it: the member a group
+// currently uses, or the detour of a proxy.
+func outboundChain(manager adapter.OutboundManager, out adapter.Outbound) []string {
+	path := outboundPath(manager, out, true)
+	chain := make([]string, 0, len(path))
+	for _, it := range path {
+		chain = append(chain, it.Tag())
+	}
+	return chain
+}
+
+// routedDialer dials through a router instead of straight through an
+// outbound, so the router's rules decide where the connection goes, and a
+// routeRecorder on a test box sees the decision. The connection is handed to
+// the router as one end of a pipe.
+type routedDialer struct {
+	router adapter.Router
+}
+
+func (d *routedDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if N.NetworkName(network) != N.NetworkTCP {
+		return nil, os.ErrInvalid
+	}
+	conn, routedConn := net.Pipe()
+	var (
+		access   sync.Mutex
+		returned bool
+		routeErr error
+	)
+	// routing and dialing fail before RouteConnectionEx returns; later
+	// calls only report the connection closing
+	onClose := func(err error) {
+		access.Lock()
+		if !returned {
+			routeErr = err
+		}
+		access.Unlock()
+	}
+	d.router.RouteConnectionEx(ctx, routedConn, adapter.InboundContext{
+		Inbound:     testRouteInbound,
+		Network:     N.NetworkTCP,
+		Destination: destination,
+	}, onClose)
+	access.Lock()
+	returned = true
+	err := routeErr
+	access.Unlock()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *routedDialer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	return nil, os.ErrInvalid
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type tlsDetails struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipherSuite"`
+	ALPN        string `json:"alpn,omitempty"`
+	ServerName  string `json:"serverName"`
+	Verified    bool   `json:"verified"`
+	VerifyError string `json:"verifyError,omitempty"`
+}
+
+// tlsInspector records what the test client's TLS handshakes negotiated.
+// Certificate verification is done by the inspector itself so that a node
+// presenting a bad certificate still yields the details, with verified
+// false, instead of only a handshake error.
+type tlsInspector struct {
+	access  sync.Mutex
+	details *tlsDetails
+}
+
+// inspectTLS installs a tlsInspector on a client from outboundHTTPClient.
+func inspectTLS(client *http.Client) *tlsInspector {
+	inspector := &tlsInspector{}
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection:   inspector.verifyConnection,
+	}
+	return inspector
+}
+
+func (i *tlsInspector) verifyConnection(state tls.ConnectionState) error {
+	details := &tlsDetails{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+		ServerName:  state.ServerName,
+	}
+	if err := verifyServerCertificate(state); err != nil {
+		details.VerifyError = err.Error()
+	} else {
+		details.Verified = true
+	}
+	i.access.Lock()
+	i.details = details
+	i.access.Unlock()
+	return nil
+}
+
+// lastHandshake returns the details of the latest handshake, or nil when the
+// target was not reached over TLS.
+func (i *tlsInspector) lastHandshake() *tlsDetails {
+	i.access.Lock()
+	defer i.access.Unlock()
+	return i.details
+}
+
+// verifyServerCertificate repeats the verification crypto/tls does by default.
+func verifyServerCertificate(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no peer certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// pinCertificate makes the client's handshakes fail unless the leaf
+// certificate's SHA-256 matches fingerprint. It composes with inspectTLS.
+func pinCertificate(client *http.Client, fingerprint []byte) {
+	transport := client.Transport.(*http.Transport)
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no peer certificate")
+		}
+		actual := sha256.Sum256(rawCerts[0])
+		if !bytes.Equal(actual[:], fingerprint) {
+			return fmt.Errorf("certificate fingerprint mismatch: expected %s, got %s", hex.EncodeToString(fingerprint), hex.EncodeToString(actual[:]))
+		}
+		return nil
+	}
+}
+
+// parseFingerprint accepts a SHA-256 fingerprint as hex, with or without
+// colon separators.
+func parseFingerprint(content string) ([]byte, error) {
+	content = strings.ReplaceAll(strings.TrimSpace(content), ":", "")
+	fingerprint, err := hex.DecodeString(content)
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, fmt.Errorf("invalid sha-256 fingerprint: %s", content)
+	}
+	return fingerprint, nil
+}
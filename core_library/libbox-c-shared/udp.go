@@ -0,0 +1,68 @@
+package main
+
+import "C"
+import (
+	"context"
+	"time"
+
+	"github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// udpProbeTimeout bounds the DNS query LibboxOutboundSupportsUDP falls back
+// to.
+const udpProbeTimeout = 3 * time.Second
+
+// nativeUDPTypes carry UDP as part of the protocol itself, so a server that
+// speaks it relays UDP too. Other protocols leave UDP optional to the server.
+var nativeUDPTypes = []string{
+	constant.TypeDirect,
+	constant.TypeShadowsocks,
+	constant.TypeHysteria,
+	constant.TypeHysteria2,
+	constant.TypeTUIC,
+	constant.TypeWireGuard,
+}
+
+// LibboxOutboundSupportsUDP tells whether the outbound (or the entry of a
+// chain) can carry UDP: 1 if it can, 0 if it can't, -1 if unknown or the
+// config is invalid. The networks the outbound declares after parsing decide
+// first, e.g. HTTP and SSH never do and "network": "tcp" turns UDP off. When
+// UDP depends on the server, a DNS query is sent through the outbound; no
+// answer yields -1, since it can't be told apart from an unreachable server.
+//
+//export LibboxOutboundSupportsUDP
+func LibboxOutboundSupportsUDP(outboundJSON *C.char) C.int {
+	configStr := C.GoString(outboundJSON)
+
+	ctx, cancel := context.WithTimeout(context.Background(), udpProbeTimeout)
+	defer cancel()
+
+	ctx = include.Context(ctx)
+
+	tempInstance, out, err := startTestOutbound(ctx, configStr, "test-outbound", currentLogLevel)
+	if err != nil {
+		return -1
+	}
+	defer tempInstance.Close()
+
+	if out.Type() == constant.TypeBlock || !common.Contains(out.Network(), N.NetworkUDP) {
+		return 0
+	}
+	if common.Contains(nativeUDPTypes, out.Type()) {
+		return 1
+	}
+
+	_, _, message, err := newDNSQuery("www.google.com", "A")
+	if err != nil {
+		return -1
+	}
+	destination := metadata.ParseSocksaddr(defaultDetourDNSServer)
+	if _, err := exchangeThrough(ctx, out, N.NetworkUDP, destination, message); err != nil {
+		return -1
+	}
+	return 1
+}
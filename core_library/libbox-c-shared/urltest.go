@@ -0,0 +1,53 @@
+package main
+
+import "C"
+import (
+	"slices"
+
+	"github.com/sagernet/sing-box/option"
+)
+
+// LibboxSetURLTestTolerance recreates the running urltest group groupTag,
+// as LibboxReloadOutbounds would, with a new tolerance: how many
+// milliseconds faster another member must be before the group switches to
+// it. ms of 0 restores sing-box's default of 50.
+//
+// This is not a live tweak. Recreating the group drops every connection
+// through it and through outbounds built on it, and restarts its tests. The
+// change is lost on restart.
+//
+//export LibboxSetURLTestTolerance
+func LibboxSetURLTestTolerance(groupTag *C.char, ms C.int) *C.char {
+	if ms < 0 || ms > 0xffff {
+		return C.CString("tolerance must be between 0 and 65535 ms")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		return C.CString("service not running")
+	}
+	tag := C.GoString(groupTag)
+	outbounds := slices.Clone(instanceOptions.Outbounds)
+	index := slices.IndexFunc(outbounds, func(it option.Outbound) bool {
+		return it.Tag == tag
+	})
+	if index < 0 {
+		return C.CString("outbound not found: " + tag)
+	}
+	urlTestOptions, isURLTest := outbounds[index].Options.(*option.URLTestOutboundOptions)
+	if !isURLTest {
+		return C.CString("outbound " + tag + " is not a urltest group")
+	}
+	if urlTestOptions.Tolerance == uint16(ms) {
+		return nil
+	}
+	updated := *urlTestOptions
+	updated.Tolerance = uint16(ms)
+	outbounds[index].Options = &updated
+	if _, err := reloadOutbounds(outbounds); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
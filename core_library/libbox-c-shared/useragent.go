@@ -0,0 +1,68 @@
+package main
+
+import "C"
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultUserAgent is sent by test and fetch requests until the host sets
+// its own, since some providers block or throttle Go's default agent.
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36"
+
+var (
+	userAgentAccess sync.Mutex
+	userAgent       = defaultUserAgent
+)
+
+// LibboxSetUserAgent sets the User-Agent of the requests LibboxTestOutbound,
+// LibboxTestBatch and the LibboxFetch functions send through outbounds. An
+// empty ua restores the browser-like default. A single call can override it
+// with a "userAgent" field in its outbound JSON, or in the LibboxTestBatch
+// wrapper.
+//
+//export LibboxSetUserAgent
+func LibboxSetUserAgent(ua *C.char) {
+	value := strings.TrimSpace(C.GoString(ua))
+	if value == "" {
+		value = defaultUserAgent
+	}
+	userAgentAccess.Lock()
+	userAgent = value
+	userAgentAccess.Unlock()
+}
+
+type userAgentKey struct{}
+
+// withUserAgent makes requests sent under ctx use ua instead of the
+// package-wide User-Agent; an empty ua changes nothing.
+func withUserAgent(ctx context.Context, ua string) context.Context {
+	if ua == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, userAgentKey{}, ua)
+}
+
+// takeUserAgent removes the per-call "userAgent" test field from configStr
+// and carries it in the returned context.
+func takeUserAgent(ctx context.Context, configStr string) (context.Context, string, error) {
+	configStr, ua, err := takeTestOption(configStr, "userAgent")
+	if err != nil {
+		return ctx, configStr, err
+	}
+	return withUserAgent(ctx, strings.TrimSpace(ua)), configStr, nil
+}
+
+// setUserAgent sets the User-Agent of req from its context, or the
+// package-wide one.
+func setUserAgent(req *http.Request) {
+	ua, _ := req.Context().Value(userAgentKey{}).(string)
+	if ua == "" {
+		userAgentAccess.Lock()
+		ua = userAgent
+		userAgentAccess.Unlock()
+	}
+	req.Header.Set("User-Agent", ua)
+}
@@ -0,0 +1,96 @@
+package main
+
+import "C"
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sagernet/sing-box/include"
+	sjson "github.com/sagernet/sing/common/json"
+)
+
+// taggedOptions is the part of a config that carries tags. It is decoded
+// leniently, before the strict decoding that would stop at the first
+// duplicate with a terse message.
+type taggedOptions struct {
+	Inbounds  []taggedItem `json:"inbounds"`
+	Outbounds []taggedItem `json:"outbounds"`
+	Endpoints []taggedItem `json:"endpoints"`
+	DNS       *struct {
+		Servers []taggedItem `json:"servers"`
+	} `json:"dns"`
+}
+
+type taggedItem struct {
+	Tag string `json:"tag"`
+}
+
+// LibboxValidateConfig checks configJSON without starting it. It returns
+// NULL when the config is valid and otherwise a JSON envelope
+// {"code","message"}: DUPLICATE_TAG, with the offending tags in "tags", when
+// inbounds, outbounds and endpoints, or DNS servers reuse a tag, and
+// INVALID_CONFIG for anything sing-box rejects while decoding, with "line"
+// and "column" (from 1) and the JSON "path", e.g. "outbounds[2].server_port",
+// of the problem when it can be located.
+//
+//export LibboxValidateConfig
+func LibboxValidateConfig(configJSON *C.char) *C.char {
+	if err := validateConfig(C.GoString(configJSON)); err != nil {
+		return err.envelope()
+	}
+	return nil
+}
+
+func validateConfig(configStr string) *codedError {
+	ctx := include.Context(context.Background())
+	var tagged taggedOptions
+	if err := sjson.Unmarshal(stripJSONC([]byte(configStr)), &tagged); err != nil {
+		// the strict decoding fails as well and locates the problem
+		if _, decodeErr := decodeConfig(ctx, configStr); decodeErr != nil {
+			err = decodeErr
+		}
+		return invalidConfigError(err)
+	}
+	var messages, tags []string
+	report := func(kind string, duplicates []string) {
+		if len(duplicates) == 0 {
+			return
+		}
+		messages = append(messages, fmt.Sprintf("duplicate %s tag: %s", kind, strings.Join(duplicates, ", ")))
+		tags = append(tags, duplicates...)
+	}
+	report("inbound", duplicateTags(tagged.Inbounds))
+	// outbounds and endpoints share one namespace
+	report("outbound/endpoint", duplicateTags(append(tagged.Outbounds, tagged.Endpoints...)))
+	if tagged.DNS != nil {
+		report("dns server", duplicateTags(tagged.DNS.Servers))
+	}
+	if len(messages) > 0 {
+		err := newCodedError(errorCodeDuplicateTag, "%s", strings.Join(messages, "; "))
+		err.Tags = tags
+		return err
+	}
+
+	if _, err := decodeConfig(ctx, configStr); err != nil {
+		return invalidConfigError(err)
+	}
+	return nil
+}
+
+// duplicateTags returns every non-empty tag used more than once, once each,
+// in the order of their first repetition.
+func duplicateTags(items []taggedItem) []string {
+	seen := make(map[string]int)
+	var duplicates []string
+	for _, item := range items {
+		if item.Tag == "" {
+			continue
+		}
+		seen[item.Tag]++
+		if seen[item.Tag] == 2 {
+			duplicates = append(duplicates, item.Tag)
+		}
+	}
+	return duplicates
+}
@@ -0,0 +1,129 @@
+package main
+
+import "C"
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sagernet/fswatch"
+	"github.com/sagernet/sing-box/include"
+)
+
+// configWatchDebounce is how long writes to the watched config have to
+// settle before it is reloaded, so an editor saving in several steps
+// triggers a single reload.
+const configWatchDebounce = 500 * time.Millisecond
+
+// configWatcher is the watch set up by LibboxStartWatching, guarded by mu.
+var configWatcher *configWatch
+
+type configWatch struct {
+	watcher *fswatch.Watcher
+	// content is the config the running instance was last started with,
+	// restored when a reload fails to start.
+	content string
+}
+
+// LibboxStartWatching starts like LibboxStartFromFile and then watches path,
+// restarting the service with the file's new content whenever it changes.
+// Every reload is reported to the status callback as a "config_reload" event
+// with "success" and, on failure, "code" and "error". A changed config that doesn't
+// decode leaves the running instance alone; one that fails to start brings
+// the previous config back up. Watching ends with LibboxStop.
+//
+//export LibboxStartWatching
+func LibboxStartWatching(path *C.char, logFD C.longlong) *C.char {
+	configPath, err := filepath.Abs(C.GoString(path))
+	if err != nil {
+		return newCodedError(errorCodeFileRead, "read config error: %s", err).envelope()
+	}
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return newCodedError(errorCodeFileRead, "read config error: %s", err).envelope()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	redirectLog(logFD)
+	if err := startDesktop(string(content), 0); err != nil {
+		return err.envelope()
+	}
+
+	watch := &configWatch{content: string(content)}
+	watch.watcher, err = fswatch.NewWatcher(fswatch.Options{
+		Path:        []string{configPath},
+		Callback:    watch.reload,
+		WaitTimeout: configWatchDebounce,
+	})
+	if err == nil {
+		err = watch.watcher.Start()
+		if err != nil {
+			watch.watcher.Close()
+		}
+	}
+	if err != nil {
+		stopInstance()
+		return newCodedError(errorCodeFileRead, "watch config error: %s", err).envelope()
+	}
+	configWatcher = watch
+	return nil
+}
+
+// stopWatching ends the watch of LibboxStartWatching, if any. It must be
+// called with mu held.
+func stopWatching() {
+	if configWatcher == nil {
+		return
+	}
+	configWatcher.watcher.Close()
+	configWatcher = nil
+}
+
+func (w *configWatch) reload(path string) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		postReloadEvent(newCodedError(errorCodeFileRead, "read config error: %s", err))
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// the watch may have ended while the debounce ran out
+	if configWatcher != w || instance == nil {
+		return
+	}
+	configStr := string(content)
+	if configStr == w.content {
+		return
+	}
+	if _, err := decodeConfig(include.Context(context.Background()), configStr); err != nil {
+		postReloadEvent(invalidConfigError(err))
+		return
+	}
+	if err := stopInstance(); err != nil {
+		postReloadEvent(newCodedError(errorCodeStart, "%s", err))
+		return
+	}
+	if startErr := startDesktop(configStr, 0); startErr != nil {
+		if restoreErr := startDesktop(w.content, 0); restoreErr != nil {
+			// nothing is left running to watch for
+			stopWatching()
+		}
+		postReloadEvent(startErr)
+		return
+	}
+	w.content = configStr
+	postReloadEvent(nil)
+}
+
+func postReloadEvent(err *codedError) {
+	if err != nil {
+		postStatusEvent("config_reload", map[string]any{"success": false, "code": err.Code, "error": err.Message})
+		return
+	}
+	postStatusEvent("config_reload", map[string]any{"success": true})
+}
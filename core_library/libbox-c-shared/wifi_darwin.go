@@ -0,0 +1,57 @@
+//go:build darwin && !ios
+
+package main
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework CoreWLAN -framework Foundation
+#import <CoreWLAN/CoreWLAN.h>
+#include <stdlib.h>
+#include <string.h>
+
+static char *wifi_copy_string(NSString *value) {
+	if (value == nil) {
+		return NULL;
+	}
+	return strdup([value UTF8String]);
+}
+
+// wifi_read_state reports the network of the default Wi-Fi interface. Since
+// macOS 14 the SSID and BSSID are nil unless the process may use location
+// services.
+static void wifi_read_state(char **ssid, char **bssid) {
+	@autoreleasepool {
+		CWInterface *interface = [[CWWiFiClient sharedWiFiClient] interface];
+		*ssid = wifi_copy_string([interface ssid]);
+		*bssid = wifi_copy_string([interface bssid]);
+	}
+}
+*/
+import "C"
+import (
+	"unsafe"
+
+	"github.com/sagernet/sing-box/adapter"
+)
+
+// sing-box has no Wi-Fi monitor of its own on macOS, so the platform reads
+// the state for it.
+const platformWIFIState = true
+
+// readWIFIState reads the current network through CoreWLAN. The state is
+// empty when Wi-Fi is off or not associated, or the process lacks the
+// location permission CoreWLAN requires for it.
+func readWIFIState() adapter.WIFIState {
+	var ssid, bssid *C.char
+	C.wifi_read_state(&ssid, &bssid)
+	defer C.free(unsafe.Pointer(ssid))
+	defer C.free(unsafe.Pointer(bssid))
+	if ssid == nil {
+		return adapter.WIFIState{}
+	}
+	state := adapter.WIFIState{SSID: C.GoString(ssid)}
+	if bssid != nil {
+		state.BSSID = C.GoString(bssid)
+	}
+	return state
+}
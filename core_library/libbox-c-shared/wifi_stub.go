@@ -0,0 +1,14 @@
+//go:build !darwin || ios
+
+package main
+
+import "github.com/sagernet/sing-box/adapter"
+
+// Elsewhere sing-box's own Wi-Fi monitor (NetworkManager, iwd,
+// wpa_supplicant or connman on Linux, WLAN API on Windows) reports the state.
+// iOS has no CoreWLAN and reports none.
+const platformWIFIState = false
+
+func readWIFIState() adapter.WIFIState {
+	return adapter.WIFIState{}
+}
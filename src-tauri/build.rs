@@ -2,6 +2,20 @@ use std::env;
 use std::path::Path;
 use std::process::Command;
 
+/// Rebuild libbox whenever any of its Go sources or the shared C header changes.
+/// The directory itself is not watched because the build writes its archives there.
+fn watch_libbox_sources(libbox_dir: &Path) {
+    let entries = std::fs::read_dir(libbox_dir).expect("Failed to read libbox directory");
+    for entry in entries.flatten() {
+        let path = entry.path();
+        let is_source = path.extension().is_some_and(|ext| ext == "go")
+            || path.file_name().is_some_and(|name| name == "callback.h");
+        if is_source {
+            println!("cargo:rerun-if-changed={}", path.display());
+        }
+    }
+}
+
 fn main() {
     let target_os = env::var("CARGO_CFG_TARGET_OS").unwrap_or_default();
     if target_os == "macos" {
@@ -9,10 +23,7 @@ fn main() {
         let libbox_dir = Path::new(&manifest_dir).join("../core_library/libbox-c-shared");
 
         // Only rebuild if Go files change
-        println!(
-            "cargo:rerun-if-changed={}",
-            libbox_dir.join("main.go").display()
-        );
+        watch_libbox_sources(&libbox_dir);
         println!(
             "cargo:rerun-if-changed={}",
             libbox_dir.join("go.mod").display()
@@ -29,7 +40,7 @@ fn main() {
                 "-buildmode=c-archive",
                 "-o",
                 "libbox.a",
-                "main.go",
+                ".",
             ])
             .env("CGO_ENABLED", "1")
             .status()
@@ -51,6 +62,11 @@ fn main() {
         println!("cargo:rustc-link-arg=Security");
         println!("cargo:rustc-link-arg=-framework");
         println!("cargo:rustc-link-arg=SystemConfiguration");
+        // CoreWLAN backs the Wi-Fi state; cgo LDFLAGS don't reach us through the archive
+        println!("cargo:rustc-link-arg=-framework");
+        println!("cargo:rustc-link-arg=CoreWLAN");
+        println!("cargo:rustc-link-arg=-framework");
+        println!("cargo:rustc-link-arg=Foundation");
 
         println!("cargo:rustc-link-arg=-lresolv");
     } else if target_os == "ios" {
@@ -76,7 +92,7 @@ fn main() {
                 "-buildmode=c-archive",
                 "-o",
                 "libbox_ios.a",
-                "main.go",
+                ".",
             ])
             .env("CGO_ENABLED", "1")
             .env("GOOS", "ios");
@@ -132,7 +148,7 @@ fn main() {
             "-buildmode=c-shared",
         ]);
 
-        cmd.arg("-o").arg(out_dir.join("libbox.so")).arg("main.go");
+        cmd.arg("-o").arg(out_dir.join("libbox.so")).arg(".");
 
         cmd.env("CGO_ENABLED", "1").env("GOOS", "android");
 
@@ -218,10 +234,7 @@ fn main() {
         let libbox_dir = Path::new(&manifest_dir).join("../core_library/libbox-c-shared");
 
         // Only rebuild if Go files change
-        println!(
-            "cargo:rerun-if-changed={}",
-            libbox_dir.join("main.go").display()
-        );
+        watch_libbox_sources(&libbox_dir);
 
         // Build Go library as static archive
         let status = Command::new("go")
@@ -234,7 +247,7 @@ fn main() {
                 "-buildmode=c-archive",
                 "-o",
                 "libbox.a",
-                "main.go",
+                ".",
             ])
             .env("CGO_ENABLED", "1")
             .status()
@@ -252,10 +265,7 @@ fn main() {
         let libbox_dir = Path::new(&manifest_dir).join("../core_library/libbox-c-shared");
 
         // Only rebuild if Go files change
-        println!(
-            "cargo:rerun-if-changed={}",
-            libbox_dir.join("main.go").display()
-        );
+        watch_libbox_sources(&libbox_dir);
 
         let target_triple = env::var("TARGET").unwrap_or_default();
         let goarch = if target_triple.contains("aarch64") {
@@ -280,7 +290,7 @@ fn main() {
                 "-ldflags=-s -w -extldflags '-static' -checklinkname=0 -X github.com/sagernet/sing-box/constant.Version=1.13.6",
                 "-o",
                 "libbox.dll",
-                "main.go",
+                ".",
             ])
             .env("CGO_ENABLED", "1")
             .env("GOARCH", goarch)